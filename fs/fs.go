@@ -47,8 +47,17 @@ type sysFuture struct {
 
 // DirFile is a fs.File that represents a directory entry.
 type DirFile struct {
-	Buffer   *bytes.Buffer
+	Buffer *bytes.Buffer
+	// Reader, if set, is read from instead of Buffer, for listings that are
+	// streamed as they're produced. It's closed with the DirFile if it's an
+	// io.Closer.
+	Reader   io.Reader
 	FileInfo fs.FileInfo
+	// ContentType is the media type of the listing in Buffer. Empty means
+	// application/json.
+	ContentType string
+	// ETag is a validator for the listing, if the store computes one.
+	ETag string
 }
 
 // Stat returns a fs.FileInfo.
@@ -61,11 +70,17 @@ func (df *DirFile) Stat() (fs.FileInfo, error) {
 
 // Read reads from the DirFile and satisfies fs.FS.
 func (df *DirFile) Read(buf []byte) (int, error) {
+	if df.Reader != nil {
+		return df.Reader.Read(buf)
+	}
 	return df.Buffer.Read(buf)
 }
 
-// Close is a no-op but satisfies fs.FS.
+// Close closes Reader if it's an io.Closer and satisfies fs.FS.
 func (df *DirFile) Close() error {
+	if c, ok := df.Reader.(io.Closer); ok {
+		return c.Close()
+	}
 	return nil
 }
 
@@ -7,12 +7,17 @@ import (
 
 // FileInfo describes a file and is returned by Stat.
 type FileInfo struct {
-	Name    string      `json:"name"`
-	IsDir   bool        `json:"is_dir"`
-	Size    int64       `json:"size"`
-	ModTime time.Time   `json:"modtime"`
-	Mode    fs.FileMode `json:"mode"`
-	Files   []FileInfo  `json:"files,omitempty"`
+	Name       string            `json:"name"`
+	IsDir      bool              `json:"is_dir"`
+	Size       int64             `json:"size"`
+	StoredSize int64             `json:"stored_size,omitempty"`
+	ModTime    time.Time         `json:"modtime"`
+	Mode       fs.FileMode       `json:"mode"`
+	Files      []FileInfo        `json:"files,omitempty"`
+	Xattrs     map[string][]byte `json:"xattrs,omitempty"`
+	ETag       string            `json:"etag,omitempty"`
+	Checksum   string            `json:"checksum,omitempty"`
+	Tier       string            `json:"tier,omitempty"`
 }
 
 // Add execute permissions to an fs.FileMode to mirror read permissions.
@@ -294,19 +294,16 @@ func (s *HTTPServer) handlePostFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer f.Close() // nolint:errcheck
-	if s.cfg.UserMaxStorage > 0 {
-		stat, err := s.cfg.FileStore.Stat(u.CharmID, "")
-		if err != nil {
-			log.Printf("cannot stat user storage: %s", err)
-			s.renderError(w)
-			return
-		}
-		if stat.Size()+fh.Size > s.cfg.UserMaxStorage {
-			s.renderCustomError(w, "user storage limit exceeded", http.StatusForbidden)
-			return
-		}
+	err = s.cfg.FileStore.Put(u.CharmID, path, f, fs.FileMode(m))
+	if errors.Is(err, storage.ErrFileTooLarge) {
+		s.renderCustomError(w, "file too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if errors.Is(err, storage.ErrQuotaExceeded) {
+		s.renderCustomError(w, "user storage limit exceeded", http.StatusForbidden)
+		return
 	}
-	if err := s.cfg.FileStore.Put(u.CharmID, path, f, fs.FileMode(m)); err != nil {
+	if err != nil {
 		log.Printf("cannot post file: %s", err)
 		s.renderError(w)
 		return
@@ -335,9 +332,16 @@ func (s *HTTPServer) handleGetFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	switch f.(type) {
+	switch df := f.(type) {
 	case *charmfs.DirFile:
-		w.Header().Set("Content-Type", "application/json")
+		ct := df.ContentType
+		if ct == "" {
+			ct = "application/json"
+		}
+		w.Header().Set("Content-Type", ct)
+		if df.ETag != "" {
+			w.Header().Set("ETag", df.ETag)
+		}
 	default:
 		w.Header().Set("Content-Type", "application/octet-stream")
 		w.Header().Set("Last-Modified", fi.ModTime().Format(http.TimeFormat))
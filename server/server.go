@@ -38,6 +38,7 @@ type Config struct {
 	PublicURL      string `env:"CHARM_SERVER_PUBLIC_URL"`
 	EnableMetrics  bool   `env:"CHARM_SERVER_ENABLE_METRICS" envDefault:"false"`
 	UserMaxStorage int64  `env:"CHARM_SERVER_USER_MAX_STORAGE" envDefault:"0"`
+	FileStoreURL   string `env:"CHARM_SERVER_FILE_STORE"`
 	errorLog       *log.Logger
 	PublicKey      []byte
 	PrivateKey     []byte
@@ -207,11 +208,22 @@ func (srv *Server) init(cfg *Config) {
 		db := sqlite.NewDB(filepath.Join(dp, sqlite.DbName))
 		srv.Config = cfg.WithDB(db)
 	}
+	if cfg.FileStore == nil && cfg.FileStoreURL != "" {
+		fs, err := storage.Open(cfg.FileStoreURL)
+		if err != nil {
+			log.Fatalf("could not open file store: %s", err)
+		}
+		if l, ok := fs.(*lfs.LocalFileStore); ok {
+			l.QuotaBytes = cfg.UserMaxStorage
+		}
+		srv.Config = cfg.WithFileStore(fs)
+	}
 	if cfg.FileStore == nil {
 		fs, err := lfs.NewLocalFileStore(filepath.Join(cfg.DataDir, "files"))
 		if err != nil {
 			log.Fatalf("could not init file path: %s", err)
 		}
+		fs.QuotaBytes = cfg.UserMaxStorage
 		srv.Config = cfg.WithFileStore(fs)
 	}
 	if cfg.Stats == nil {
@@ -0,0 +1,483 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"path"
+
+	charm "github.com/charmbracelet/charm/proto"
+	"golang.org/x/crypto/hkdf"
+)
+
+// ErrDecrypt is used when a file stored by an EncryptedFileStore can't be
+// decrypted, because it's been tampered with or was encrypted under another
+// key.
+var ErrDecrypt = errors.New("unable to decrypt file")
+
+// ErrDataKeyRequired is used when a file encrypted under a caller-provided
+// data key is read without one, or when an EncryptedFileStore without a
+// master key is used without one.
+var ErrDataKeyRequired = errors.New("file requires a data key")
+
+// encryptedMagic starts every file written by an EncryptedFileStore under a
+// key derived from its master key. It's followed by the nonce prefix and the
+// encrypted chunks.
+var encryptedMagic = []byte("CFSE\x01")
+
+// envelopeMagic starts every file written by an EncryptedFileStore under a
+// caller-provided data key. It's followed by the length of the wrapped key as
+// two big-endian bytes, the wrapped key, the nonce prefix and the encrypted
+// chunks.
+var envelopeMagic = []byte("CFSE\x02")
+
+// Files are encrypted in chunks of encryptedChunkSize bytes, so neither Put
+// nor Get hold a whole file in memory. Each chunk is sealed with a nonce made
+// of a random prefix per file, the index of the chunk and whether it's the
+// last one, so chunks can't be reordered, dropped or truncated unnoticed. The
+// last chunk is always shorter than encryptedChunkSize, and empty if the
+// contents fill the chunks before it.
+const (
+	encryptedChunkSize  = 64 << 10
+	encryptedPrefixSize = 7
+	encryptedTagSize    = 16
+)
+
+// EncryptedFileStore is a FileStore that encrypts file contents with AES-GCM
+// before storing them in the FileStore it wraps. Each Charm ID's files are
+// encrypted under a distinct key derived from the master key with HKDF, so a
+// leaked derived key only exposes one user. The Charm ID and path of a file
+// are authenticated with its contents, so a file moved or copied to another
+// path in the wrapped FileStore can't be decrypted. Directory listings are
+// passed through and report the encrypted size of files.
+//
+// For envelope encryption, PutWithKey and GetWithKey encrypt a file under a
+// data key supplied by the caller instead, such as one issued by a key
+// management service, and store the wrapped data key with the file.
+type EncryptedFileStore struct {
+	FileStore
+	masterKey []byte
+}
+
+// NewEncryptedFileStore returns an EncryptedFileStore storing files in fs
+// under keys derived from masterKey, which must be at least 32 bytes. A nil
+// masterKey makes a store that only accepts files with a data key, through
+// PutWithKey and GetWithKey.
+func NewEncryptedFileStore(fs FileStore, masterKey []byte) (*EncryptedFileStore, error) {
+	if masterKey != nil && len(masterKey) < 32 {
+		return nil, fmt.Errorf("master key must be at least 32 bytes, got %d", len(masterKey))
+	}
+	return &EncryptedFileStore{FileStore: fs, masterKey: masterKey}, nil
+}
+
+// Stat returns the fs.FileInfo of the file at path for the Charm ID, with the
+// size of its decrypted contents.
+func (efs *EncryptedFileStore) Stat(charmID string, path string) (fs.FileInfo, error) {
+	info, err := efs.FileStore.Stat(charmID, path)
+	if err != nil || info.IsDir() {
+		return info, err
+	}
+	return plaintextInfo{info}, nil
+}
+
+// Get returns an fs.File with the decrypted contents of the file at path for
+// the Charm ID. The contents are decrypted as they're read, and a read fails
+// with ErrDecrypt if they've been tampered with.
+func (efs *EncryptedFileStore) Get(charmID string, path string) (fs.File, error) {
+	f, err := efs.FileStore.Get(charmID, path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		return f, err
+	}
+	df, err := efs.open(f, charmID, path, info)
+	if err != nil {
+		f.Close() // nolint:errcheck
+		return nil, err
+	}
+	return df, nil
+}
+
+func (efs *EncryptedFileStore) open(f fs.File, charmID, path string, info fs.FileInfo) (*decryptedFile, error) {
+	magic := make([]byte, len(encryptedMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return nil, ErrDecrypt
+	}
+	if bytes.Equal(magic, envelopeMagic) {
+		return nil, ErrDataKeyRequired
+	}
+	if !bytes.Equal(magic, encryptedMagic) {
+		return nil, ErrDecrypt
+	}
+	gcm, err := efs.cipher(charmID)
+	if err != nil {
+		return nil, err
+	}
+	r, err := openStream(f, gcm, fileAD(charmID, path))
+	if err != nil {
+		return nil, err
+	}
+	return &decryptedFile{Reader: r, f: f, info: plaintextInfo{info}}, nil
+}
+
+// Put encrypts the data from r and stores it at path for the Charm ID.
+func (efs *EncryptedFileStore) Put(charmID string, path string, r io.Reader, mode fs.FileMode) error {
+	if mode.IsDir() {
+		return efs.FileStore.Put(charmID, path, r, mode)
+	}
+	gcm, err := efs.cipher(charmID)
+	if err != nil {
+		return err
+	}
+	sr, err := sealStream(r, gcm, encryptedMagic, fileAD(charmID, path))
+	if err != nil {
+		return err
+	}
+	return efs.FileStore.Put(charmID, path, sr, mode)
+}
+
+// List returns the entries of the directory at path for the Charm ID, with the
+// encrypted size of files.
+func (efs *EncryptedFileStore) List(charmID string, path string) ([]charm.FileInfo, error) {
+	return List(efs.FileStore, charmID, path)
+}
+
+// CopyFrom copies the file or directory at srcPath for srcCharmID in the src
+// FileStore to the given path for the Charm ID, encrypting it.
+func (efs *EncryptedFileStore) CopyFrom(charmID string, path string, src FileStore, srcCharmID string, srcPath string) error {
+	return Copy(efs, charmID, path, src, srcCharmID, srcPath)
+}
+
+// DataKey is a data key supplied by the caller to encrypt a single file with.
+type DataKey struct {
+	// Plaintext is the 32 byte AES-256 key the file is encrypted under. It's
+	// never stored.
+	Plaintext []byte
+	// Wrapped is the data key as encrypted by the caller's key management
+	// service. It's stored alongside the file and handed back to unwrap the
+	// key when the file is read.
+	Wrapped []byte
+}
+
+// PutWithKey encrypts the data from r under the data key and stores it at
+// path for the Charm ID, along with the wrapped key. The file can then only
+// be read with GetWithKey. Stat can't see the length of the wrapped key, so
+// the size it reports for the file includes it.
+func (efs *EncryptedFileStore) PutWithKey(charmID string, path string, r io.Reader, mode fs.FileMode, key DataKey) error {
+	if mode.IsDir() {
+		return efs.FileStore.Put(charmID, path, r, mode)
+	}
+	if len(key.Wrapped) == 0 || len(key.Wrapped) > 0xffff {
+		return fmt.Errorf("wrapped data key must be 1 to 65535 bytes, got %d", len(key.Wrapped))
+	}
+	gcm, err := dataKeyCipher(key.Plaintext)
+	if err != nil {
+		return err
+	}
+	hdr := make([]byte, len(envelopeMagic)+2, len(envelopeMagic)+2+len(key.Wrapped))
+	copy(hdr, envelopeMagic)
+	binary.BigEndian.PutUint16(hdr[len(envelopeMagic):], uint16(len(key.Wrapped)))
+	hdr = append(hdr, key.Wrapped...)
+	sr, err := sealStream(r, gcm, hdr, envelopeAD(charmID, path, key.Wrapped))
+	if err != nil {
+		return err
+	}
+	return efs.FileStore.Put(charmID, path, sr, mode)
+}
+
+// GetWithKey returns an fs.File with the decrypted contents of the file at
+// path for the Charm ID that was stored with PutWithKey. unwrap is called with
+// the wrapped data key stored with the file and returns the plaintext key.
+// Files that were stored without a data key are decrypted like Get does.
+func (efs *EncryptedFileStore) GetWithKey(charmID string, path string, unwrap func(wrapped []byte) ([]byte, error)) (fs.File, error) {
+	f, err := efs.FileStore.Get(charmID, path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		return f, err
+	}
+	df, err := efs.openWithKey(f, charmID, path, info, unwrap)
+	if err != nil {
+		f.Close() // nolint:errcheck
+	}
+	if errors.Is(err, errNotEnvelope) {
+		return efs.Get(charmID, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return df, nil
+}
+
+// errNotEnvelope is used when a file read with GetWithKey wasn't stored with a
+// data key.
+var errNotEnvelope = errors.New("file wasn't stored with a data key")
+
+func (efs *EncryptedFileStore) openWithKey(f fs.File, charmID, path string, info fs.FileInfo, unwrap func(wrapped []byte) ([]byte, error)) (*decryptedFile, error) {
+	hdr := make([]byte, len(envelopeMagic)+2)
+	if _, err := io.ReadFull(f, hdr); err != nil || !bytes.HasPrefix(hdr, envelopeMagic) {
+		return nil, errNotEnvelope
+	}
+	wrapped := make([]byte, binary.BigEndian.Uint16(hdr[len(envelopeMagic):]))
+	if _, err := io.ReadFull(f, wrapped); err != nil {
+		return nil, ErrDecrypt
+	}
+	key, err := unwrap(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := dataKeyCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	r, err := openStream(f, gcm, envelopeAD(charmID, path, wrapped))
+	if err != nil {
+		return nil, err
+	}
+	size := plaintextSize(info.Size() - int64(len(hdr)+len(wrapped)+encryptedPrefixSize))
+	return &decryptedFile{Reader: r, f: f, info: sizedInfo{info, size}}, nil
+}
+
+// fileAD returns the additional data the chunks of the file at path for the
+// Charm ID are authenticated with.
+func fileAD(charmID, p string) []byte {
+	return []byte(charmID + "\x00" + path.Clean("/"+p))
+}
+
+// envelopeAD returns the additional data the chunks of the file at path for
+// the Charm ID stored with a data key are authenticated with. The wrapped key
+// is authenticated so it can't be swapped for another.
+func envelopeAD(charmID, p string, wrapped []byte) []byte {
+	return append(append(fileAD(charmID, p), 0), wrapped...)
+}
+
+// dataKeyCipher returns the AEAD for a caller-provided data key.
+func dataKeyCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("data key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// cipher returns the AEAD for the Charm ID's derived key.
+func (efs *EncryptedFileStore) cipher(charmID string) (cipher.AEAD, error) {
+	if efs.masterKey == nil {
+		return nil, ErrDataKeyRequired
+	}
+	key := make([]byte, 32)
+	kdf := hkdf.New(sha256.New, efs.masterKey, nil, []byte("charm file store "+charmID))
+	if _, err := io.ReadFull(kdf, key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// plaintextInfo reports the decrypted size of an encrypted file.
+type plaintextInfo struct {
+	fs.FileInfo
+}
+
+func (pi plaintextInfo) Size() int64 {
+	return plaintextSize(pi.FileInfo.Size() - int64(len(encryptedMagic)+encryptedPrefixSize))
+}
+
+// plaintextSize returns the size of the contents of n bytes of encrypted
+// chunks.
+func plaintextSize(n int64) int64 {
+	if n < encryptedTagSize {
+		return 0
+	}
+	const sealed = encryptedChunkSize + encryptedTagSize
+	full, last := n/sealed, n%sealed
+	if last < encryptedTagSize {
+		return full * encryptedChunkSize
+	}
+	return full*encryptedChunkSize + last - encryptedTagSize
+}
+
+// sizedInfo reports the size of the decrypted contents of a file.
+type sizedInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (si sizedInfo) Size() int64 {
+	return si.size
+}
+
+// decryptedFile is the fs.File returned by EncryptedFileStore.Get.
+type decryptedFile struct {
+	io.Reader
+	f    fs.File
+	info fs.FileInfo
+}
+
+func (df *decryptedFile) Stat() (fs.FileInfo, error) {
+	return df.info, nil
+}
+
+func (df *decryptedFile) Close() error {
+	return df.f.Close()
+}
+
+// chunkNonce returns the nonce of chunk i of a file with the nonce prefix.
+func chunkNonce(nonce, prefix []byte, i uint32, last bool) []byte {
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptedPrefixSize:], i)
+	nonce[len(nonce)-1] = 0
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// sealStream returns a reader of hdr, a random nonce prefix and the contents
+// of r encrypted in chunks authenticated with ad.
+func sealStream(r io.Reader, aead cipher.AEAD, hdr, ad []byte) (io.Reader, error) {
+	prefix := make([]byte, encryptedPrefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(hdr)+encryptedPrefixSize)
+	out = append(append(out, hdr...), prefix...)
+	return &sealReader{
+		r:      r,
+		aead:   aead,
+		ad:     ad,
+		prefix: prefix,
+		nonce:  make([]byte, aead.NonceSize()),
+		pt:     make([]byte, encryptedChunkSize),
+		ct:     make([]byte, 0, encryptedChunkSize+encryptedTagSize),
+		out:    out,
+	}, nil
+}
+
+// sealReader encrypts the contents of r one chunk at a time as it's read.
+type sealReader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	ad     []byte
+	prefix []byte
+	nonce  []byte
+	pt     []byte
+	ct     []byte
+	out    []byte
+	i      uint32
+	done   bool
+}
+
+func (sr *sealReader) Read(p []byte) (int, error) {
+	for len(sr.out) == 0 {
+		if sr.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(sr.r, sr.pt)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return 0, err
+		}
+		if sr.i == math.MaxUint32 && !last {
+			return 0, fmt.Errorf("file too large to encrypt")
+		}
+		nonce := chunkNonce(sr.nonce, sr.prefix, sr.i, last)
+		sr.out = sr.aead.Seal(sr.ct[:0], nonce, sr.pt[:n], sr.ad)
+		sr.i++
+		sr.done = last
+	}
+	n := copy(p, sr.out)
+	sr.out = sr.out[n:]
+	return n, nil
+}
+
+// openStream returns a reader of the decrypted contents of the nonce prefix
+// and encrypted chunks read from r, which are authenticated with ad. The first
+// chunk is decrypted right away, so a file encrypted under another key or for
+// another path is reported before it's read.
+func openStream(r io.Reader, aead cipher.AEAD, ad []byte) (io.Reader, error) {
+	or := &openReader{
+		r:      r,
+		aead:   aead,
+		ad:     ad,
+		prefix: make([]byte, encryptedPrefixSize),
+		nonce:  make([]byte, aead.NonceSize()),
+		ct:     make([]byte, encryptedChunkSize+encryptedTagSize),
+		pt:     make([]byte, 0, encryptedChunkSize),
+	}
+	if _, err := io.ReadFull(r, or.prefix); err != nil {
+		return nil, ErrDecrypt
+	}
+	if err := or.next(); err != nil {
+		return nil, err
+	}
+	return or, nil
+}
+
+// openReader decrypts the chunks read from r one at a time as it's read.
+type openReader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	ad     []byte
+	prefix []byte
+	nonce  []byte
+	ct     []byte
+	pt     []byte
+	out    []byte
+	i      uint32
+	done   bool
+}
+
+func (or *openReader) Read(p []byte) (int, error) {
+	for len(or.out) == 0 {
+		if or.done {
+			return 0, io.EOF
+		}
+		if err := or.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, or.out)
+	or.out = or.out[n:]
+	return n, nil
+}
+
+// next decrypts the next chunk. Only the last chunk is shorter than a full
+// one, so running out of chunks before it means the file was truncated.
+func (or *openReader) next() error {
+	n, err := io.ReadFull(or.r, or.ct)
+	last := err == io.ErrUnexpectedEOF
+	switch {
+	case err == io.EOF:
+		return ErrDecrypt
+	case err != nil && !last:
+		return err
+	}
+	nonce := chunkNonce(or.nonce, or.prefix, or.i, last)
+	pt, err := or.aead.Open(or.pt[:0], nonce, or.ct[:n], or.ad)
+	if err != nil {
+		return ErrDecrypt
+	}
+	or.out = pt
+	or.i++
+	or.done = last
+	return nil
+}
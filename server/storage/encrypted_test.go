@@ -0,0 +1,211 @@
+package storage_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/charmbracelet/charm/server/storage"
+	memstorage "github.com/charmbracelet/charm/server/storage/mem"
+)
+
+func TestEncryptedFileStorePerUserKeys(t *testing.T) {
+	mfs := memstorage.NewMemFileStore()
+	efs, err := storage.NewEncryptedFileStore(mfs, bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := "the same secret"
+	for _, id := range []string{"alice", "bob"} {
+		if err := efs.Put(id, "/secret.txt", bytes.NewBufferString(plaintext), fs.FileMode(0o600)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	raw := func(id string) []byte {
+		f, err := mfs.Get(id, "/secret.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close() // nolint:errcheck
+		b, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	alice, bob := raw("alice"), raw("bob")
+	if bytes.Equal(alice, bob) {
+		t.Fatal("expected identical plaintext to produce different ciphertext")
+	}
+	if bytes.Contains(alice, []byte(plaintext)) {
+		t.Fatal("expected stored data to be encrypted")
+	}
+
+	for _, id := range []string{"alice", "bob"} {
+		f, err := efs.Get(id, "/secret.txt")
+		if err != nil {
+			t.Fatalf("expected no error decrypting %s's file, %v", id, err)
+		}
+		b, err := io.ReadAll(f)
+		f.Close() // nolint:errcheck
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != plaintext {
+			t.Fatalf("expected %s's plaintext %q, got %q", id, plaintext, b)
+		}
+		info, err := efs.Stat(id, "/secret.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != int64(len(plaintext)) {
+			t.Fatalf("expected plaintext size %d, got %d", len(plaintext), info.Size())
+		}
+	}
+
+	// Alice's ciphertext can't be read with Bob's key.
+	if err := mfs.Put("bob", "/stolen.txt", bytes.NewReader(alice), fs.FileMode(0o600)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := efs.Get("bob", "/stolen.txt"); !errors.Is(err, storage.ErrDecrypt) {
+		t.Fatalf("expected ErrDecrypt reading another user's ciphertext, got %v", err)
+	}
+}
+
+func TestEncryptedFileStoreDataKey(t *testing.T) {
+	mfs := memstorage.NewMemFileStore()
+	efs, err := storage.NewEncryptedFileStore(mfs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyA := storage.DataKey{Plaintext: bytes.Repeat([]byte{1}, 32), Wrapped: []byte("wrapped-a")}
+	keyB := bytes.Repeat([]byte{2}, 32)
+	plaintext := "envelope secret"
+	if err := efs.PutWithKey("alice", "/secret.txt", bytes.NewBufferString(plaintext), fs.FileMode(0o600), keyA); err != nil {
+		t.Fatal(err)
+	}
+	if err := efs.Put("alice", "/other.txt", bytes.NewBufferString(plaintext), fs.FileMode(0o600)); !errors.Is(err, storage.ErrDataKeyRequired) {
+		t.Fatalf("expected ErrDataKeyRequired without a master key, got %v", err)
+	}
+
+	var gotWrapped []byte
+	f, err := efs.GetWithKey("alice", "/secret.txt", func(wrapped []byte) ([]byte, error) {
+		gotWrapped = wrapped
+		return keyA.Plaintext, nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error decrypting with key A, %v", err)
+	}
+	b, err := io.ReadAll(f)
+	f.Close() // nolint:errcheck
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != plaintext {
+		t.Fatalf("expected plaintext %q, got %q", plaintext, b)
+	}
+	if !bytes.Equal(gotWrapped, keyA.Wrapped) {
+		t.Fatalf("expected the stored wrapped key %q, got %q", keyA.Wrapped, gotWrapped)
+	}
+	if info, err := f.Stat(); err != nil || info.Size() != int64(len(plaintext)) {
+		t.Fatalf("expected the plaintext size, got %v, %v", info, err)
+	}
+
+	_, err = efs.GetWithKey("alice", "/secret.txt", func([]byte) ([]byte, error) { return keyB, nil })
+	if !errors.Is(err, storage.ErrDecrypt) {
+		t.Fatalf("expected ErrDecrypt with the wrong key, got %v", err)
+	}
+	if _, err := efs.Get("alice", "/secret.txt"); !errors.Is(err, storage.ErrDataKeyRequired) {
+		t.Fatalf("expected ErrDataKeyRequired reading without a key, got %v", err)
+	}
+}
+
+func TestEncryptedFileStorePathBound(t *testing.T) {
+	mfs := memstorage.NewMemFileStore()
+	efs, err := storage.NewEncryptedFileStore(mfs, bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := efs.Put("alice", "/a.txt", bytes.NewBufferString("for a only"), fs.FileMode(0o600)); err != nil {
+		t.Fatal(err)
+	}
+	f, err := mfs.Get("alice", "/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(f)
+	f.Close() // nolint:errcheck
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.Put("alice", "/b.txt", bytes.NewReader(raw), fs.FileMode(0o600)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := efs.Get("alice", "/b.txt"); !errors.Is(err, storage.ErrDecrypt) {
+		t.Fatalf("expected ErrDecrypt reading a file moved to another path, got %v", err)
+	}
+	if _, err := efs.Get("alice", "a.txt"); err != nil {
+		t.Fatalf("expected an unclean path to name the same file, %v", err)
+	}
+}
+
+func TestEncryptedFileStoreChunks(t *testing.T) {
+	const chunk = 64 << 10
+	mfs := memstorage.NewMemFileStore()
+	efs, err := storage.NewEncryptedFileStore(mfs, bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{0, 1, chunk - 1, chunk, 3*chunk + 5} {
+		plaintext := bytes.Repeat([]byte{'x'}, n)
+		if err := efs.Put("alice", "/big.bin", bytes.NewReader(plaintext), fs.FileMode(0o600)); err != nil {
+			t.Fatal(err)
+		}
+		f, err := efs.Get("alice", "/big.bin")
+		if err != nil {
+			t.Fatalf("expected no error decrypting %d bytes, %v", n, err)
+		}
+		b, err := io.ReadAll(f)
+		f.Close() // nolint:errcheck
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, plaintext) {
+			t.Fatalf("expected %d bytes of plaintext back, got %d", n, len(b))
+		}
+		info, err := efs.Stat("alice", "/big.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != int64(n) {
+			t.Fatalf("expected plaintext size %d, got %d", n, info.Size())
+		}
+	}
+
+	// Dropping the last chunks is noticed.
+	f, err := mfs.Get("alice", "/big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(f)
+	f.Close() // nolint:errcheck
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := len("CFSE\x01") + 7
+	truncated := raw[:header+2*(chunk+16)]
+	if err := mfs.Put("alice", "/big.bin", bytes.NewReader(truncated), fs.FileMode(0o600)); err != nil {
+		t.Fatal(err)
+	}
+	f, err = efs.Get("alice", "/big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close() // nolint:errcheck
+	if _, err := io.ReadAll(f); !errors.Is(err, storage.ErrDecrypt) {
+		t.Fatalf("expected ErrDecrypt reading a truncated file, got %v", err)
+	}
+}
@@ -0,0 +1,10 @@
+package storage
+
+import "time"
+
+// SetNow makes RateLimitedFileStore read the time from f until the returned
+// func is called.
+func SetNow(f func() time.Time) func() {
+	now = f
+	return func() { now = time.Now }
+}
@@ -0,0 +1,115 @@
+package localstorage
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// accessDir is the top-level directory last access times are recorded in,
+// as the modification time of an empty file per Charm ID.
+const accessDir = ".access"
+
+// freeSpace returns the number of bytes available to the store at path. It's a
+// variable so tests can simulate a filling disk.
+var freeSpace = diskFree
+
+// touch records that the Charm ID's data was just accessed.
+func (lfs *LocalFileStore) touch(charmID string) error {
+	if !lfs.TrackAccess {
+		return nil
+	}
+	ap := filepath.Join(lfs.root(), accessDir, charmID)
+	now := time.Now()
+	err := os.Chtimes(ap, now, now)
+	if !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ap), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(ap, nil, 0o600)
+}
+
+// LastAccess returns when the Charm ID's data was last read or written. For
+// Charm IDs without a recorded access, the modification time of their
+// directory is returned.
+func (lfs *LocalFileStore) LastAccess(charmID string) (time.Time, error) {
+	defer lfs.op()()
+	info, err := os.Stat(filepath.Join(lfs.root(), accessDir, charmID))
+	if os.IsNotExist(err) {
+		info, err = os.Stat(filepath.Join(lfs.root(), charmID))
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// EvictLRU removes the data of the least recently accessed Charm IDs until
+// the store has at least targetFreeBytes available, and returns the evicted
+// Charm IDs. Access times are recorded when TrackAccess is set.
+func (lfs *LocalFileStore) EvictLRU(targetFreeBytes int64) ([]string, error) {
+	defer lfs.op()()
+	ids, err := lfs.ListCharmIDs()
+	if err != nil {
+		return nil, err
+	}
+	accessed := make(map[string]time.Time, len(ids))
+	for _, id := range ids {
+		if accessed[id], err = lfs.LastAccess(id); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return accessed[ids[i]].Before(accessed[ids[j]])
+	})
+	evicted := make([]string, 0)
+	for _, id := range ids {
+		free, err := freeSpace(lfs.root())
+		if err != nil {
+			return evicted, err
+		}
+		if free >= targetFreeBytes {
+			break
+		}
+		if err := lfs.evict(id); err != nil {
+			return evicted, err
+		}
+		evicted = append(evicted, id)
+	}
+	return evicted, nil
+}
+
+// evict removes everything stored for the Charm ID. Its files are deleted
+// with Delete, so writes to them through the store are waited for, before the
+// rest of its data is removed.
+func (lfs *LocalFileStore) evict(charmID string) error {
+	defer lfs.resetUsage(charmID)
+	root := filepath.Join(lfs.root(), charmID)
+	des, err := os.ReadDir(root)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, de := range des {
+		// Staging files belong to writes, which clean them up.
+		if isStaging(de.Name()) {
+			continue
+		}
+		if err := lfs.Delete(charmID, de.Name()); err != nil {
+			return err
+		}
+	}
+	// Anything written since it was listed is kept, along with the directory.
+	os.Remove(root) // nolint:errcheck
+	for _, dir := range charmIDDirs {
+		if dir == "" {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(lfs.root(), dir, charmID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package localstorage
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestEvictLRU(t *testing.T) {
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.TrackAccess = true
+	stale := uuid.New().String()
+	active := uuid.New().String()
+	data := bytes.Repeat([]byte("x"), 1000)
+	for _, id := range []string{active, stale} {
+		if err := lfs.Put(id, "/data.bin", bytes.NewReader(data), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+		// Backdate so the Get below is clearly the latest access.
+		old := time.Now().Add(-time.Hour)
+		if err := os.Chtimes(filepath.Join(lfs.Path, accessDir, id), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Reading the active user's data is what protects it.
+	f, err := lfs.Get(active, "/data.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close() // nolint:errcheck
+
+	// Simulate a 2500 byte disk holding only the store.
+	freeSpace = func(string) (int64, error) {
+		var used int64
+		for _, id := range []string{active, stale} {
+			n, err := lfs.Usage(id)
+			if err != nil {
+				return 0, err
+			}
+			used += n
+		}
+		return 2500 - used, nil
+	}
+	defer func() { freeSpace = diskFree }()
+
+	evicted, err := lfs.EvictLRU(1000)
+	if err != nil {
+		t.Fatalf("expected no error evicting, %v", err)
+	}
+	if len(evicted) != 1 || evicted[0] != stale {
+		t.Fatalf("expected only %s to be evicted, got %v", stale, evicted)
+	}
+	if _, err := lfs.Stat(stale, "/data.bin"); err == nil {
+		t.Fatal("expected stale user's data to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(lfs.Path, stale)); !os.IsNotExist(err) {
+		t.Fatalf("expected stale user's directory to be removed, got %v", err)
+	}
+	if _, err := lfs.Stat(active, "/data.bin"); err != nil {
+		t.Fatalf("expected active user's data to remain, %v", err)
+	}
+
+	evicted, err = lfs.EvictLRU(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evicted) != 0 {
+		t.Fatalf("expected nothing evicted with enough free space, got %v", evicted)
+	}
+}
+
+func TestEvictLRUDuringWrite(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/data.bin", bytes.NewBufferString("old"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	freeSpace = func(string) (int64, error) {
+		n, err := lfs.Usage(charmID)
+		return 1000 - n, err
+	}
+	defer func() { freeSpace = diskFree }()
+
+	// Once the first byte is read, the write is under way.
+	pr, pw := io.Pipe()
+	put := make(chan error, 1)
+	go func() {
+		put <- lfs.Put(charmID, "/data.bin", pr, fs.FileMode(0o644))
+	}()
+	if _, err := pw.Write([]byte("n")); err != nil {
+		t.Fatal(err)
+	}
+	evict := make(chan error, 1)
+	go func() {
+		_, err := lfs.EvictLRU(1000)
+		evict <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	pw.Write([]byte("ew")) // nolint:errcheck
+	pw.Close()             // nolint:errcheck
+	if err := <-put; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-evict; err != nil {
+		t.Fatalf("expected no error evicting, %v", err)
+	}
+	// The eviction waited for the write instead of letting it land after.
+	if _, err := lfs.Stat(charmID, "/data.bin"); err == nil {
+		t.Fatal("expected the written file to be evicted")
+	}
+}
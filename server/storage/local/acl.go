@@ -0,0 +1,319 @@
+package localstorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// aclDir is the top-level directory ACLs are kept in, as a JSON file per Charm
+// ID mapping paths to their ACL.
+const aclDir = ".acls"
+
+// SetACL sets the ACL granting other Charm IDs access to the file or directory
+// at path for the Charm ID, replacing any it had. An ACL on a directory covers
+// everything below it that doesn't have its own. An empty ACL removes it.
+func (lfs *LocalFileStore) SetACL(charmID, path string, acl storage.ACL) error {
+	defer lfs.op()()
+	if _, err := lfs.Stat(charmID, path); err != nil {
+		return err
+	}
+	lfs.aclMu.Lock()
+	defer lfs.aclMu.Unlock()
+	acls, err := lfs.readACLs(charmID)
+	if err != nil {
+		return err
+	}
+	if len(acl.Read) == 0 && len(acl.Write) == 0 {
+		delete(acls, aclKey(path))
+	} else {
+		acls[aclKey(path)] = acl
+	}
+	return lfs.writeACLs(charmID, acls)
+}
+
+// GetACL returns the ACL set on the file or directory at path for the Charm
+// ID, which is empty if it has none of its own.
+func (lfs *LocalFileStore) GetACL(charmID, path string) (storage.ACL, error) {
+	defer lfs.op()()
+	lfs.aclMu.Lock()
+	defer lfs.aclMu.Unlock()
+	acls, err := lfs.readACLs(charmID)
+	if err != nil {
+		return storage.ACL{}, err
+	}
+	return acls[aclKey(path)], nil
+}
+
+// CheckAccess reports whether requesterID may perform op on path for the
+// Charm ID. The Charm ID itself may always access its data. Other Charm IDs
+// need to be granted access by the ACL of the path or, if it has none, of its
+// nearest parent with one.
+func (lfs *LocalFileStore) CheckAccess(requesterID, charmID, path string, op storage.Op) (bool, error) {
+	defer lfs.op()()
+	if requesterID == charmID {
+		return true, nil
+	}
+	lfs.aclMu.Lock()
+	acls, err := lfs.readACLs(charmID)
+	lfs.aclMu.Unlock()
+	if err != nil {
+		return false, err
+	}
+	for p := aclKey(path); ; p = aclParent(p) {
+		if acl, ok := acls[p]; ok {
+			return acl.Allows(requesterID, op), nil
+		}
+		if p == "/" {
+			return false, nil
+		}
+	}
+}
+
+// GetAs is like Get on behalf of requesterID, failing with
+// storage.ErrAccessDenied unless it may read path for the Charm ID.
+func (lfs *LocalFileStore) GetAs(requesterID, charmID, path string) (fs.File, error) {
+	defer lfs.op()()
+	if err := lfs.requireAccess(requesterID, charmID, path, storage.OpRead); err != nil {
+		return nil, err
+	}
+	return lfs.Get(charmID, path)
+}
+
+// PutAs is like Put on behalf of requesterID, failing with
+// storage.ErrAccessDenied unless it may write path for the Charm ID.
+func (lfs *LocalFileStore) PutAs(requesterID, charmID, path string, r io.Reader, mode fs.FileMode) error {
+	defer lfs.op()()
+	if err := lfs.requireAccess(requesterID, charmID, path, storage.OpWrite); err != nil {
+		return err
+	}
+	return lfs.Put(charmID, path, r, mode)
+}
+
+// DeleteAs is like Delete on behalf of requesterID, failing with
+// storage.ErrAccessDenied unless it may delete path for the Charm ID.
+func (lfs *LocalFileStore) DeleteAs(requesterID, charmID, path string) error {
+	defer lfs.op()()
+	if err := lfs.requireAccess(requesterID, charmID, path, storage.OpDelete); err != nil {
+		return err
+	}
+	return lfs.Delete(charmID, path)
+}
+
+// GetMultipleAs is like GetMultiple on behalf of requesterID, failing with
+// storage.ErrAccessDenied unless it may read every one of paths for the Charm
+// ID.
+func (lfs *LocalFileStore) GetMultipleAs(requesterID, charmID string, paths []string) (io.ReadCloser, error) {
+	defer lfs.op()()
+	for _, p := range paths {
+		if err := lfs.requireAccess(requesterID, charmID, p, storage.OpRead); err != nil {
+			return nil, err
+		}
+	}
+	return lfs.GetMultiple(charmID, paths)
+}
+
+// PutAtAs is like PutAt on behalf of requesterID, failing with
+// storage.ErrAccessDenied unless it may write path for the Charm ID.
+func (lfs *LocalFileStore) PutAtAs(requesterID, charmID, path string, offset int64, r io.Reader) error {
+	defer lfs.op()()
+	if err := lfs.requireAccess(requesterID, charmID, path, storage.OpWrite); err != nil {
+		return err
+	}
+	return lfs.PutAt(charmID, path, offset, r)
+}
+
+// PutDeltaAs is like PutDelta on behalf of requesterID, failing with
+// storage.ErrAccessDenied unless it may write path for the Charm ID.
+func (lfs *LocalFileStore) PutDeltaAs(requesterID, charmID, path string, have []string, r io.Reader) error {
+	defer lfs.op()()
+	if err := lfs.requireAccess(requesterID, charmID, path, storage.OpWrite); err != nil {
+		return err
+	}
+	return lfs.PutDelta(charmID, path, have, r)
+}
+
+// CopyRangeAs is like CopyRange on behalf of requesterID, failing with
+// storage.ErrAccessDenied unless it may read srcPath and write dstPath for
+// the Charm ID.
+func (lfs *LocalFileStore) CopyRangeAs(requesterID, charmID, srcPath string, srcOffset, length int64, dstPath string, dstOffset int64) error {
+	defer lfs.op()()
+	if err := lfs.requireAccess(requesterID, charmID, srcPath, storage.OpRead); err != nil {
+		return err
+	}
+	if err := lfs.requireAccess(requesterID, charmID, dstPath, storage.OpWrite); err != nil {
+		return err
+	}
+	return lfs.CopyRange(charmID, srcPath, srcOffset, length, dstPath, dstOffset)
+}
+
+// SwapAs is like Swap on behalf of requesterID, failing with
+// storage.ErrAccessDenied unless it may write both paths for the Charm ID.
+func (lfs *LocalFileStore) SwapAs(requesterID, charmID, pathA, pathB string) error {
+	defer lfs.op()()
+	for _, p := range []string{pathA, pathB} {
+		if err := lfs.requireAccess(requesterID, charmID, p, storage.OpWrite); err != nil {
+			return err
+		}
+	}
+	return lfs.Swap(charmID, pathA, pathB)
+}
+
+func (lfs *LocalFileStore) requireAccess(requesterID, charmID, path string, op storage.Op) error {
+	ok, err := lfs.CheckAccess(requesterID, charmID, path, op)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s: %w", path, storage.ErrAccessDenied)
+	}
+	return nil
+}
+
+// deleteACLs removes the ACLs of path for the Charm ID and everything below
+// it.
+func (lfs *LocalFileStore) deleteACLs(charmID, path string) error {
+	lfs.aclMu.Lock()
+	defer lfs.aclMu.Unlock()
+	acls, err := lfs.readACLs(charmID)
+	if err != nil || len(acls) == 0 {
+		return err
+	}
+	key := aclKey(path)
+	for p := range acls {
+		if p == key || strings.HasPrefix(p, strings.TrimSuffix(key, "/")+"/") {
+			delete(acls, p)
+		}
+	}
+	return lfs.writeACLs(charmID, acls)
+}
+
+// renameGrants replaces oldID with newID in the ACLs of every Charm ID. If
+// one can't be rewritten, those already rewritten are put back.
+func (lfs *LocalFileStore) renameGrants(oldID, newID string) error {
+	lfs.aclMu.Lock()
+	defer lfs.aclMu.Unlock()
+	des, err := os.ReadDir(filepath.Join(lfs.root(), aclDir))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var undos []func()
+	undo := func() {
+		for i := len(undos) - 1; i >= 0; i-- {
+			undos[i]()
+		}
+	}
+	for _, de := range des {
+		// Skip the temporary files of writes in progress.
+		if de.IsDir() || strings.HasPrefix(de.Name(), ".") {
+			continue
+		}
+		charmID := de.Name()
+		acls, err := lfs.readACLs(charmID)
+		if err != nil {
+			undo()
+			return err
+		}
+		renamed := make(map[string]storage.ACL, len(acls))
+		changed := false
+		for p, acl := range acls {
+			read, r := replaceID(acl.Read, oldID, newID)
+			write, w := replaceID(acl.Write, oldID, newID)
+			renamed[p] = storage.ACL{Read: read, Write: write}
+			changed = changed || r || w
+		}
+		if !changed {
+			continue
+		}
+		if err := lfs.writeACLs(charmID, renamed); err != nil {
+			undo()
+			return err
+		}
+		undos = append(undos, func() {
+			lfs.writeACLs(charmID, acls) // nolint:errcheck
+		})
+	}
+	return nil
+}
+
+// replaceID returns a copy of ids with oldID replaced by newID, and whether
+// oldID was in ids.
+func replaceID(ids []string, oldID, newID string) ([]string, bool) {
+	out := make([]string, len(ids))
+	found := false
+	for i, id := range ids {
+		if id == oldID {
+			id, found = newID, true
+		}
+		out[i] = id
+	}
+	if len(out) == 0 {
+		out = nil
+	}
+	return out, found
+}
+
+// aclKey returns the key of path in a Charm ID's ACLs.
+func aclKey(p string) string {
+	return path.Clean("/" + filepath.ToSlash(p))
+}
+
+// aclParent returns the key of the parent of the path with key p.
+func aclParent(p string) string {
+	return path.Dir(p)
+}
+
+func (lfs *LocalFileStore) readACLs(charmID string) (map[string]storage.ACL, error) {
+	acls := make(map[string]storage.ACL)
+	data, err := os.ReadFile(filepath.Join(lfs.root(), aclDir, charmID))
+	if os.IsNotExist(err) {
+		return acls, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &acls); err != nil {
+		return nil, err
+	}
+	return acls, nil
+}
+
+func (lfs *LocalFileStore) writeACLs(charmID string, acls map[string]storage.ACL) error {
+	ap := filepath.Join(lfs.root(), aclDir, charmID)
+	if len(acls) == 0 {
+		if err := os.Remove(ap); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(acls)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ap), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(ap), fmt.Sprintf(".%s.*.tmp", charmID))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // nolint:errcheck
+	defer tmp.Close()           // nolint:errcheck
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), ap)
+}
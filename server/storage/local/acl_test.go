@@ -0,0 +1,143 @@
+package localstorage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+func TestACL(t *testing.T) {
+	owner := uuid.New().String()
+	reader := uuid.New().String()
+	stranger := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(owner, "/team/plan.txt", bytes.NewBufferString("plan"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.SetACL(owner, "/team", storage.ACL{Read: []string{reader}}); err != nil {
+		t.Fatal(err)
+	}
+	acl, err := lfs.GetACL(owner, "/team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acl.Read) != 1 || acl.Read[0] != reader || len(acl.Write) != 0 {
+		t.Fatalf("unexpected ACL %+v", acl)
+	}
+
+	// The directory's ACL covers the file below it.
+	f, err := lfs.GetAs(reader, owner, "/team/plan.txt")
+	if err != nil {
+		t.Fatalf("expected read access to be granted, got %v", err)
+	}
+	data, err := io.ReadAll(f)
+	f.Close() // nolint:errcheck
+	if err != nil || string(data) != "plan" {
+		t.Fatalf("expected to read the file, got %q, %v", data, err)
+	}
+	if err := lfs.PutAs(reader, owner, "/team/plan.txt", bytes.NewBufferString("mine"), fs.FileMode(0o644)); !errors.Is(err, storage.ErrAccessDenied) {
+		t.Fatalf("expected write access to be denied, got %v", err)
+	}
+	if err := lfs.DeleteAs(reader, owner, "/team/plan.txt"); !errors.Is(err, storage.ErrAccessDenied) {
+		t.Fatalf("expected delete access to be denied, got %v", err)
+	}
+	if _, err := lfs.GetAs(stranger, owner, "/team/plan.txt"); !errors.Is(err, storage.ErrAccessDenied) {
+		t.Fatalf("expected read access to be denied to others, got %v", err)
+	}
+	if ok, err := lfs.CheckAccess(owner, owner, "/team/plan.txt", storage.OpWrite); err != nil || !ok {
+		t.Fatalf("expected the owner to have access, got %v, %v", ok, err)
+	}
+
+	// A file's own ACL takes precedence over its directory's.
+	if err := lfs.SetACL(owner, "/team/plan.txt", storage.ACL{Read: []string{reader}, Write: []string{reader}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.PutAs(reader, owner, "/team/plan.txt", bytes.NewBufferString("edited"), fs.FileMode(0o644)); err != nil {
+		t.Fatalf("expected write access to be granted, got %v", err)
+	}
+
+	fis, err := lfs.ListFiltered(owner, "/", storage.ListFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 1 || fis[0].Name != "team" {
+		t.Fatalf("expected ACLs to be hidden from listings, got %+v", fis)
+	}
+
+	if err := lfs.Delete(owner, "/team"); err != nil {
+		t.Fatal(err)
+	}
+	if acl, err := lfs.GetACL(owner, "/team/plan.txt"); err != nil || len(acl.Read) != 0 {
+		t.Fatalf("expected deleting to remove the ACLs, got %+v, %v", acl, err)
+	}
+}
+
+func TestACLOtherEntryPoints(t *testing.T) {
+	owner := uuid.New().String()
+	reader := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/team/plan.txt", "/team/notes.txt", "/private/secret.txt"} {
+		if err := lfs.Put(owner, p, bytes.NewBufferString("data"), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := lfs.SetACL(owner, "/team", storage.ACL{Read: []string{reader}}); err != nil {
+		t.Fatal(err)
+	}
+	denied := func(what string, err error) {
+		t.Helper()
+		if !errors.Is(err, storage.ErrAccessDenied) {
+			t.Fatalf("expected %s to be denied, got %v", what, err)
+		}
+	}
+
+	rc, err := lfs.GetMultipleAs(reader, owner, []string{"/team/plan.txt"})
+	if err != nil {
+		t.Fatalf("expected to read multiple granted files, got %v", err)
+	}
+	rc.Close() // nolint:errcheck
+	_, err = lfs.GetMultipleAs(reader, owner, []string{"/team/plan.txt", "/private/secret.txt"})
+	denied("reading multiple files", err)
+
+	fsys := lfs.FSForAs(reader, owner)
+	if data, err := fs.ReadFile(fsys, "team/plan.txt"); err != nil || string(data) != "data" {
+		t.Fatalf("expected to read a granted file through the fs.FS, got %q, %v", data, err)
+	}
+	_, err = fs.ReadFile(fsys, "private/secret.txt")
+	denied("reading through the fs.FS", err)
+	_, err = fs.ReadDir(fsys, "private")
+	denied("listing through the fs.FS", err)
+	_, err = fs.Stat(fsys, "private/secret.txt")
+	denied("stating through the fs.FS", err)
+
+	denied("patching", lfs.PutAtAs(reader, owner, "/team/plan.txt", 0, bytes.NewBufferString("x")))
+	denied("applying a delta", lfs.PutDeltaAs(reader, owner, "/team/plan.txt", nil, bytes.NewBufferString("x")))
+	denied("copying a range", lfs.CopyRangeAs(reader, owner, "/team/plan.txt", 0, 1, "/team/copy.txt", 0))
+	denied("swapping", lfs.SwapAs(reader, owner, "/team/plan.txt", "/team/notes.txt"))
+
+	if err := lfs.SetACL(owner, "/team", storage.ACL{Read: []string{reader}, Write: []string{reader}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.PutAtAs(reader, owner, "/team/plan.txt", 0, bytes.NewBufferString("D")); err != nil {
+		t.Fatalf("expected patching to be granted, got %v", err)
+	}
+	if err := lfs.CopyRangeAs(reader, owner, "/team/plan.txt", 0, 4, "/team/copy.txt", 0); err != nil {
+		t.Fatalf("expected copying a range to be granted, got %v", err)
+	}
+	denied("copying a range from a file that isn't granted", lfs.CopyRangeAs(reader, owner, "/private/secret.txt", 0, 4, "/team/copy.txt", 0))
+	if err := lfs.SwapAs(reader, owner, "/team/plan.txt", "/team/notes.txt"); err != nil {
+		t.Fatalf("expected swapping to be granted, got %v", err)
+	}
+	denied("swapping with a file that isn't granted", lfs.SwapAs(reader, owner, "/team/plan.txt", "/private/secret.txt"))
+}
@@ -0,0 +1,55 @@
+package localstorage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// allocAttempts is how many names AllocPath tries before giving up.
+const allocAttempts = 10
+
+// AllocPath reserves a new, unique path in the directory dir for the Charm ID
+// named prefix, a random part and ext, such as "upload-" and ".png", and
+// returns it for a subsequent Put. The path is reserved by atomically creating
+// an empty placeholder file, so concurrent allocations never return the same
+// path. dir is created if needed.
+func (lfs *LocalFileStore) AllocPath(charmID, dir, prefix, ext string) (string, error) {
+	defer lfs.op()()
+	if strings.ContainsAny(prefix+ext, `/\`) {
+		return "", fmt.Errorf("invalid name specified: %s", prefix+ext)
+	}
+	dir = path.Join("/", filepath.ToSlash(dir))
+	if cpath := filepath.Clean(dir); inReserved(cpath) {
+		return "", fmt.Errorf("invalid path specified: %s", cpath)
+	}
+	if err := lfs.ensureParent(charmID, path.Join(dir, prefix+ext), 0o644); err != nil {
+		return "", err
+	}
+	if err := lfs.requireDir(charmID, dir); err != nil {
+		return "", err
+	}
+	for i := 0; i < allocAttempts; i++ {
+		p := path.Join(dir, prefix+strings.ReplaceAll(uuid.New().String(), "-", "")[:16]+ext)
+		if isReserved(path.Base(p)) {
+			continue
+		}
+		f, err := os.OpenFile(filepath.Join(lfs.root(), charmID, p), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if err := f.Close(); err != nil {
+			return "", err
+		}
+		return p, nil
+	}
+	return "", fmt.Errorf("no unique path found in %s after %d attempts", dir, allocAttempts)
+}
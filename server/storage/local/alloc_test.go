@@ -0,0 +1,60 @@
+package localstorage
+
+import (
+	"bytes"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+func TestAllocPath(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 100
+	paths := make([]string, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			paths[i], errs[i] = lfs.AllocPath(charmID, "/uploads", "img-", ".png")
+		}(i)
+	}
+	wg.Wait()
+	seen := make(map[string]bool)
+	for i, p := range paths {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if path.Dir(p) != "/uploads" || !strings.HasPrefix(path.Base(p), "img-") || path.Ext(p) != ".png" {
+			t.Fatalf("unexpected path %s", p)
+		}
+		if seen[p] {
+			t.Fatalf("path %s allocated twice", p)
+		}
+		seen[p] = true
+	}
+	entries, err := lfs.ListFiltered(charmID, "/uploads", storage.ListFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != n {
+		t.Fatalf("expected %d placeholders, got %d", n, len(entries))
+	}
+
+	if err := lfs.Put(charmID, paths[0], bytes.NewBufferString("png"), fs.FileMode(0o644)); err != nil {
+		t.Fatalf("expected to Put to an allocated path, got %v", err)
+	}
+	if _, err := lfs.AllocPath(charmID, "/uploads", "a/", ".png"); err == nil {
+		t.Fatal("expected an error for a prefix with a slash")
+	}
+}
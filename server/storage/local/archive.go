@@ -0,0 +1,185 @@
+package localstorage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// GetZip streams a zip archive of the file or directory at path for the Charm
+// ID. Archive entries are named relative to path and keep their file modes.
+// Reserved entries are left out.
+func (lfs *LocalFileStore) GetZip(charmID, path string) (io.ReadCloser, error) {
+	defer lfs.op()()
+	fp := filepath.Join(lfs.root(), charmID, path)
+	info, err := os.Stat(fp)
+	if os.IsNotExist(err) {
+		return nil, fs.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	end := lfs.op()
+	go func() {
+		defer end()
+		zw := zip.NewWriter(pw)
+		var err error
+		if info.IsDir() {
+			err = lfs.walk(charmID, path, func(rel string, d fs.DirEntry) error {
+				fi, err := d.Info()
+				if err != nil {
+					return err
+				}
+				return lfs.addZipEntry(zw, filepath.Join(fp, rel), rel, fi)
+			})
+		} else {
+			err = lfs.addZipEntry(zw, fp, info.Name(), info)
+		}
+		if err == nil {
+			err = zw.Close()
+		}
+		pw.CloseWithError(err) // nolint:errcheck
+	}()
+	return pr, nil
+}
+
+func (lfs *LocalFileStore) addZipEntry(zw *zip.Writer, fp, name string, fi fs.FileInfo) error {
+	hdr, err := zip.FileInfoHeader(fi)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if fi.IsDir() {
+		hdr.Name += "/"
+	} else {
+		hdr.Method = zip.Deflate
+	}
+	w, err := zw.CreateHeader(hdr)
+	if err != nil || fi.IsDir() {
+		return err
+	}
+	f, err := lfs.openStored(fp)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint:errcheck
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// GetArchiveFiltered streams a tar archive of the files below path for the
+// Charm ID for which include returns true. include is passed paths relative to
+// path, with forward slashes, and a nil include keeps every file. Only regular
+// files are archived. Directories
+// are only archived when a file below them is, unless include is nil. Entries
+// keep their file modes and modification times. Reserved entries are always
+// left out.
+func (lfs *LocalFileStore) GetArchiveFiltered(charmID, path string, include func(relPath string) bool) (io.ReadCloser, error) {
+	defer lfs.op()()
+	fp := filepath.Join(lfs.root(), charmID, path)
+	info, err := os.Stat(fp)
+	if os.IsNotExist(err) {
+		return nil, fs.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	end := lfs.op()
+	go func() {
+		defer end()
+		tw := tar.NewWriter(pw)
+		var err error
+		if info.IsDir() {
+			err = lfs.addTarEntries(tw, charmID, path, include)
+		} else if include == nil || include(info.Name()) {
+			err = lfs.addTarEntry(tw, fp, info.Name(), info)
+		}
+		if err == nil {
+			err = tw.Close()
+		}
+		pw.CloseWithError(err) // nolint:errcheck
+	}()
+	return pr, nil
+}
+
+// addTarEntries adds the entries below path to tw. Directories are held back
+// until a file below them is included.
+func (lfs *LocalFileStore) addTarEntries(tw *tar.Writer, charmID, path string, include func(string) bool) error {
+	type dir struct {
+		rel     string
+		info    fs.FileInfo
+		written bool
+	}
+	var dirs []*dir
+	root := filepath.Join(lfs.root(), charmID, path)
+	return lfs.walk(charmID, path, func(rel string, d fs.DirEntry) error {
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		// Walks are depth first, so dirs only needs to hold the ancestors of
+		// the current entry.
+		for len(dirs) > 0 && !strings.HasPrefix(rel, dirs[len(dirs)-1].rel+"/") {
+			dirs = dirs[:len(dirs)-1]
+		}
+		if fi.IsDir() {
+			dirs = append(dirs, &dir{rel: rel, info: fi})
+			if include != nil {
+				return nil
+			}
+		} else if !fi.Mode().IsRegular() || (include != nil && !include(rel)) {
+			return nil
+		}
+		for _, d := range dirs {
+			if d.written {
+				continue
+			}
+			if err := lfs.addTarEntry(tw, filepath.Join(root, d.rel), d.rel, d.info); err != nil {
+				return err
+			}
+			d.written = true
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		return lfs.addTarEntry(tw, filepath.Join(root, rel), rel, fi)
+	})
+}
+
+func (lfs *LocalFileStore) addTarEntry(tw *tar.Writer, fp, name string, fi fs.FileInfo) error {
+	if !fi.Mode().IsRegular() {
+		return writeTarHeader(tw, name, fi)
+	}
+	f, err := lfs.openStored(fp)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint:errcheck
+	// Files stored compressed report their logical size once opened.
+	if fi, err = f.Stat(); err != nil {
+		return err
+	}
+	if err := writeTarHeader(tw, name, fi); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, f, fi.Size())
+	return err
+}
+
+func writeTarHeader(tw *tar.Writer, name string, fi fs.FileInfo) error {
+	hdr, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if fi.IsDir() {
+		hdr.Name += "/"
+	}
+	return tw.WriteHeader(hdr)
+}
@@ -0,0 +1,141 @@
+package localstorage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestGetZip(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]struct {
+		content string
+		mode    fs.FileMode
+	}{
+		"hello.txt":     {"hello", 0o644},
+		"foo/bar.txt":   {"bar", 0o600},
+		"foo/baz/a.txt": {"a", 0o640},
+	}
+	for path, f := range files {
+		if err := lfs.Put(charmID, "/docs/"+path, bytes.NewBufferString(f.content), f.mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(lfs.Path, charmID, "docs", ".hello.txt.1.tmp"), []byte("he"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	rc, err := lfs.GetZip(charmID, "/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close() // nolint:errcheck
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("expected no error reading zip, %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := 0
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+		want, ok := files[zf.Name]
+		if !ok {
+			t.Fatalf("unexpected zip entry %s", zf.Name)
+		}
+		got++
+		r, err := zf.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(r)
+		r.Close() // nolint:errcheck
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != want.content {
+			t.Fatalf("expected content of %s to be %s, got %s", zf.Name, want.content, string(content))
+		}
+		if zf.Mode().Perm() != want.mode {
+			t.Fatalf("expected mode of %s to be %v, got %v", zf.Name, want.mode, zf.Mode().Perm())
+		}
+	}
+	if got != len(files) {
+		t.Fatalf("expected %d files in zip, got %d", len(files), got)
+	}
+}
+
+func TestGetArchiveFiltered(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"README.md", "notes.txt", "foo/bar.md", "foo/bar.txt", "baz/a.txt"} {
+		if err := lfs.Put(charmID, "/docs/"+path, bytes.NewBufferString(path), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(lfs.Path, charmID, "docs", ".README.md.1.tmp"), []byte("RE"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	members := func(include func(string) bool) []string {
+		t.Helper()
+		rc, err := lfs.GetArchiveFiltered(charmID, "/docs", include)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close() // nolint:errcheck
+		var names []string
+		tr := tar.NewReader(rc)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("expected no error reading tar, %v", err)
+			}
+			if hdr.Typeflag == tar.TypeReg {
+				content, err := io.ReadAll(tr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(content) != hdr.Name {
+					t.Fatalf("expected content of %s to be its path, got %s", hdr.Name, content)
+				}
+			}
+			names = append(names, hdr.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	got := strings.Join(members(func(rel string) bool { return strings.HasSuffix(rel, ".md") }), " ")
+	if want := "README.md foo/ foo/bar.md"; got != want {
+		t.Fatalf("expected members %q, got %q", want, got)
+	}
+	got = strings.Join(members(nil), " ")
+	if want := "README.md baz/ baz/a.txt foo/ foo/bar.md foo/bar.txt notes.txt"; got != want {
+		t.Fatalf("expected members %q, got %q", want, got)
+	}
+}
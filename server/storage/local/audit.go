@@ -0,0 +1,148 @@
+package localstorage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// auditDir is the top-level directory audit logs are kept in, as a file of
+// JSON lines per Charm ID.
+const auditDir = ".audit"
+
+// Audited operations.
+const (
+	opGet    = "get"
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+// audit appends an entry for an operation on path to the Charm ID's audit log
+// if AuditLog is set. Failing to write the entry doesn't fail the operation.
+func (lfs *LocalFileStore) audit(charmID, op, path string, size int64, err error) {
+	if !lfs.AuditLog {
+		return
+	}
+	e := storage.ActivityEntry{Op: op, Path: path, Size: size, Time: time.Now(), Result: "ok"}
+	if err != nil {
+		e.Result = err.Error()
+	}
+	b, merr := json.Marshal(e)
+	if merr != nil {
+		return
+	}
+	defer lfs.op()()
+	lfs.auditMu.Lock()
+	defer lfs.auditMu.Unlock()
+	ap := filepath.Join(lfs.root(), auditDir, charmID)
+	if err := os.MkdirAll(filepath.Dir(ap), 0o700); err != nil {
+		return
+	}
+	f, err := os.OpenFile(ap, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()          // nolint:errcheck
+	f.Write(append(b, '\n')) // nolint:errcheck
+}
+
+// UserActivity returns up to limit of the most recent operations in the Charm
+// ID's audit log, newest first. A limit of zero or less returns them all.
+// Operations are only logged while AuditLog is set.
+func (lfs *LocalFileStore) UserActivity(charmID string, limit int) ([]storage.ActivityEntry, error) {
+	defer lfs.op()()
+	entries := make([]storage.ActivityEntry, 0)
+	f, err := os.Open(filepath.Join(lfs.root(), auditDir, charmID))
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // nolint:errcheck
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for sc.Scan() {
+		var e storage.ActivityEntry
+		// A line cut short by a crash is skipped.
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+		if limit > 0 && len(entries) > limit {
+			entries = entries[1:]
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+// CompactLogs rewrites the Charm ID's audit log without the entries older than
+// retain, and without lines left unreadable by a crash. A retain of zero or
+// less keeps every readable entry. The log is rewritten to a temporary file
+// that then replaces it, and operations logged meanwhile wait for it.
+func (lfs *LocalFileStore) CompactLogs(charmID string, retain time.Duration) error {
+	defer lfs.op()()
+	lfs.auditMu.Lock()
+	defer lfs.auditMu.Unlock()
+	ap := filepath.Join(lfs.root(), auditDir, charmID)
+	f, err := os.Open(ap)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint:errcheck
+	tmp, err := os.CreateTemp(filepath.Dir(ap), fmt.Sprintf(".%s.*.tmp", charmID))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // nolint:errcheck
+	defer tmp.Close()           // nolint:errcheck
+	cutoff := time.Now().Add(-retain)
+	w := bufio.NewWriter(tmp)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for sc.Scan() {
+		var e storage.ActivityEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			continue
+		}
+		if retain > 0 && e.Time.Before(cutoff) {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, sc.Bytes()); err != nil {
+			continue
+		}
+		buf.WriteByte('\n')
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), ap)
+}
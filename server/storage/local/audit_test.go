@@ -0,0 +1,144 @@
+package localstorage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+func TestUserActivity(t *testing.T) {
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.AuditLog = true
+	charmID := uuid.New().String()
+	for _, p := range []string{"/a.txt", "/b.txt"} {
+		if err := lfs.Put(charmID, p, bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := lfs.Delete(charmID, "/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Delete(charmID, "/"); err == nil {
+		t.Fatal("expected an error deleting the root directory")
+	}
+
+	entries, err := lfs.UserActivity(charmID, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	if e := entries[0]; e.Op != opDelete || e.Path != "/" || e.Result == "ok" {
+		t.Fatalf("expected the failed delete first, got %+v", e)
+	}
+	if e := entries[1]; e.Op != opDelete || e.Path != "/a.txt" || e.Result != "ok" {
+		t.Fatalf("expected the delete of /a.txt second, got %+v", e)
+	}
+	if e := entries[2]; e.Op != opPut || e.Path != "/b.txt" || e.Size != 5 || e.Result != "ok" {
+		t.Fatalf("expected the put of /b.txt third, got %+v", e)
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i].Time.After(entries[i-1].Time) {
+			t.Fatalf("entries aren't newest first: %+v", entries)
+		}
+	}
+
+	all, err := lfs.UserActivity(charmID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(all))
+	}
+	if _, err := lfs.Stat(charmID, "/.audit"); err == nil {
+		t.Fatal("expected the audit log to be hidden")
+	}
+	other, err := lfs.UserActivity(uuid.New().String(), 10)
+	if err != nil || len(other) != 0 {
+		t.Fatalf("expected no activity for another user, got %v, %v", other, err)
+	}
+}
+
+func TestCompactLogs(t *testing.T) {
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.AuditLog = true
+	charmID := uuid.New().String()
+	ap := filepath.Join(lfs.Path, auditDir, charmID)
+	if err := os.MkdirAll(filepath.Dir(ap), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	var old bytes.Buffer
+	for i := 0; i < 3; i++ {
+		e := storage.ActivityEntry{Op: opPut, Path: "/old.txt", Time: time.Now().Add(-48 * time.Hour), Result: "ok"}
+		b, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		old.Write(b)
+		old.WriteByte('\n')
+	}
+	old.WriteString("{\"op\": \"put\", \"pa\n")
+	if err := os.WriteFile(ap, old.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/new.txt", bytes.NewBufferString("new"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lfs.CompactLogs(charmID, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := lfs.UserActivity(charmID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Path != "/new.txt" {
+		t.Fatalf("expected only the recent entry to be retained, got %+v", entries)
+	}
+	f, err := os.Open(ap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close() // nolint:errcheck
+	sc := bufio.NewScanner(f)
+	lines := 0
+	for sc.Scan() {
+		lines++
+		if !json.Valid(sc.Bytes()) {
+			t.Fatalf("expected every line to be valid JSON, got %q", sc.Text())
+		}
+	}
+	if lines != 1 {
+		t.Fatalf("expected 1 line, got %d", lines)
+	}
+
+	// Logging carries on after the log is replaced.
+	if err := lfs.Delete(charmID, "/new.txt"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err = lfs.UserActivity(charmID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Op != opDelete {
+		t.Fatalf("expected the delete to be logged after compaction, got %+v", entries)
+	}
+	if err := lfs.CompactLogs(uuid.New().String(), time.Hour); err != nil {
+		t.Fatalf("expected no error compacting a missing log, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package localstorage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// probeCaseInsensitive reports whether the volume dir is on ignores case in
+// file names. It's a variable so tests can simulate a case-insensitive volume.
+var probeCaseInsensitive = func(dir string) (bool, error) {
+	f, err := os.CreateTemp(dir, ".case*.tmp")
+	if err != nil {
+		return false, err
+	}
+	name := f.Name()
+	defer os.Remove(name) // nolint:errcheck
+	if err := f.Close(); err != nil {
+		return false, err
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return false, err
+	}
+	ufi, err := os.Stat(filepath.Join(dir, strings.ToUpper(filepath.Base(name))))
+	if err != nil {
+		return false, nil
+	}
+	return os.SameFile(fi, ufi), nil
+}
+
+// caseInsensitive reports whether the volume holding the store is
+// case-insensitive, probing it the first time it's asked.
+func (lfs *LocalFileStore) caseInsensitive() (bool, error) {
+	lfs.caseMu.Lock()
+	defer lfs.caseMu.Unlock()
+	if !lfs.caseProbed {
+		lfs.caseFold, lfs.caseErr = probeCaseInsensitive(lfs.root())
+		lfs.caseProbed = true
+	}
+	return lfs.caseFold, lfs.caseErr
+}
+
+// checkCaseConflict returns storage.ErrCaseConflict if writing fp would
+// replace a sibling whose name only differs in case, which happens on
+// case-insensitive volumes.
+func (lfs *LocalFileStore) checkCaseConflict(fp string) error {
+	if lfs.AllowCaseClobber {
+		return nil
+	}
+	insensitive, err := lfs.caseInsensitive()
+	if err != nil || !insensitive {
+		return err
+	}
+	des, err := os.ReadDir(filepath.Dir(fp))
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(fp)
+	for _, de := range des {
+		if de.Name() != name && strings.EqualFold(de.Name(), name) {
+			return storage.ErrCaseConflict
+		}
+	}
+	return nil
+}
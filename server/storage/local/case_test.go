@@ -0,0 +1,62 @@
+package localstorage
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+func TestPutCaseConflict(t *testing.T) {
+	probe := probeCaseInsensitive
+	defer func() { probeCaseInsensitive = probe }()
+
+	newStore := func(t *testing.T, insensitive bool) (*LocalFileStore, string) {
+		t.Helper()
+		probeCaseInsensitive = func(string) (bool, error) { return insensitive, nil }
+		lfs, err := NewLocalFileStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		charmID := uuid.New().String()
+		if err := lfs.Put(charmID, "/foo/hello.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+		return lfs, charmID
+	}
+
+	t.Run("case-insensitive", func(t *testing.T) {
+		lfs, charmID := newStore(t, true)
+		err := lfs.Put(charmID, "/foo/Hello.txt", bytes.NewBufferString("HELLO"), fs.FileMode(0o644))
+		if !errors.Is(err, storage.ErrCaseConflict) {
+			t.Fatalf("expected ErrCaseConflict, got %v", err)
+		}
+		if err := lfs.Put(charmID, "/foo/hello.txt", bytes.NewBufferString("hi"), fs.FileMode(0o644)); err != nil {
+			t.Fatalf("expected replacing the same name to succeed, %v", err)
+		}
+		lfs.AllowCaseClobber = true
+		if err := lfs.Put(charmID, "/foo/Hello.txt", bytes.NewBufferString("HELLO"), fs.FileMode(0o644)); err != nil {
+			t.Fatalf("expected clobbering to be allowed, %v", err)
+		}
+	})
+
+	t.Run("case-sensitive", func(t *testing.T) {
+		lfs, charmID := newStore(t, false)
+		if err := lfs.Put(charmID, "/foo/Hello.txt", bytes.NewBufferString("HELLO"), fs.FileMode(0o644)); err != nil {
+			t.Fatalf("expected no conflict on a case-sensitive volume, %v", err)
+		}
+	})
+
+	t.Run("probe", func(t *testing.T) {
+		insensitive, err := probe(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if insensitive {
+			t.Skip("temp dir is on a case-insensitive volume")
+		}
+	})
+}
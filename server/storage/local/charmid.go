@@ -0,0 +1,154 @@
+package localstorage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// charmIDDirs are the top-level directories holding data for each Charm ID,
+// relative to the store path. The Charm ID directory itself is "".
+var charmIDDirs = []string{"", checksumsDir, snapshotsDir, quarantineDir, accessDir, generationsDir, auditDir, aclDir}
+
+// RenameCharmID moves everything stored for oldID to newID, such as when a
+// user changes their Charm ID. Both must be a single path component that isn't
+// reserved by the store, or it fails with storage.ErrInvalidPath. The Charm ID
+// directory is renamed atomically, falling back to a copy and delete when it
+// can't be renamed in place. It fails with storage.ErrCharmIDExists if newID
+// already has data. If a move fails part-way, the directories already moved
+// are moved back. Calls in flight are waited for and new ones wait until the
+// rename is done, as with Relocate.
+//
+// Grants to oldID in the ACLs of other Charm IDs, quota reservations and
+// writes remembered for their IdempotencyKey move to newID as well.
+func (lfs *LocalFileStore) RenameCharmID(oldID, newID string) error {
+	for _, id := range []string{oldID, newID} {
+		if !validCharmID(id) {
+			return fmt.Errorf("%q: %w", id, storage.ErrInvalidPath)
+		}
+	}
+	unlock, err := lfs.exclusive()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	lfs.txMu.Lock()
+	defer lfs.txMu.Unlock()
+	defer lfs.resetUsage(newID)
+	defer lfs.resetUsage(oldID)
+	if _, err := os.Stat(filepath.Join(lfs.root(), oldID)); err != nil {
+		if os.IsNotExist(err) {
+			return fs.ErrNotExist
+		}
+		return err
+	}
+	for _, dir := range charmIDDirs {
+		_, err := os.Lstat(filepath.Join(lfs.root(), dir, newID))
+		if err == nil {
+			return storage.ErrCharmIDExists
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	var moved []string
+	undo := func() {
+		for i := len(moved) - 1; i >= 0; i-- {
+			moveDir(filepath.Join(lfs.root(), moved[i], newID), filepath.Join(lfs.root(), moved[i], oldID)) // nolint:errcheck
+		}
+	}
+	for _, dir := range charmIDDirs {
+		src := filepath.Join(lfs.root(), dir, oldID)
+		if _, err := os.Lstat(src); os.IsNotExist(err) {
+			continue
+		}
+		if err := moveDir(src, filepath.Join(lfs.root(), dir, newID)); err != nil {
+			undo()
+			return err
+		}
+		moved = append(moved, dir)
+	}
+	if err := lfs.renameGrants(oldID, newID); err != nil {
+		undo()
+		return err
+	}
+	lfs.renameReservations(oldID, newID)
+	lfs.renameIdempotent(oldID, newID)
+	return nil
+}
+
+// validCharmID reports whether id can name a Charm ID directory: a single
+// path component that isn't one of the store's own directories.
+func validCharmID(id string) bool {
+	switch {
+	case id == "" || id == "." || id == "..":
+		return false
+	case strings.ContainsAny(id, `/\`) || filepath.Base(id) != id || filepath.VolumeName(id) != "":
+		return false
+	}
+	return !reservedRoots[id] && !isStaging(id)
+}
+
+// moveDir renames the directory src to dst, copying it and removing src if
+// they're on different volumes.
+func moveDir(src, dst string) error {
+	err := rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyTree(src, dst); err != nil {
+		os.RemoveAll(dst) // nolint:errcheck
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// copyTree copies the directory src to dst, keeping modes and modification
+// times.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, fp)
+		if err != nil {
+			return err
+		}
+		tp := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(tp, info.Mode().Perm()|0o700)
+		}
+		if err := copyFile(fp, tp, info.Mode().Perm()); err != nil {
+			return err
+		}
+		return os.Chtimes(tp, info.ModTime(), info.ModTime())
+	})
+}
+
+func copyFile(src, dst string, mode fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close() // nolint:errcheck
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close() // nolint:errcheck
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,164 @@
+package localstorage
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+func TestRenameCharmID(t *testing.T) {
+	setup := func(t *testing.T) (*LocalFileStore, string) {
+		lfs, err := NewLocalFileStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		oldID := uuid.New().String()
+		if err := lfs.Put(oldID, "/foo/bar.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+		return lfs, oldID
+	}
+	assertRenamed := func(t *testing.T, lfs *LocalFileStore, oldID, newID string) {
+		if _, err := lfs.Stat(oldID, "/foo/bar.txt"); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected file to be gone from old Charm ID, got %v", err)
+		}
+		if _, err := lfs.Stat(newID, "/foo/bar.txt"); err != nil {
+			t.Fatalf("expected file under new Charm ID, %v", err)
+		}
+		ok, err := lfs.Verify(newID, "/foo/bar.txt")
+		if err != nil {
+			t.Fatalf("expected checksum to move with the Charm ID, %v", err)
+		}
+		if !ok {
+			t.Fatal("expected file to verify under new Charm ID")
+		}
+	}
+
+	t.Run("rename", func(t *testing.T) {
+		lfs, oldID := setup(t)
+		newID := uuid.New().String()
+		if err := lfs.RenameCharmID(oldID, newID); err != nil {
+			t.Fatalf("expected no error renaming Charm ID, %v", err)
+		}
+		assertRenamed(t, lfs, oldID, newID)
+	})
+
+	t.Run("cross volume", func(t *testing.T) {
+		lfs, oldID := setup(t)
+		newID := uuid.New().String()
+		rename = func(oldpath, newpath string) error {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+		}
+		defer func() { rename = os.Rename }()
+		if err := lfs.RenameCharmID(oldID, newID); err != nil {
+			t.Fatalf("expected no error renaming Charm ID, %v", err)
+		}
+		assertRenamed(t, lfs, oldID, newID)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		lfs, oldID := setup(t)
+		for _, newID := range []string{"", ".", "..", "../escaped", "a/b", `a\b`, "/abs", lfs.Path, blobsDir, sharedDir, aclDir, auditDir, ".x.123.tmp"} {
+			if err := lfs.RenameCharmID(oldID, newID); !errors.Is(err, storage.ErrInvalidPath) {
+				t.Fatalf("expected ErrInvalidPath renaming to %q, got %v", newID, err)
+			}
+		}
+		if err := lfs.RenameCharmID("..", uuid.New().String()); !errors.Is(err, storage.ErrInvalidPath) {
+			t.Fatalf("expected ErrInvalidPath renaming from outside the store, got %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(filepath.Dir(lfs.Path), "escaped")); !os.IsNotExist(err) {
+			t.Fatalf("expected nothing to be moved out of the store, got %v", err)
+		}
+		if _, err := lfs.Stat(oldID, "/foo/bar.txt"); err != nil {
+			t.Fatalf("expected old Charm ID to be untouched, %v", err)
+		}
+	})
+
+	t.Run("exists", func(t *testing.T) {
+		lfs, oldID := setup(t)
+		newID := uuid.New().String()
+		if err := lfs.Put(newID, "/taken.txt", bytes.NewBufferString("mine"), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+		if err := lfs.RenameCharmID(oldID, newID); !errors.Is(err, storage.ErrCharmIDExists) {
+			t.Fatalf("expected ErrCharmIDExists, got %v", err)
+		}
+		if _, err := lfs.Stat(oldID, "/foo/bar.txt"); err != nil {
+			t.Fatalf("expected old Charm ID to be untouched, %v", err)
+		}
+		if _, err := lfs.Stat(newID, "/taken.txt"); err != nil {
+			t.Fatalf("expected new Charm ID to be untouched, %v", err)
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		lfs, oldID := setup(t)
+		newID := uuid.New().String()
+		rename = func(oldpath, newpath string) error {
+			if strings.Contains(oldpath, checksumsDir) {
+				return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EIO}
+			}
+			return os.Rename(oldpath, newpath)
+		}
+		defer func() { rename = os.Rename }()
+		if err := lfs.RenameCharmID(oldID, newID); !errors.Is(err, syscall.EIO) {
+			t.Fatalf("expected the failed move's error, got %v", err)
+		}
+		if _, err := lfs.Stat(newID, "/foo/bar.txt"); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected nothing under new Charm ID, got %v", err)
+		}
+		ok, err := lfs.Verify(oldID, "/foo/bar.txt")
+		if err != nil {
+			t.Fatalf("expected old Charm ID to be moved back, %v", err)
+		}
+		if !ok {
+			t.Fatal("expected file to verify under old Charm ID")
+		}
+	})
+
+	t.Run("keyed state", func(t *testing.T) {
+		lfs, oldID := setup(t)
+		newID, otherID := uuid.New().String(), uuid.New().String()
+		lfs.QuotaBytes = 100
+		if err := lfs.Put(otherID, "/shared.txt", bytes.NewBufferString("hi"), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+		if err := lfs.SetACL(otherID, "/shared.txt", storage.ACL{Read: []string{oldID}}); err != nil {
+			t.Fatal(err)
+		}
+		resID, err := lfs.ReserveQuota(oldID, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		idem := storage.PutOptions{IdempotencyKey: "k"}
+		if err := lfs.PutWithOptions(oldID, "/idem.txt", bytes.NewBufferString("1"), fs.FileMode(0o644), idem); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := lfs.RenameCharmID(oldID, newID); err != nil {
+			t.Fatalf("expected no error renaming Charm ID, %v", err)
+		}
+		if ok, err := lfs.CheckAccess(newID, otherID, "/shared.txt", storage.OpRead); err != nil || !ok {
+			t.Fatalf("expected grant to move to new Charm ID, got %v, %v", ok, err)
+		}
+		if ok, err := lfs.CheckAccess(oldID, otherID, "/shared.txt", storage.OpRead); err != nil || ok {
+			t.Fatalf("expected grant to be gone from old Charm ID, got %v, %v", ok, err)
+		}
+		res := storage.PutOptions{ReservationID: resID}
+		if err := lfs.PutWithOptions(newID, "/res.txt", &opaqueReader{bytes.NewBufferString("1234")}, fs.FileMode(0o644), res); err != nil {
+			t.Fatalf("expected reservation to move to new Charm ID, %v", err)
+		}
+		err = lfs.PutWithOptions(newID, "/other.txt", bytes.NewBufferString("1"), fs.FileMode(0o644), idem)
+		if !errors.Is(err, storage.ErrIdempotencyKeyReused) {
+			t.Fatalf("expected idempotency key to move to new Charm ID, got %v", err)
+		}
+	})
+}
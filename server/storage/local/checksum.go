@@ -0,0 +1,163 @@
+package localstorage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/zeebo/blake3"
+	"github.com/zeebo/xxh3"
+)
+
+// checksumsDir is the top-level directory checksums are recorded in. It
+// mirrors the layout of the Charm ID directories.
+const checksumsDir = ".checksums"
+
+// Checksum returns the checksum recorded when the file at path was stored.
+func (lfs *LocalFileStore) Checksum(charmID, path string) (string, error) {
+	defer lfs.op()()
+	b, err := os.ReadFile(lfs.checksumPath(charmID, path))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// checksum returns the recorded checksum of the file at path, computing and
+// recording it if it's missing. The caller must hold the path lock of the
+// file, so a checksum computed from data being replaced isn't recorded over
+// that of the replacement.
+func (lfs *LocalFileStore) checksum(charmID, path string) (string, error) {
+	sum, err := lfs.Checksum(charmID, path)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return sum, err
+	}
+	sum, err = lfs.checksumFile(filepath.Join(lfs.root(), charmID, path), lfs.checksumAlgo(), nil)
+	if err != nil {
+		return "", err
+	}
+	return sum, lfs.writeChecksum(charmID, path, sum)
+}
+
+// lockedChecksum is like checksum but takes the path lock of the file itself.
+func (lfs *LocalFileStore) lockedChecksum(charmID, path string) (string, error) {
+	defer lfs.lockPath(filepath.Join(lfs.root(), charmID, path))()
+	return lfs.checksum(charmID, path)
+}
+
+func (lfs *LocalFileStore) checksumPath(charmID, path string) string {
+	return filepath.Join(lfs.root(), checksumsDir, charmID, path)
+}
+
+func (lfs *LocalFileStore) writeChecksum(charmID, path, sum string) error {
+	cp := lfs.checksumPath(charmID, path)
+	if err := storage.EnsureDir(filepath.Dir(cp), 0o700); err != nil {
+		return err
+	}
+	return writeSidecar(cp, []byte(sum+"\n"))
+}
+
+// removeChecksum removes the checksum recorded for the file at path, if any.
+func (lfs *LocalFileStore) removeChecksum(charmID, path string) error {
+	if err := os.Remove(lfs.checksumPath(charmID, path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ChecksumAlgo is a hashing algorithm used for file checksums. Checksums are
+// stored prefixed with the name of the algorithm, like sha256:<hex>, so files
+// hashed with different algorithms can coexist.
+type ChecksumAlgo string
+
+// Supported checksum algorithms.
+const (
+	SHA256 ChecksumAlgo = "sha256"
+	BLAKE3 ChecksumAlgo = "blake3"
+	XXH3   ChecksumAlgo = "xxh3"
+)
+
+func (a ChecksumAlgo) newHash() (hash.Hash, error) {
+	switch a {
+	case SHA256:
+		return sha256.New(), nil
+	case BLAKE3:
+		return blake3.New(), nil
+	case XXH3:
+		return xxh3.New(), nil
+	}
+	return nil, fmt.Errorf("unknown checksum algorithm: %q", string(a))
+}
+
+// Verify recomputes the checksum of the file at path with the algorithm it was
+// recorded with and reports whether it still matches.
+func (lfs *LocalFileStore) Verify(charmID, path string) (bool, error) {
+	defer lfs.op()()
+	return lfs.verify(charmID, path, nil)
+}
+
+func (lfs *LocalFileStore) verify(charmID, path string, wrap func(io.Reader) io.Reader) (bool, error) {
+	want, err := lfs.Checksum(charmID, path)
+	if err != nil {
+		return false, err
+	}
+	algo, _ := parseChecksum(want)
+	got, err := lfs.checksumFile(filepath.Join(lfs.root(), charmID, path), algo, wrap)
+	if err != nil {
+		return false, err
+	}
+	_, wantSum := parseChecksum(want)
+	_, gotSum := parseChecksum(got)
+	return gotSum == wantSum, nil
+}
+
+// checksumAlgo returns the algorithm new checksums are computed with.
+func (lfs *LocalFileStore) checksumAlgo() ChecksumAlgo {
+	if lfs.ChecksumAlgo == "" {
+		return SHA256
+	}
+	return lfs.ChecksumAlgo
+}
+
+func encodeChecksum(algo ChecksumAlgo, h hash.Hash) string {
+	return fmt.Sprintf("%s:%s", algo, hex.EncodeToString(h.Sum(nil)))
+}
+
+// parseChecksum splits a checksum into its algorithm and hex digest.
+// Checksums without a prefix predate configurable algorithms and are SHA-256.
+func parseChecksum(sum string) (ChecksumAlgo, string) {
+	if i := strings.IndexByte(sum, ':'); i >= 0 {
+		return ChecksumAlgo(sum[:i]), sum[i+1:]
+	}
+	return SHA256, sum
+}
+
+// checksumFile computes the checksum of the file at fp. If wrap is provided,
+// the file is read through the reader it returns.
+func (lfs *LocalFileStore) checksumFile(fp string, algo ChecksumAlgo, wrap func(io.Reader) io.Reader) (string, error) {
+	h, err := algo.newHash()
+	if err != nil {
+		return "", err
+	}
+	f, err := lfs.openStored(fp)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close() // nolint:errcheck
+	var r io.Reader = f
+	if wrap != nil {
+		r = wrap(f)
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return "", fmt.Errorf("checksum %s: %w", fp, err)
+	}
+	return encodeChecksum(algo, h), nil
+}
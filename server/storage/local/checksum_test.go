@@ -0,0 +1,167 @@
+package localstorage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+func TestChecksumAlgo(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	algos := []ChecksumAlgo{SHA256, BLAKE3, XXH3}
+	for _, algo := range algos {
+		lfs.ChecksumAlgo = algo
+		path := "/" + string(algo) + ".txt"
+		if err := lfs.Put(charmID, path, bytes.NewBufferString("hello world"), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+		sum, err := lfs.Checksum(charmID, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(sum, string(algo)+":") {
+			t.Fatalf("expected checksum to be prefixed with %s, got %s", algo, sum)
+		}
+	}
+
+	// Verify uses the algorithm a checksum was stored with, whatever the
+	// current setting.
+	lfs.ChecksumAlgo = SHA256
+	for _, algo := range algos {
+		path := "/" + string(algo) + ".txt"
+		ok, err := lfs.Verify(charmID, path)
+		if err != nil {
+			t.Fatalf("expected no error verifying %s, %v", path, err)
+		}
+		if !ok {
+			t.Fatalf("expected %s to verify", path)
+		}
+		if err := os.WriteFile(filepath.Join(lfs.Path, charmID, path), []byte("hello World"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		ok, err = lfs.Verify(charmID, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			t.Fatalf("expected corrupted %s to fail verification", path)
+		}
+	}
+
+	lfs.ChecksumAlgo = "md5"
+	if err := lfs.Put(charmID, "/md5.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err == nil {
+		t.Fatalf("expected error for an unknown checksum algorithm")
+	}
+}
+
+func TestVerifyOnGet(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.VerifyOnGet = true
+	if err := lfs.Put(charmID, "/secret.enc", bytes.NewBufferString("ciphertext"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	f, err := lfs.Get(charmID, "/secret.enc")
+	if err != nil {
+		t.Fatalf("expected no error getting intact file, %v", err)
+	}
+	f.Close() // nolint:errcheck
+
+	fp := filepath.Join(lfs.Path, charmID, "secret.enc")
+	b, err := os.ReadFile(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xff
+	if err := os.WriteFile(fp, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lfs.Get(charmID, "/secret.enc"); !errors.Is(err, storage.ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt, got %v", err)
+	}
+}
+
+func TestVerifyOnGetDuringPut(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.VerifyOnGet = true
+	if err := lfs.Put(charmID, "/busy.txt", bytes.NewBufferString("0"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			if err := lfs.Put(charmID, "/busy.txt", bytes.NewBufferString(fmt.Sprint(i)), fs.FileMode(0o644)); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for i := 0; i < 1000; i++ {
+		f, err := lfs.Get(charmID, "/busy.txt")
+		if err != nil {
+			t.Fatalf("expected a file being replaced to verify, got %v", err)
+		}
+		f.Close() // nolint:errcheck
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPutExpectedChecksum(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.ChecksumAlgo = BLAKE3
+	// sha256 of "hello world".
+	const sum = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+	for _, want := range []string{sum, "sha256:" + sum, strings.ToUpper(sum)} {
+		opts := storage.PutOptions{ExpectedChecksum: want}
+		if err := lfs.PutWithOptions(charmID, "/ok.txt", bytes.NewBufferString("hello world"), fs.FileMode(0o644), opts); err != nil {
+			t.Fatalf("expected the data to match %s, got %v", want, err)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(lfs.Path, charmID, "ok.txt")); err != nil || string(data) != "hello world" {
+		t.Fatalf("expected the file to be stored, got %q, %v", data, err)
+	}
+
+	opts := storage.PutOptions{ExpectedChecksum: "sha256:" + sum}
+	err = lfs.PutWithOptions(charmID, "/bad.txt", bytes.NewBufferString("hello w0rld"), fs.FileMode(0o644), opts)
+	if !errors.Is(err, storage.ErrChecksumMismatch) {
+		t.Fatalf("expected storage.ErrChecksumMismatch, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(lfs.Path, charmID, "bad.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected no file to be written, got %v", err)
+	}
+	des, err := os.ReadDir(filepath.Join(lfs.Path, charmID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, de := range des {
+		if strings.HasSuffix(de.Name(), ".tmp") {
+			t.Fatalf("expected the staged file to be removed, found %s", de.Name())
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package localstorage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// compressedMagic starts every file stored compressed, so whether a file is
+// compressed is known from the file alone. It's followed by the size of the
+// file before compression, as 8 big-endian bytes, and then the gzip stream.
+var compressedMagic = []byte("\x89charm-gzip\r\n\x1a\n")
+
+// compressedHeaderSize is the size of the header of a compressed file.
+var compressedHeaderSize = int64(len(compressedMagic) + 8)
+
+// compressedHeader returns the header of a compressed file holding n bytes.
+func compressedHeader(n int64) []byte {
+	h := make([]byte, compressedHeaderSize)
+	copy(h, compressedMagic)
+	binary.BigEndian.PutUint64(h[len(compressedMagic):], uint64(n))
+	return h
+}
+
+// readCompressedHeader returns the size before compression recorded in the
+// header of f, and false if f isn't a compressed file.
+func readCompressedHeader(f *os.File) (int64, bool, error) {
+	if info, err := f.Stat(); err != nil || !info.Mode().IsRegular() {
+		return 0, false, err
+	}
+	h := make([]byte, compressedHeaderSize)
+	if _, err := f.ReadAt(h, 0); err == io.EOF {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	if !bytes.Equal(h[:len(compressedMagic)], compressedMagic) {
+		return 0, false, nil
+	}
+	return int64(binary.BigEndian.Uint64(h[len(compressedMagic):])), true, nil
+}
+
+// logicalSize returns the size of the file at fp before it was compressed, and
+// false if it isn't compressed.
+func (lfs *LocalFileStore) logicalSize(fp string) (int64, bool, error) {
+	f, err := os.Open(fp)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close() // nolint:errcheck
+	return readCompressedHeader(f)
+}
+
+// compressedWriter writes a compressed file to f. Close fills in the header
+// once the size before compression is known.
+type compressedWriter struct {
+	f  *os.File
+	zw *gzip.Writer
+	n  int64
+}
+
+// newCompressedWriter returns a compressedWriter for f that writes through w,
+// which writes to f.
+func newCompressedWriter(f *os.File, w io.Writer) (*compressedWriter, error) {
+	if _, err := w.Write(compressedHeader(0)); err != nil {
+		return nil, err
+	}
+	return &compressedWriter{f: f, zw: gzip.NewWriter(w)}, nil
+}
+
+func (cw *compressedWriter) Write(p []byte) (int, error) {
+	n, err := cw.zw.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+func (cw *compressedWriter) Close() error {
+	if err := cw.zw.Close(); err != nil {
+		return err
+	}
+	_, err := cw.f.WriteAt(compressedHeader(cw.n), 0)
+	return err
+}
+
+// compressFile compresses the file at fp in place and returns its size
+// before compression.
+func compressFile(fp string) (int64, error) {
+	in, err := os.Open(fp)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close() // nolint:errcheck
+	out, err := os.CreateTemp(filepath.Dir(fp), fmt.Sprintf(".%s.*.tmp", filepath.Base(fp)))
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(out.Name()) // nolint:errcheck
+	defer out.Close()           // nolint:errcheck
+	cw, err := newCompressedWriter(out, out)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(cw, in)
+	if err != nil {
+		return 0, err
+	}
+	if err := cw.Close(); err != nil {
+		return 0, err
+	}
+	if err := out.Close(); err != nil {
+		return 0, err
+	}
+	return n, os.Rename(out.Name(), fp)
+}
+
+// recompress compresses the staged file f in place and returns it reopened.
+func recompress(f *os.File) (*os.File, error) {
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if _, err := compressFile(f.Name()); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(f.Name(), os.O_RDWR, 0)
+}
+
+// openStored opens the file at fp for reading its contents, decompressing it
+// if it's stored compressed.
+func (lfs *LocalFileStore) openStored(fp string) (fs.File, error) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	n, ok, err := readCompressedHeader(f)
+	if err != nil || !ok {
+		if err != nil {
+			f.Close() // nolint:errcheck
+		}
+		return f, err
+	}
+	zr, err := gzip.NewReader(io.NewSectionReader(f, compressedHeaderSize, 1<<63-1))
+	if err != nil {
+		f.Close() // nolint:errcheck
+		return nil, err
+	}
+	return &compressedFile{f: f, zr: zr, size: n}, nil
+}
+
+// compressedFile is a compressed file read through gzip. Stat reports its
+// logical size.
+type compressedFile struct {
+	f    *os.File
+	zr   *gzip.Reader
+	size int64
+}
+
+func (cf *compressedFile) Read(p []byte) (int, error) {
+	return cf.zr.Read(p)
+}
+
+func (cf *compressedFile) Close() error {
+	return cf.f.Close()
+}
+
+func (cf *compressedFile) Stat() (fs.FileInfo, error) {
+	info, err := cf.f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return logicalInfo{info, cf.size}, nil
+}
+
+// logicalInfo reports the logical size of a compressed file.
+type logicalInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (li logicalInfo) Size() int64 {
+	return li.size
+}
@@ -0,0 +1,130 @@
+package localstorage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	charm "github.com/charmbracelet/charm/proto"
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+func TestCompress(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.Compress = true
+	content := bytes.Repeat([]byte("charm "), 1000)
+	if err := lfs.Put(charmID, "/docs/big.txt", bytes.NewReader(content), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := lfs.Get(charmID, "/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dir charm.FileInfo
+	err = json.NewDecoder(f).Decode(&dir)
+	f.Close() // nolint:errcheck
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dir.Files) != 1 {
+		t.Fatalf("expected 1 file in listing, got %d", len(dir.Files))
+	}
+	if fi := dir.Files[0]; fi.Size != int64(len(content)) || fi.StoredSize >= fi.Size || fi.StoredSize == 0 {
+		t.Fatalf("expected logical size %d and a smaller stored size, got %d and %d", len(content), fi.Size, fi.StoredSize)
+	}
+
+	f, err = lfs.Get(charmID, "/docs/big.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(f)
+	f.Close() // nolint:errcheck
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Fatalf("expected decompressed content")
+	}
+	ok, err := lfs.Verify(charmID, "/docs/big.txt")
+	if err != nil || !ok {
+		t.Fatalf("expected checksum of decompressed content to match, %t %v", ok, err)
+	}
+	if err := lfs.PutAt(charmID, "/docs/big.txt", 0, bytes.NewBufferString("x")); !errors.Is(err, storage.ErrInvalidTarget) {
+		t.Fatalf("expected ErrInvalidTarget patching a compressed file, got %v", err)
+	}
+
+	// Files written without compression are read as is.
+	lfs.Compress = false
+	if err := lfs.Put(charmID, "/docs/big.txt", bytes.NewBufferString("plain"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	info, err := lfs.Stat(charmID, "/docs/big.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 5 {
+		t.Fatalf("expected size 5, got %d", info.Size())
+	}
+	if err := lfs.Delete(charmID, "/docs"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Data that looks like a compressed file is read back as written.
+	forged := append(append([]byte(nil), compressedMagic...), "not gzip"...)
+	if err := lfs.Put(charmID, "/forged.bin", bytes.NewReader(forged), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	f, err = lfs.Get(charmID, "/forged.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = io.ReadAll(f)
+	f.Close() // nolint:errcheck
+	if err != nil || !bytes.Equal(b, forged) {
+		t.Fatalf("expected the data to be read back as written, got %q, %v", b, err)
+	}
+}
+
+func TestCompressQuota(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.Compress = true
+	lfs.QuotaBytes = 1000
+	// The quota counts the bytes on disk, not those uploaded.
+	content := bytes.Repeat([]byte("charm "), 1000)
+	if err := lfs.Put(charmID, "/small.txt", &opaqueReader{bytes.NewReader(content)}, fs.FileMode(0o644)); err != nil {
+		t.Fatalf("expected data that compresses below the quota to fit, got %v", err)
+	}
+	usage, err := lfs.Usage(charmID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage > lfs.QuotaBytes {
+		t.Fatalf("expected usage within the quota, got %d", usage)
+	}
+	random := make([]byte, 2000)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/big.bin", &opaqueReader{bytes.NewReader(random)}, fs.FileMode(0o644)); !errors.Is(err, storage.ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(lfs.Path, charmID, "big.bin")); !os.IsNotExist(err) {
+		t.Fatalf("expected the file over the quota not to be stored, got %v", err)
+	}
+}
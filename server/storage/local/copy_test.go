@@ -0,0 +1,130 @@
+package localstorage
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/charmbracelet/charm/server/storage"
+	memstorage "github.com/charmbracelet/charm/server/storage/mem"
+	"github.com/google/uuid"
+)
+
+func TestCopyFrom(t *testing.T) {
+	files := map[string]string{
+		"hello.txt":   "hello",
+		"foo/bar.txt": "bar",
+	}
+	dst, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcs := map[string]storage.FileStore{
+		"local": local,
+		"mem":   memstorage.NewMemFileStore(),
+	}
+	for name, src := range srcs {
+		t.Run(name, func(t *testing.T) {
+			srcID := uuid.New().String()
+			dstID := uuid.New().String()
+			for path, content := range files {
+				if err := src.Put(srcID, path, bytes.NewBufferString(content), fs.FileMode(0o640)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := dst.CopyFrom(dstID, "/single.txt", src, srcID, "/hello.txt"); err != nil {
+				t.Fatalf("expected no error when copying a file, %v", err)
+			}
+			if err := dst.CopyFrom(dstID, "/copy", src, srcID, "/"); err != nil {
+				t.Fatalf("expected no error when copying a directory, %v", err)
+			}
+
+			want := map[string]string{"single.txt": "hello"}
+			for path, content := range files {
+				want[filepath.Join("copy", path)] = content
+			}
+			for path, content := range want {
+				fp := filepath.Join(dst.Path, dstID, path)
+				b, err := os.ReadFile(fp)
+				if err != nil {
+					t.Fatalf("expected %s to be copied, %v", path, err)
+				}
+				if string(b) != content {
+					t.Fatalf("expected content of %s to be %s, got %s", path, content, string(b))
+				}
+				info, err := os.Stat(fp)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Mode().Perm() != 0o640 {
+					t.Fatalf("expected mode of %s to be preserved, got %v", path, info.Mode())
+				}
+			}
+		})
+	}
+}
+
+func TestCopyFromListingFormats(t *testing.T) {
+	for _, format := range []ListingFormat{ListingJSON, ListingMsgpack, ListingNDJSON} {
+		t.Run(string(format), func(t *testing.T) {
+			src, err := NewLocalFileStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			src.ListingFormat = format
+			dst := memstorage.NewMemFileStore()
+			srcID := uuid.New().String()
+			dstID := uuid.New().String()
+			for _, path := range []string{"/dir/x.txt", "/dir/sub/y.txt"} {
+				if err := src.Put(srcID, path, bytes.NewBufferString(path), fs.FileMode(0o644)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := dst.CopyFrom(dstID, "/copy", src, srcID, "/dir"); err != nil {
+				t.Fatalf("expected no error copying out of a %s store, got %v", format, err)
+			}
+			for _, path := range []string{"/copy/x.txt", "/copy/sub/y.txt"} {
+				if _, err := dst.Stat(dstID, path); err != nil {
+					t.Fatalf("expected %s to be copied out of a %s store, got %v", path, format, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCopyFromIndexFile(t *testing.T) {
+	src, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.IndexFile = "index.html"
+	dst, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcID := uuid.New().String()
+	dstID := uuid.New().String()
+	for _, path := range []string{"/d/index.html", "/d/style.css"} {
+		if err := src.Put(srcID, path, bytes.NewBufferString(path), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dst.CopyFrom(dstID, "/d", src, srcID, "/d"); err != nil {
+		t.Fatal(err)
+	}
+	if isDir, err := dst.IsDir(dstID, "/d"); err != nil || !isDir {
+		t.Fatalf("expected /d to be copied as a directory, got %v, %v", isDir, err)
+	}
+	for _, path := range []string{"/d/index.html", "/d/style.css"} {
+		if _, err := dst.Stat(dstID, path); err != nil {
+			t.Fatalf("expected %s to be copied, got %v", path, err)
+		}
+	}
+}
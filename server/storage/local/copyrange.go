@@ -0,0 +1,122 @@
+package localstorage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// CopyRange copies length bytes of the file at srcPath for the Charm ID,
+// starting at srcOffset, into the file at dstPath starting at dstOffset,
+// without the data leaving the server. Like PutAt, the destination is created
+// if it doesn't exist, extended if the range runs past its end, and changed in
+// place. The copy uses copy_file_range where it's available, so the data
+// needn't pass through user space. The source must hold the whole range, and
+// the two paths must differ. Files stored compressed can't be copied from or
+// into.
+func (lfs *LocalFileStore) CopyRange(charmID, srcPath string, srcOffset, length int64, dstPath string, dstOffset int64) error {
+	defer lfs.op()()
+	if srcOffset < 0 || dstOffset < 0 || length < 0 {
+		return storage.ErrInvalidOffset
+	}
+	for _, path := range []string{srcPath, dstPath} {
+		if cpath := filepath.Clean(path); cpath == string(os.PathSeparator) || inReserved(cpath) {
+			return fmt.Errorf("invalid path specified: %s", cpath)
+		}
+	}
+	sp := filepath.Join(lfs.root(), charmID, srcPath)
+	fp := filepath.Join(lfs.root(), charmID, dstPath)
+	if sp == fp {
+		return storage.ErrInvalidTarget
+	}
+	src, err := os.Open(sp)
+	if os.IsNotExist(err) {
+		return fs.ErrNotExist
+	}
+	if err != nil {
+		return err
+	}
+	defer src.Close() // nolint:errcheck
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return storage.ErrInvalidTarget
+	}
+	if info.Size() < srcOffset+length {
+		return fmt.Errorf("copy range of %s: %w", srcPath, io.ErrUnexpectedEOF)
+	}
+
+	defer lfs.lockPath(fp)()
+	var reservationID string
+	defer func() { lfs.releaseQuota(charmID, reservationID) }()
+	defer lfs.trackUsage(charmID, fp)()
+	for _, p := range []string{sp, fp} {
+		if _, ok, err := lfs.logicalSize(p); err != nil || ok {
+			if err != nil {
+				return err
+			}
+			return storage.ErrInvalidTarget
+		}
+	}
+	size := dstOffset + length
+	var existing int64
+	if info, err := os.Lstat(fp); err == nil {
+		if info.IsDir() || info.Mode()&specialModes != 0 {
+			return storage.ErrInvalidTarget
+		}
+		existing = info.Size()
+		if existing > size {
+			size = existing
+		}
+	}
+	if lfs.MaxFileBytes > 0 && size > lfs.MaxFileBytes {
+		return storage.ErrFileTooLarge
+	}
+	allowed, err := lfs.allowance(charmID, fp, "")
+	if err != nil {
+		return err
+	}
+	if allowed >= 0 && size > allowed {
+		return storage.ErrQuotaExceeded
+	}
+	if err := lfs.sniffPatch(fp, dstOffset, io.NewSectionReader(src, srcOffset, length), length); err != nil {
+		return err
+	}
+	if reservationID, err = lfs.claimQuota(charmID, size, existing); err != nil {
+		return err
+	}
+	if err := lfs.ensureParent(charmID, dstPath, 0o755); err != nil {
+		return err
+	}
+	if err := unshare(fp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	dst, err := os.OpenFile(fp, os.O_WRONLY|os.O_CREATE, defaultFileMode(fp))
+	if err != nil {
+		return err
+	}
+	defer dst.Close() // nolint:errcheck
+	if _, err := copyFileRange(dst, src, srcOffset, dstOffset, length); err != nil {
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	lfs.patched(charmID, dstPath, fp)
+	return nil
+}
+
+// copyRangeBuffered copies n bytes from src at srcOffset to dst at dstOffset
+// through a buffer. It's used where copy_file_range isn't available.
+func copyRangeBuffered(dst, src *os.File, srcOffset, dstOffset, n int64) (int64, error) {
+	if _, err := dst.Seek(dstOffset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(struct{ io.Writer }{dst}, io.NewSectionReader(src, srcOffset, n))
+}
@@ -0,0 +1,31 @@
+package localstorage
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyFileRange copies n bytes from src at srcOffset to dst at dstOffset with
+// copy_file_range, falling back to a buffered copy if the kernel or file
+// system doesn't support it.
+func copyFileRange(dst, src *os.File, srcOffset, dstOffset, n int64) (int64, error) {
+	var copied int64
+	for copied < n {
+		roff, woff := srcOffset+copied, dstOffset+copied
+		m, err := unix.CopyFileRange(int(src.Fd()), &roff, int(dst.Fd()), &woff, int(n-copied), 0)
+		switch {
+		case errors.Is(err, unix.ENOSYS), errors.Is(err, unix.EXDEV), errors.Is(err, unix.EINVAL), errors.Is(err, unix.EOPNOTSUPP):
+			rest, err := copyRangeBuffered(dst, src, srcOffset+copied, dstOffset+copied, n-copied)
+			return copied + rest, err
+		case err != nil:
+			return copied, err
+		case m == 0:
+			return copied, io.ErrUnexpectedEOF
+		}
+		copied += int64(m)
+	}
+	return copied, nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package localstorage
+
+import "os"
+
+// copyFileRange copies n bytes from src at srcOffset to dst at dstOffset.
+// There's no copy_file_range outside Linux, so it's a buffered copy.
+func copyFileRange(dst, src *os.File, srcOffset, dstOffset, n int64) (int64, error) {
+	return copyRangeBuffered(dst, src, srcOffset, dstOffset, n)
+}
@@ -0,0 +1,91 @@
+package localstorage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCopyRange(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func(name string) string {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(lfs.Path, charmID, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	if err := lfs.Put(charmID, "/src.txt", bytes.NewBufferString("0123456789"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/dst.txt", bytes.NewBufferString("abcdefghij"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lfs.CopyRange(charmID, "/src.txt", 3, 4, "/dst.txt", 2); err != nil {
+		t.Fatalf("expected no error splicing, %v", err)
+	}
+	if got := read("dst.txt"); got != "ab3456ghij" {
+		t.Fatalf("expected spliced content, got %q", got)
+	}
+	ok, err := lfs.Verify(charmID, "/dst.txt")
+	if err != nil || !ok {
+		t.Fatalf("expected checksum to be updated, %t %v", ok, err)
+	}
+
+	if err := lfs.CopyRange(charmID, "/src.txt", 8, 2, "/new.txt", 1); err != nil {
+		t.Fatalf("expected no error copying into a new file, %v", err)
+	}
+	if got := read("new.txt"); got != "\x0089" {
+		t.Fatalf("expected zero-filled gap, got %q", got)
+	}
+
+	if err := lfs.CopyRange(charmID, "/src.txt", 8, 4, "/dst.txt", 0); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected ErrUnexpectedEOF for a range past the end of the source, got %v", err)
+	}
+	if got := read("dst.txt"); got != "ab3456ghij" {
+		t.Fatalf("expected destination to be untouched, got %q", got)
+	}
+}
+
+func TestCopyRangeBuffered(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "src"), []byte("0123456789"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "dst"), []byte("abcdefghij"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	src, err := os.Open(filepath.Join(dir, "src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close() // nolint:errcheck
+	dst, err := os.OpenFile(filepath.Join(dir, "dst"), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close() // nolint:errcheck
+	n, err := copyRangeBuffered(dst, src, 3, 2, 4)
+	if err != nil || n != 4 {
+		t.Fatalf("expected 4 bytes copied, got %d %v", n, err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "dst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "ab3456ghij" {
+		t.Fatalf("expected spliced content, got %q", b)
+	}
+}
@@ -0,0 +1,108 @@
+package localstorage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// blobsDir is the top-level directory deduplicated file contents are kept in.
+const blobsDir = "blobs"
+
+// dedup replaces the newly stored file at fp with a hard link to the blob
+// holding the same content and mode, or makes the file that blob if there's
+// none yet. A blob's references are its other links, so a blob with a single
+// link is orphaned. Files hashed with XXH3 aren't deduplicated as it isn't
+// collision resistant.
+func (lfs *LocalFileStore) dedup(fp, sum string) error {
+	algo, hex := parseChecksum(sum)
+	if algo == XXH3 {
+		return nil
+	}
+	info, err := os.Stat(fp)
+	if err != nil {
+		return err
+	}
+	if _, ok := linkCount(info); !ok {
+		return nil
+	}
+	bp := filepath.Join(lfs.root(), blobsDir, string(algo), fmt.Sprintf("%s-%o", hex, info.Mode().Perm()))
+	if err := os.MkdirAll(filepath.Dir(bp), 0o700); err != nil {
+		return err
+	}
+	for {
+		err := os.Link(fp, bp)
+		if !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+		tmp := fmt.Sprintf("%s.%s.tmp", filepath.Join(filepath.Dir(fp), "."+filepath.Base(fp)), hex[:8])
+		err = os.Link(bp, tmp)
+		if errors.Is(err, fs.ErrNotExist) {
+			// The blob was collected in the meantime, so store ours instead.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := rename(tmp, fp); err != nil {
+			os.Remove(tmp) // nolint:errcheck
+			return err
+		}
+		return nil
+	}
+}
+
+// GarbageCollectBlobs removes deduplicated blobs no file refers to anymore and
+// returns the number of bytes freed. Every file referring to a blob is a hard
+// link to it, so orphans are found from their link count rather than by
+// scanning users' files. A Put racing with collection can't lose data: its
+// file keeps the content alive, it just isn't deduplicated.
+func (lfs *LocalFileStore) GarbageCollectBlobs() (int64, error) {
+	defer lfs.op()()
+	var freed int64
+	err := filepath.WalkDir(filepath.Join(lfs.root(), blobsDir), func(fp string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if n, ok := linkCount(info); !ok || n > 1 {
+			return nil
+		}
+		if err := os.Remove(fp); err != nil {
+			return err
+		}
+		freed += info.Size()
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	return freed, err
+}
+
+// unshare gives the file at fp its own copy of its content if it's a link to
+// a blob, so it can be changed in place.
+func unshare(fp string) error {
+	info, err := os.Stat(fp)
+	if err != nil {
+		return err
+	}
+	if n, ok := linkCount(info); !ok || n < 2 {
+		return nil
+	}
+	tmp := fmt.Sprintf("%s.unshare.tmp", filepath.Join(filepath.Dir(fp), "."+filepath.Base(fp)))
+	if err := copyFile(fp, tmp, info.Mode().Perm()); err != nil {
+		os.Remove(tmp) // nolint:errcheck
+		return err
+	}
+	if err := os.Chtimes(tmp, info.ModTime(), info.ModTime()); err != nil {
+		os.Remove(tmp) // nolint:errcheck
+		return err
+	}
+	return rename(tmp, fp)
+}
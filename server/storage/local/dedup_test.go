@@ -0,0 +1,142 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package localstorage
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestGarbageCollectBlobs(t *testing.T) {
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.Dedup = true
+	alice, bob := uuid.New().String(), uuid.New().String()
+	shared := strings.Repeat("shared", 100)
+	orphan := strings.Repeat("orphan", 50)
+	for _, id := range []string{alice, bob} {
+		if err := lfs.Put(id, "/shared.txt", bytes.NewBufferString(shared), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := lfs.Put(alice, "/orphan.txt", bytes.NewBufferString(orphan), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	a, err := os.Stat(filepath.Join(lfs.Path, alice, "shared.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.Stat(filepath.Join(lfs.Path, bob, "shared.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(a, b) {
+		t.Fatal("expected identical files to be deduplicated")
+	}
+
+	if err := lfs.Delete(alice, "/orphan.txt"); err != nil {
+		t.Fatal(err)
+	}
+	freed, err := lfs.GarbageCollectBlobs()
+	if err != nil {
+		t.Fatalf("expected no error collecting blobs, %v", err)
+	}
+	if freed != int64(len(orphan)) {
+		t.Fatalf("expected %d bytes freed, got %d", len(orphan), freed)
+	}
+	if freed, err := lfs.GarbageCollectBlobs(); err != nil || freed != 0 {
+		t.Fatalf("expected nothing left to collect, got %d, %v", freed, err)
+	}
+
+	// Removing one reference keeps the blob for the other.
+	if err := lfs.Delete(alice, "/shared.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if freed, err := lfs.GarbageCollectBlobs(); err != nil || freed != 0 {
+		t.Fatalf("expected referenced blob to be kept, got %d, %v", freed, err)
+	}
+	got, err := os.ReadFile(filepath.Join(lfs.Path, bob, "shared.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != shared {
+		t.Fatal("expected remaining reference to keep its content")
+	}
+
+	// Patching a deduplicated file in place doesn't change the others.
+	if err := lfs.Put(alice, "/shared.txt", bytes.NewBufferString(shared), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.PutAt(alice, "/shared.txt", 0, bytes.NewBufferString("SHARED")); err != nil {
+		t.Fatal(err)
+	}
+	got, err = os.ReadFile(filepath.Join(lfs.Path, bob, "shared.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != shared {
+		t.Fatal("expected PutAt not to change other references")
+	}
+}
+
+func TestFixModesDedup(t *testing.T) {
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.Dedup = true
+	alice, bob, carol, dave := uuid.New().String(), uuid.New().String(), uuid.New().String(), uuid.New().String()
+	content := strings.Repeat("key", 100)
+	for _, id := range []string{alice, bob} {
+		if err := lfs.Put(id, "/key", bytes.NewBufferString(content), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := lfs.FixModes(alice, func(string, fs.FileMode) (fs.FileMode, bool) { return 0o600, true }); err != nil {
+		t.Fatal(err)
+	}
+	mode := func(id string) fs.FileMode {
+		info, err := os.Stat(filepath.Join(lfs.Path, id, "key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Mode().Perm()
+	}
+	if m := mode(alice); m != 0o600 {
+		t.Fatalf("expected alice's file to be fixed, got %v", m)
+	}
+	if m := mode(bob); m != 0o644 {
+		t.Fatalf("expected bob's file to keep its mode, got %v", m)
+	}
+	if err := lfs.Put(carol, "/key", bytes.NewBufferString(content), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if m := mode(carol); m != 0o644 {
+		t.Fatalf("expected carol's new file to keep its mode, got %v", m)
+	}
+
+	// The fixed file is deduplicated under its new mode.
+	if err := lfs.Put(dave, "/key", bytes.NewBufferString(content), fs.FileMode(0o600)); err != nil {
+		t.Fatal(err)
+	}
+	a, err := os.Stat(filepath.Join(lfs.Path, alice, "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := os.Stat(filepath.Join(lfs.Path, dave, "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(a, d) {
+		t.Fatal("expected the fixed file to be deduplicated under its new mode")
+	}
+}
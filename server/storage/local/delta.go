@@ -0,0 +1,253 @@
+package localstorage
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// Content-defined chunking parameters. Blocks average 8KiB.
+const (
+	chunkMin  = 2 << 10
+	chunkMax  = 64 << 10
+	chunkMask = uint64(1<<13-1) << (64 - 13)
+)
+
+// gear holds the random values the rolling hash used for chunking mixes in
+// for each byte.
+var gear [256]uint64
+
+func init() {
+	// splitmix64, so the table is the same everywhere.
+	x := uint64(0x6368617266696c65)
+	for i := range gear {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// chunker splits a stream into content-defined blocks. Where a block ends only
+// depends on the bytes since it started, so a block is split the same way
+// whatever comes before it.
+type chunker struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func newChunker(r io.Reader) *chunker {
+	return &chunker{r: bufio.NewReader(r), buf: make([]byte, 0, chunkMax)}
+}
+
+// next returns the next block, which is only valid until the following call,
+// or io.EOF once the stream is exhausted.
+func (c *chunker) next() ([]byte, error) {
+	c.buf = c.buf[:0]
+	var h uint64
+	for {
+		b, err := c.r.ReadByte()
+		if err == io.EOF && len(c.buf) > 0 {
+			return c.buf, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.buf = append(c.buf, b)
+		h = h<<1 + gear[b]
+		if (len(c.buf) >= chunkMin && h&chunkMask == 0) || len(c.buf) >= chunkMax {
+			return c.buf, nil
+		}
+	}
+}
+
+// blockHash returns the address of a block.
+func blockHash(block []byte) string {
+	sum := sha256.Sum256(block)
+	return hex.EncodeToString(sum[:])
+}
+
+// ChunkBlocks splits the data from r into the content-defined blocks used by
+// PutDelta and calls fn with the hash and contents of each, in order. The
+// block is only valid until fn returns.
+func ChunkBlocks(r io.Reader, fn func(hash string, block []byte) error) error {
+	c := newChunker(r)
+	for {
+		block, err := c.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(blockHash(block), block); err != nil {
+			return err
+		}
+	}
+}
+
+// blockRef locates a block in a stored file.
+type blockRef struct {
+	offset int64
+	size   int64
+}
+
+// blockIndex chunks the file at fp and returns where each of its blocks is.
+// A file that doesn't exist or is stored compressed has no blocks to reuse.
+func (lfs *LocalFileStore) blockIndex(fp string) (map[string]blockRef, error) {
+	f, err := os.Open(fp)
+	if os.IsNotExist(err) {
+		return make(map[string]blockRef), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // nolint:errcheck
+	return lfs.indexBlocks(fp, f)
+}
+
+// indexBlocks is like blockIndex for the already open file at fp.
+func (lfs *LocalFileStore) indexBlocks(fp string, f *os.File) (map[string]blockRef, error) {
+	idx := make(map[string]blockRef)
+	if _, ok, err := lfs.logicalSize(fp); err != nil || ok {
+		return idx, err
+	}
+	if info, err := f.Stat(); err != nil || !info.Mode().IsRegular() {
+		return idx, err
+	}
+	var offset int64
+	err := ChunkBlocks(f, func(hash string, block []byte) error {
+		if _, ok := idx[hash]; !ok {
+			idx[hash] = blockRef{offset: offset, size: int64(len(block))}
+		}
+		offset += int64(len(block))
+		return nil
+	})
+	return idx, err
+}
+
+// MissingBlocks returns the hashes, out of the given ones, of the blocks that
+// aren't part of the file currently stored at path for the Charm ID. A client
+// splits the new version of a file with ChunkBlocks, asks which blocks are
+// missing, and sends only those to PutDelta.
+func (lfs *LocalFileStore) MissingBlocks(charmID, path string, hashes []string) ([]string, error) {
+	defer lfs.op()()
+	idx, err := lfs.blockIndex(filepath.Join(lfs.root(), charmID, path))
+	if err != nil {
+		return nil, err
+	}
+	missing := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, h := range hashes {
+		if _, ok := idx[h]; !ok && !seen[h] {
+			missing = append(missing, h)
+			seen[h] = true
+		}
+	}
+	return missing, nil
+}
+
+// PutDelta stores a new version of the file at path for the Charm ID from the
+// blocks of the current version and the data in r. have lists the hashes of
+// the new version's blocks, as produced by ChunkBlocks, in order. r holds the
+// blocks that the current version doesn't have, in the order they first appear
+// in have, with nothing in between. Blocks read from r are checked against
+// their hashes and storage.ErrCorrupt is returned on a mismatch. The new
+// version is written like any other Put, so it's staged, counted against the
+// quota and checksummed, and keeps the current version's mode.
+func (lfs *LocalFileStore) PutDelta(charmID, path string, have []string, r io.Reader) error {
+	defer lfs.op()()
+	if cpath := filepath.Clean(path); cpath == string(os.PathSeparator) || inReserved(cpath) {
+		return fmt.Errorf("invalid path specified: %s", cpath)
+	}
+	fp := filepath.Join(lfs.root(), charmID, path)
+	var mode fs.FileMode
+	if info, err := os.Stat(fp); err == nil {
+		if info.IsDir() {
+			return storage.ErrIsDirectory
+		}
+		mode = info.Mode().Perm()
+	}
+	// The current version stays readable through old even once it's
+	// replaced.
+	idx := make(map[string]blockRef)
+	old, err := os.Open(fp)
+	switch {
+	case err == nil:
+		defer old.Close() // nolint:errcheck
+		if idx, err = lfs.indexBlocks(fp, old); err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+	// New blocks that appear again later are kept to be written again.
+	remaining := make(map[string]int)
+	for _, h := range have {
+		if _, ok := idx[h]; !ok {
+			remaining[h]++
+		}
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		c := newChunker(r)
+		received := make(map[string][]byte)
+		var err error
+		for _, h := range have {
+			if err = writeBlock(pw, h, old, idx, c, received, remaining); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			if _, err = c.next(); err == io.EOF {
+				err = nil
+			} else if err == nil {
+				err = fmt.Errorf("put delta %s: unexpected data after the last block: %w", path, storage.ErrCorrupt)
+			}
+		}
+		pw.CloseWithError(err) // nolint:errcheck
+	}()
+	err = lfs.Put(charmID, path, pr, mode)
+	pr.CloseWithError(err) // nolint:errcheck
+	return err
+}
+
+// writeBlock writes the block with hash h to w, from the current version of
+// the file if it has it, from the blocks already received if it was sent
+// earlier, or otherwise as the next block sent.
+func writeBlock(w io.Writer, h string, old *os.File, idx map[string]blockRef, c *chunker, received map[string][]byte, remaining map[string]int) error {
+	if ref, ok := idx[h]; ok {
+		_, err := io.Copy(w, io.NewSectionReader(old, ref.offset, ref.size))
+		return err
+	}
+	block, ok := received[h]
+	if !ok {
+		var err error
+		block, err = c.next()
+		if err == io.EOF {
+			return fmt.Errorf("missing block %s: %w", h, io.ErrUnexpectedEOF)
+		}
+		if err != nil {
+			return err
+		}
+		if blockHash(block) != h {
+			return fmt.Errorf("block %s: %w", h, storage.ErrCorrupt)
+		}
+	}
+	if remaining[h]--; remaining[h] > 0 {
+		received[h] = append([]byte(nil), block...)
+	} else {
+		delete(received, h)
+	}
+	_, err := w.Write(block)
+	return err
+}
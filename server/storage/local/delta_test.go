@@ -0,0 +1,135 @@
+package localstorage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// delta returns the block list of data, the blocks the file at path is missing
+// and their contents, as a client would send them to PutDelta.
+func delta(t *testing.T, lfs *LocalFileStore, charmID, path string, data []byte) ([]string, []string, []byte) {
+	t.Helper()
+	var hashes []string
+	blocks := make(map[string][]byte)
+	err := ChunkBlocks(bytes.NewReader(data), func(hash string, block []byte) error {
+		hashes = append(hashes, hash)
+		blocks[hash] = append([]byte(nil), block...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing, err := lfs.MissingBlocks(charmID, path, hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	for _, h := range missing {
+		buf.Write(blocks[h])
+	}
+	return hashes, missing, buf.Bytes()
+}
+
+func TestPutDelta(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data) // nolint:errcheck
+	have, _, send := delta(t, lfs, charmID, "/big.bin", data)
+	if !bytes.Equal(send, data) {
+		t.Fatalf("expected every block to be missing for a new file")
+	}
+	if err := lfs.PutDelta(charmID, "/big.bin", have, bytes.NewReader(send)); err != nil {
+		t.Fatalf("expected no error storing a new file, %v", err)
+	}
+
+	edited := append([]byte(nil), data...)
+	copy(edited[500000:], "charm")
+	have, missing, send := delta(t, lfs, charmID, "/big.bin", edited)
+	if len(missing) != 1 || len(send) > chunkMax {
+		t.Fatalf("expected a single block to be sent, got %d blocks of %d bytes", len(missing), len(send))
+	}
+	cr := &countingReader{r: bytes.NewReader(send)}
+	if err := lfs.PutDelta(charmID, "/big.bin", have, cr); err != nil {
+		t.Fatalf("expected no error storing the delta, %v", err)
+	}
+	if cr.n != int64(len(send)) {
+		t.Fatalf("expected %d bytes to be read, got %d", len(send), cr.n)
+	}
+	b, err := os.ReadFile(filepath.Join(lfs.Path, charmID, "big.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, edited) {
+		t.Fatalf("expected the edited file to be reassembled")
+	}
+	ok, err := lfs.Verify(charmID, "/big.bin")
+	if err != nil || !ok {
+		t.Fatalf("expected checksum to match, %t %v", ok, err)
+	}
+
+	copy(edited[10:], "corrupt")
+	have, _, send = delta(t, lfs, charmID, "/big.bin", edited)
+	send[0] ^= 0xff
+	if err := lfs.PutDelta(charmID, "/big.bin", have, bytes.NewReader(send)); !errors.Is(err, storage.ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt for a tampered block, got %v", err)
+	}
+	b, err = os.ReadFile(filepath.Join(lfs.Path, charmID, "big.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b[10] != data[10] {
+		t.Fatalf("expected file to be untouched after a failed delta")
+	}
+}
+
+func TestChunkBlocksRepeated(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A file made of the same data twice sends its blocks once.
+	half := make([]byte, 200<<10)
+	rand.New(rand.NewSource(2)).Read(half) // nolint:errcheck
+	var hashes []string
+	if err := ChunkBlocks(bytes.NewReader(half), func(hash string, block []byte) error {
+		hashes = append(hashes, hash)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.PutDelta(charmID, "/twice.bin", append(hashes, hashes...), bytes.NewReader(half)); err != nil {
+		t.Fatalf("expected no error, %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(lfs.Path, charmID, "twice.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, append(append([]byte(nil), half...), half...)) {
+		t.Fatalf("expected repeated blocks to be written twice")
+	}
+}
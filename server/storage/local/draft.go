@@ -0,0 +1,69 @@
+package localstorage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// draftsDir is the directory in each Charm ID directory unpublished drafts are
+// kept in. Like the scratch area, it's reserved, so drafts aren't listed and
+// don't count towards usage until they're published.
+const draftsDir = ".drafts"
+
+func init() {
+	reservedNames[draftsDir] = true
+}
+
+// PutDraft writes the data from r as a draft of the file at path for the
+// Charm ID. The draft is hidden until it's published with PublishDraft.
+func (lfs *LocalFileStore) PutDraft(charmID, path string, r io.Reader, mode fs.FileMode) error {
+	defer lfs.op()()
+	if mode.IsDir() {
+		return fmt.Errorf("drafts must be files: %s", path)
+	}
+	dp := lfs.draftPath(charmID, path)
+	defer lfs.lockPath(dp)()
+	return lfs.putHidden(dp, r, mode)
+}
+
+// PublishDraft replaces the file at path for the Charm ID with its draft,
+// which is stored like with Put. Publishing fails with
+// storage.ErrQuotaExceeded if the draft doesn't fit in the quota, in which
+// case the draft is kept.
+func (lfs *LocalFileStore) PublishDraft(charmID, path string) error {
+	defer lfs.op()()
+	dp := lfs.draftPath(charmID, path)
+	defer lfs.lockPath(dp)()
+	f, err := os.Open(dp)
+	if os.IsNotExist(err) {
+		return fs.ErrNotExist
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint:errcheck
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := lfs.Put(charmID, path, f, info.Mode()); err != nil {
+		return err
+	}
+	return os.Remove(dp)
+}
+
+// DiscardDraft removes the draft of the file at path for the Charm ID.
+func (lfs *LocalFileStore) DiscardDraft(charmID, path string) error {
+	defer lfs.op()()
+	dp := lfs.draftPath(charmID, path)
+	defer lfs.lockPath(dp)()
+	return os.RemoveAll(dp)
+}
+
+// draftPath returns the location of the draft of path for the Charm ID.
+func (lfs *LocalFileStore) draftPath(charmID, path string) string {
+	return filepath.Join(lfs.root(), charmID, draftsDir, filepath.Clean("/"+path))
+}
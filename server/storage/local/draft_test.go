@@ -0,0 +1,122 @@
+package localstorage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestDrafts(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/published.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	listed := func(name string) bool {
+		t.Helper()
+		fis, err := lfs.list(charmID, "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, fi := range fis {
+			if fi.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+	usage := func() int64 {
+		t.Helper()
+		n, err := lfs.Usage(charmID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	before := usage()
+
+	t.Run("publish", func(t *testing.T) {
+		if err := lfs.PutDraft(charmID, "/post.md", bytes.NewBufferString("# draft"), fs.FileMode(0o600)); err != nil {
+			t.Fatalf("expected no error saving draft, %v", err)
+		}
+		if listed("post.md") || listed(draftsDir) {
+			t.Fatal("expected draft to be hidden from listings")
+		}
+		if usage() != before {
+			t.Fatal("expected draft not to count towards usage")
+		}
+		if err := lfs.PublishDraft(charmID, "/post.md"); err != nil {
+			t.Fatalf("expected no error publishing, %v", err)
+		}
+		if !listed("post.md") {
+			t.Fatal("expected published draft to be listed")
+		}
+		b, err := os.ReadFile(filepath.Join(lfs.Path, charmID, "post.md"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "# draft" {
+			t.Fatalf("unexpected published content %q", b)
+		}
+		if ok, err := lfs.Verify(charmID, "/post.md"); err != nil || !ok {
+			t.Fatalf("expected published file to have a checksum, %t %v", ok, err)
+		}
+		if err := lfs.PublishDraft(charmID, "/post.md"); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected draft to be gone after publishing, got %v", err)
+		}
+	})
+
+	t.Run("compressed", func(t *testing.T) {
+		lfs.Compress = true
+		defer func() { lfs.Compress = false }()
+		content := bytes.Repeat([]byte("draft "), 1000)
+		if err := lfs.PutDraft(charmID, "/big.md", bytes.NewReader(content), fs.FileMode(0o600)); err != nil {
+			t.Fatal(err)
+		}
+		if err := lfs.PublishDraft(charmID, "/big.md"); err != nil {
+			t.Fatalf("expected no error publishing, %v", err)
+		}
+		if _, ok, err := lfs.logicalSize(filepath.Join(lfs.Path, charmID, "big.md")); err != nil || !ok {
+			t.Fatalf("expected published draft to be stored compressed, %t %v", ok, err)
+		}
+		f, err := lfs.Get(charmID, "/big.md")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(f)
+		f.Close() // nolint:errcheck
+		if err != nil || !bytes.Equal(b, content) {
+			t.Fatalf("expected published content to read back, %v", err)
+		}
+		if ok, err := lfs.Verify(charmID, "/big.md"); err != nil || !ok {
+			t.Fatalf("expected published file to verify, %t %v", ok, err)
+		}
+	})
+
+	t.Run("discard", func(t *testing.T) {
+		if err := lfs.PutDraft(charmID, "/notes/idea.md", bytes.NewBufferString("maybe"), fs.FileMode(0o600)); err != nil {
+			t.Fatal(err)
+		}
+		if err := lfs.DiscardDraft(charmID, "/notes/idea.md"); err != nil {
+			t.Fatalf("expected no error discarding, %v", err)
+		}
+		if listed("notes") {
+			t.Fatal("expected discarded draft to leave no trace in listings")
+		}
+		if _, err := os.Stat(lfs.draftPath(charmID, "/notes/idea.md")); !os.IsNotExist(err) {
+			t.Fatalf("expected draft file to be removed, got %v", err)
+		}
+		if err := lfs.PublishDraft(charmID, "/notes/idea.md"); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected nothing to publish, got %v", err)
+		}
+	})
+}
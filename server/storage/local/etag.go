@@ -0,0 +1,173 @@
+package localstorage
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	charmfs "github.com/charmbracelet/charm/fs"
+	charm "github.com/charmbracelet/charm/proto"
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// GetIfNoneMatch is like Get but only returns the file if its current ETag
+// doesn't match etag, which takes the form of an HTTP If-None-Match header. If
+// it matches, nil and false are returned. Otherwise the file is returned with
+// true.
+func (lfs *LocalFileStore) GetIfNoneMatch(charmID, path, etag string) (fs.File, bool, error) {
+	defer lfs.op()()
+	info, err := lfs.Stat(charmID, path)
+	if err != nil {
+		return nil, false, err
+	}
+	if fi, ok := info.(*charmfs.FileInfo); ok && etagMatch(etag, fi.FileInfo.ETag) {
+		return nil, false, nil
+	}
+	f, err := lfs.Get(charmID, path)
+	if err != nil {
+		return nil, false, err
+	}
+	return f, true, nil
+}
+
+// GetDirIfNoneMatch is like GetIfNoneMatch for the listing of the directory
+// at path, compared against the ETag of its entries rather than of the
+// directory itself, so it changes whenever an entry is added, removed or
+// modified. The listing is returned even if IndexFile is set. Streamed
+// ListingNDJSON listings have no ETag and are always returned.
+func (lfs *LocalFileStore) GetDirIfNoneMatch(charmID, path, etag string) (fs.File, bool, error) {
+	defer lfs.op()()
+	fp := filepath.Join(lfs.root(), charmID, path)
+	info, err := os.Stat(fp)
+	if os.IsNotExist(err) {
+		return nil, false, fs.ErrNotExist
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if !info.IsDir() {
+		return nil, false, storage.ErrNotDirectory
+	}
+	df, err := lfs.listing(charmID, path, info)
+	if err != nil {
+		return nil, false, err
+	}
+	if df.ETag != "" && etagMatch(etag, df.ETag) {
+		return nil, false, nil
+	}
+	return df, true, nil
+}
+
+// DeleteIfMatch deletes the file at path for the Charm ID only if it hasn't
+// changed since the client saw it, that is if etag, which takes the form of an
+// HTTP If-Match header, matches its current ETag or is its recorded checksum.
+// ETags are compared strongly, so a weak ETag never matches. Otherwise the
+// file is kept and storage.ErrConflict is returned.
+func (lfs *LocalFileStore) DeleteIfMatch(charmID, path, etag string) error {
+	defer lfs.op()()
+	fp := filepath.Join(lfs.root(), charmID, path)
+	defer lfs.lockPath(fp)()
+	err := lfs.deleteIfMatch(charmID, path, etag)
+	lfs.audit(charmID, opDelete, path, 0, err)
+	return err
+}
+
+func (lfs *LocalFileStore) deleteIfMatch(charmID, path, etag string) error {
+	info, err := lfs.Stat(charmID, path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return storage.ErrIsDirectory
+	}
+	match := false
+	if fi, ok := info.(*charmfs.FileInfo); ok {
+		match = etagStrongMatch(etag, fi.FileInfo.ETag)
+	}
+	if !match {
+		if sum, err := lfs.Checksum(charmID, path); err == nil {
+			_, got := parseChecksum(sum)
+			for _, tag := range strings.Split(etag, ",") {
+				tag = strings.TrimSpace(tag)
+				if strings.HasPrefix(tag, "W/") {
+					continue
+				}
+				if _, want := parseChecksum(strings.Trim(tag, `"`)); strings.EqualFold(want, got) {
+					match = true
+					break
+				}
+			}
+		}
+	}
+	if !match {
+		return storage.ErrConflict
+	}
+	return lfs.delete(charmID, path)
+}
+
+// etagMatch reports whether the ETag current is in the If-None-Match list
+// header, using weak comparison.
+func etagMatch(header, current string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	current = strings.TrimPrefix(current, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == current {
+			return true
+		}
+	}
+	return false
+}
+
+// etagStrongMatch reports whether the ETag current is in the If-Match list
+// header, using strong comparison, where weak ETags never match.
+func etagStrongMatch(header, current string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	if strings.HasPrefix(current, "W/") {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == current {
+			return true
+		}
+	}
+	return false
+}
+
+// etag returns the ETag for the file at path. Weak ETags are derived from the
+// size and modification time, strong ones from the recorded content checksum.
+// Directories and files without a checksum always get a weak ETag.
+func (lfs *LocalFileStore) etag(charmID, path string, fi charm.FileInfo) string {
+	if lfs.StrongETags && !fi.IsDir {
+		if sum, err := lfs.Checksum(charmID, path); err == nil {
+			return fmt.Sprintf("%q", sum)
+		}
+	}
+	return weakETag(fi)
+}
+
+// dirETag returns the weak ETag of a directory listing, derived from the name,
+// size and modification time of each entry.
+func dirETag(fis []charm.FileInfo) string {
+	sorted := make([]charm.FileInfo, len(fis))
+	copy(sorted, fis)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	h := fnv.New64a()
+	for _, fi := range sorted {
+		fmt.Fprintf(h, "%s\x00%d-%d\x00", fi.Name, fi.Size, fi.ModTime.UnixNano())
+	}
+	return fmt.Sprintf("W/\"%x\"", h.Sum64())
+}
+
+func weakETag(fi charm.FileInfo) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d-%d", fi.Size, fi.ModTime.UnixNano())
+	return fmt.Sprintf("W/\"%x\"", h.Sum64())
+}
@@ -0,0 +1,286 @@
+package localstorage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	charmfs "github.com/charmbracelet/charm/fs"
+	charm "github.com/charmbracelet/charm/proto"
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+func TestWeakETag(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp := filepath.Join(lfs.Path, charmID, "hello.txt")
+	mtime := time.Date(2022, 9, 1, 0, 0, 0, 0, time.UTC)
+	put := func(content string) {
+		t.Helper()
+		if err := lfs.Put(charmID, "/hello.txt", bytes.NewBufferString(content), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(fp, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	etag := func() string {
+		t.Helper()
+		info, err := lfs.Stat(charmID, "/hello.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.(*charmfs.FileInfo).FileInfo.ETag
+	}
+
+	put("hello")
+	first := etag()
+	if first == "" || first[:2] != "W/" {
+		t.Fatalf("expected a weak etag, got %q", first)
+	}
+	if etag() != first {
+		t.Fatalf("expected etag to be stable")
+	}
+
+	put("hello world")
+	sized := etag()
+	if sized == first {
+		t.Fatalf("expected etag to change when the size changes")
+	}
+
+	mtime = mtime.Add(time.Second)
+	if err := os.Chtimes(fp, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if etag() == sized {
+		t.Fatalf("expected etag to change when the modification time changes")
+	}
+}
+
+func TestStrongETag(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.StrongETags = true
+	if err := lfs.Put(charmID, "/foo/hello.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := lfs.Checksum(charmID, "/foo/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("%q", sum)
+
+	info, err := lfs.Stat(charmID, "/foo/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.(*charmfs.FileInfo).FileInfo.ETag; got != want {
+		t.Fatalf("expected stat etag %s, got %s", want, got)
+	}
+
+	f, err := lfs.Get(charmID, "/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close() // nolint:errcheck
+	var dir charm.FileInfo
+	if err := json.NewDecoder(f).Decode(&dir); err != nil {
+		t.Fatal(err)
+	}
+	if len(dir.Files) != 1 || dir.Files[0].ETag != want {
+		t.Fatalf("expected listing etag %s, got %+v", want, dir.Files)
+	}
+}
+
+func TestGetIfNoneMatch(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.StrongETags = true
+	if err := lfs.Put(charmID, "/hello.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	info, err := lfs.Stat(charmID, "/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := info.(*charmfs.FileInfo).FileInfo.ETag
+
+	for _, header := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		f, modified, err := lfs.GetIfNoneMatch(charmID, "/hello.txt", header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if modified || f != nil {
+			t.Fatalf("expected %s to match as not modified", header)
+		}
+	}
+
+	if err := lfs.Put(charmID, "/hello.txt", bytes.NewBufferString("goodbye"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	f, modified, err := lfs.GetIfNoneMatch(charmID, "/hello.txt", etag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !modified || f == nil {
+		t.Fatal("expected a stale ETag to return the file")
+	}
+	defer f.Close() // nolint:errcheck
+	buf := bytes.NewBuffer(nil)
+	if _, err := buf.ReadFrom(f); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "goodbye" {
+		t.Fatalf("expected current content, got %q", buf.String())
+	}
+}
+
+func TestDirETag(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/docs/a.txt", bytes.NewBufferString("a"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	etag := func() string {
+		t.Helper()
+		f, err := lfs.Get(charmID, "/docs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close() // nolint:errcheck
+		return f.(*charmfs.DirFile).ETag
+	}
+
+	first := etag()
+	if first == "" || first[:2] != "W/" {
+		t.Fatalf("expected a weak etag, got %q", first)
+	}
+	if etag() != first {
+		t.Fatal("expected the etag to be stable")
+	}
+	f, ok, err := lfs.GetDirIfNoneMatch(charmID, "/docs", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok || f != nil {
+		t.Fatal("expected no listing for a matching etag")
+	}
+
+	if err := lfs.Put(charmID, "/docs/b.txt", bytes.NewBufferString("b"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	added := etag()
+	if added == first {
+		t.Fatal("expected the etag to change when an entry is added")
+	}
+	f, ok, err = lfs.GetDirIfNoneMatch(charmID, "/docs", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected the listing for a stale etag")
+	}
+	var dir charm.FileInfo
+	if err := json.NewDecoder(f).Decode(&dir); err != nil {
+		t.Fatal(err)
+	}
+	f.Close() // nolint:errcheck
+	if len(dir.Files) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(dir.Files))
+	}
+
+	mtime := time.Date(2022, 9, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(lfs.Path, charmID, "docs", "a.txt"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if etag() == added {
+		t.Fatal("expected the etag to change when an entry is modified")
+	}
+	if _, _, err := lfs.GetDirIfNoneMatch(charmID, "/docs/a.txt", ""); err == nil {
+		t.Fatal("expected an error for a file")
+	}
+}
+
+func TestDeleteIfMatch(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(content string) {
+		t.Helper()
+		if err := lfs.Put(charmID, "/hello.txt", bytes.NewBufferString(content), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	etag := func() string {
+		t.Helper()
+		info, err := lfs.Stat(charmID, "/hello.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.(*charmfs.FileInfo).FileInfo.ETag
+	}
+
+	// Weak ETags don't say the content is the same, so they never match.
+	put("hello")
+	weak := etag()
+	if err := lfs.DeleteIfMatch(charmID, "/hello.txt", weak); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("expected storage.ErrConflict for a weak etag, got %v", err)
+	}
+	sum, err := lfs.Checksum(charmID, "/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.DeleteIfMatch(charmID, "/hello.txt", fmt.Sprintf("W/%q", sum)); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("expected storage.ErrConflict for a weak checksum etag, got %v", err)
+	}
+
+	lfs.StrongETags = true
+	put("hello")
+	stale := etag()
+	put("hello world")
+	if err := lfs.DeleteIfMatch(charmID, "/hello.txt", stale); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("expected storage.ErrConflict for a stale etag, got %v", err)
+	}
+	if _, err := lfs.Stat(charmID, "/hello.txt"); err != nil {
+		t.Fatalf("expected the file to be kept, got %v", err)
+	}
+	if err := lfs.DeleteIfMatch(charmID, "/hello.txt", etag()); err != nil {
+		t.Fatalf("expected the file to be deleted, got %v", err)
+	}
+	if _, err := lfs.Stat(charmID, "/hello.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected the file to be gone, got %v", err)
+	}
+
+	lfs.StrongETags = false
+	put("hello")
+	if sum, err = lfs.Checksum(charmID, "/hello.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.DeleteIfMatch(charmID, "/hello.txt", fmt.Sprintf(`W/"x", %q`, sum)); err != nil {
+		t.Fatalf("expected a matching checksum to delete the file, got %v", err)
+	}
+	if err := lfs.DeleteIfMatch(charmID, "/hello.txt", sum); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist for a missing file, got %v", err)
+	}
+}
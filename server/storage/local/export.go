@@ -0,0 +1,139 @@
+package localstorage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	charm "github.com/charmbracelet/charm/proto"
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// bundleVersion is the version of the bundle format written by Export.
+const bundleVersion = 1
+
+// bundleHeader is the first line of a bundle. It's followed by the contents of
+// each file in Files, in order, with nothing in between.
+type bundleHeader struct {
+	Version int               `json:"version"`
+	Files   []*charm.FileInfo `json:"files"`
+}
+
+// Export writes everything stored for the Charm ID to w as a bundle that can
+// be loaded into another store with Import. A bundle is a JSON line holding the
+// Manifest, followed by the contents of the files it lists.
+func (lfs *LocalFileStore) Export(charmID string, w io.Writer) error {
+	defer lfs.op()()
+	fis, err := lfs.Manifest(charmID)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(w).Encode(bundleHeader{Version: bundleVersion, Files: fis}); err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if fi.IsDir {
+			continue
+		}
+		if err := lfs.exportFile(w, filepath.Join(lfs.root(), charmID, fi.Name), fi.Size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (lfs *LocalFileStore) exportFile(w io.Writer, fp string, size int64) error {
+	f, err := lfs.openStored(fp)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint:errcheck
+	if _, err := io.CopyN(w, f, size); err != nil {
+		return fmt.Errorf("export %s: %w", fp, err)
+	}
+	return nil
+}
+
+// Import stores the files in a bundle written by Export for the Charm ID,
+// keeping their modes, modification times and extended attributes. Files are
+// checked against the checksums in the bundle and storage.ErrCorrupt is
+// returned on a mismatch. Existing files at the same paths are replaced.
+func (lfs *LocalFileStore) Import(charmID string, r io.Reader) error {
+	defer lfs.op()()
+	br := bufio.NewReader(r)
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("read bundle header: %w", err)
+	}
+	var hdr bundleHeader
+	if err := json.Unmarshal(line, &hdr); err != nil {
+		return fmt.Errorf("read bundle header: %w", err)
+	}
+	if hdr.Version != bundleVersion {
+		return fmt.Errorf("unsupported bundle version: %d", hdr.Version)
+	}
+	for _, fi := range hdr.Files {
+		if fi.IsDir {
+			if err := lfs.importDir(charmID, fi); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := lfs.importFile(charmID, fi, br); err != nil {
+			return err
+		}
+	}
+	// Directories may not be writable once their mode is applied, and writing
+	// files changes their modification time, so both are restored last,
+	// deepest first.
+	for i := len(hdr.Files) - 1; i >= 0; i-- {
+		fi := hdr.Files[i]
+		if !fi.IsDir {
+			continue
+		}
+		fp := filepath.Join(lfs.root(), charmID, fi.Name)
+		if err := os.Chmod(fp, fi.Mode.Perm()); err != nil {
+			return err
+		}
+		if err := os.Chtimes(fp, fi.ModTime, fi.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (lfs *LocalFileStore) importDir(charmID string, fi *charm.FileInfo) error {
+	if inReserved(fi.Name) {
+		return fmt.Errorf("invalid path specified: %s", fi.Name)
+	}
+	return os.MkdirAll(filepath.Join(lfs.root(), charmID, fi.Name), 0o700)
+}
+
+func (lfs *LocalFileStore) importFile(charmID string, fi *charm.FileInfo, r io.Reader) error {
+	// The entry is checked as it's written, so a bad one never replaces a
+	// file already stored.
+	opts := storage.PutOptions{
+		Xattrs:           fi.Xattrs,
+		ExpectedChecksum: fi.Checksum,
+		ExpectedSize:     fi.Size,
+	}
+	err := lfs.PutWithOptions(charmID, fi.Name, io.LimitReader(r, fi.Size), fi.Mode, opts)
+	switch {
+	case errors.Is(err, storage.ErrSizeMismatch):
+		return fmt.Errorf("import %s: %w", fi.Name, io.ErrUnexpectedEOF)
+	case errors.Is(err, storage.ErrChecksumMismatch):
+		return fmt.Errorf("import %s: %w", fi.Name, storage.ErrCorrupt)
+	case err != nil:
+		return err
+	}
+	// A deduplicated file shares its modification time with the blob.
+	fp := filepath.Join(lfs.root(), charmID, fi.Name)
+	if err := unshare(fp); err != nil {
+		return err
+	}
+	return os.Chtimes(fp, fi.ModTime, fi.ModTime)
+}
@@ -0,0 +1,150 @@
+package localstorage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+func TestExportImport(t *testing.T) {
+	charmID := uuid.New().String()
+	src, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]fs.FileMode{
+		"/a.txt":         0o644,
+		"/foo/b.txt":     0o600,
+		"/foo/bar/c.bin": 0o755,
+		"/empty.txt":     0o640,
+	}
+	for path, mode := range files {
+		content := bytes.Repeat([]byte(path), len(path))
+		if path == "/empty.txt" {
+			content = nil
+		}
+		if err := src.Put(charmID, path, bytes.NewReader(content), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := src.Put(charmID, "/foo/baz", bytes.NewReader(nil), fs.ModeDir|0o700); err != nil {
+		t.Fatal(err)
+	}
+	// A directory that isn't writable is restored after the files in it.
+	if err := src.Put(charmID, "/ro/d.txt", bytes.NewBufferString("read only"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(src.Path, charmID, "ro"), 0o500); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, path := range []string{"a.txt", "foo/bar", "foo"} {
+		if err := os.Chtimes(filepath.Join(src.Path, charmID, path), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bundle := bytes.NewBuffer(nil)
+	if err := src.Export(charmID, bundle); err != nil {
+		t.Fatalf("expected no error exporting, %v", err)
+	}
+	dst, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lfs := range []*LocalFileStore{src, dst} {
+		ro := filepath.Join(lfs.Path, charmID, "ro")
+		t.Cleanup(func() { os.Chmod(ro, 0o700) }) // nolint:errcheck
+	}
+	if err := dst.Import(charmID, bytes.NewReader(bundle.Bytes())); err != nil {
+		t.Fatalf("expected no error importing, %v", err)
+	}
+
+	want, err := src.Manifest(charmID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := dst.Manifest(charmID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d entries, got %d", len(want), len(got))
+	}
+	for i := range want {
+		w, g := want[i], got[i]
+		if !w.ModTime.Equal(g.ModTime) {
+			t.Fatalf("expected %s modtime %v, got %v", w.Name, w.ModTime, g.ModTime)
+		}
+		g.ModTime = w.ModTime
+		if !reflect.DeepEqual(w, g) {
+			t.Fatalf("expected imported entry %+v, got %+v", w, g)
+		}
+	}
+}
+
+func TestImportCorrupt(t *testing.T) {
+	charmID := uuid.New().String()
+	src, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Put(charmID, "/a.txt", bytes.NewBufferString("hello world"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	bundle := bytes.NewBuffer(nil)
+	if err := src.Export(charmID, bundle); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := append([]byte(nil), bundle.Bytes()...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	truncated := bundle.Bytes()[:bundle.Len()-1]
+
+	tests := map[string]struct {
+		bundle []byte
+		err    error
+	}{
+		"corrupt":   {corrupt, storage.ErrCorrupt},
+		"truncated": {truncated, io.ErrUnexpectedEOF},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			dst, err := NewLocalFileStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := dst.Import(charmID, bytes.NewReader(tc.bundle)); !errors.Is(err, tc.err) {
+				t.Fatalf("expected %v, got %v", tc.err, err)
+			}
+			if _, err := dst.Stat(charmID, "/a.txt"); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("expected bad file not to be imported, got %v", err)
+			}
+
+			// A bad entry leaves the file it would replace as it was.
+			if err := dst.Put(charmID, "/a.txt", bytes.NewBufferString("kept"), fs.FileMode(0o644)); err != nil {
+				t.Fatal(err)
+			}
+			if err := dst.Import(charmID, bytes.NewReader(tc.bundle)); !errors.Is(err, tc.err) {
+				t.Fatalf("expected %v, got %v", tc.err, err)
+			}
+			f, err := dst.Get(charmID, "/a.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close() // nolint:errcheck
+			data, err := io.ReadAll(f)
+			if err != nil || string(data) != "kept" {
+				t.Fatalf("expected the stored file to be kept, got %q, %v", data, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package localstorage
+
+// diskFree isn't supported on this platform.
+func diskFree(path string) (int64, error) {
+	return 0, errDiskSpaceUnsupported
+}
+
+// diskSpace isn't supported on this platform.
+func diskSpace(path string) (int64, int64, error) {
+	return 0, 0, errDiskSpaceUnsupported
+}
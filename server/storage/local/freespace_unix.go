@@ -0,0 +1,23 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package localstorage
+
+import "golang.org/x/sys/unix"
+
+// diskFree returns the number of bytes available to unprivileged users on the
+// volume holding path.
+func diskFree(path string) (int64, error) {
+	free, _, err := diskSpace(path)
+	return free, err
+}
+
+// diskSpace returns the number of bytes available to unprivileged users on the
+// volume holding path and the volume's size.
+func diskSpace(path string) (free int64, total int64, err error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), int64(st.Blocks) * int64(st.Bsize), nil
+}
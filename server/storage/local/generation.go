@@ -0,0 +1,102 @@
+package localstorage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// generationsDir is the top-level directory directory generation counters are
+// kept in, one file per directory named by the hash of its path.
+const generationsDir = ".generations"
+
+// DirGeneration returns a counter for the directory at path for the Charm ID
+// that increases whenever something in it is written, deleted or moved,
+// including in its subdirectories. Clients can compare it between polls to tell
+// whether a listing changed. It's zero for directories that haven't changed
+// since generations started being tracked.
+func (lfs *LocalFileStore) DirGeneration(charmID, path string) (uint64, error) {
+	defer lfs.op()()
+	info, err := os.Stat(filepath.Join(lfs.root(), charmID, path))
+	if os.IsNotExist(err) {
+		return 0, fs.ErrNotExist
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !info.IsDir() {
+		return 0, storage.ErrNotDirectory
+	}
+	lfs.genMu.Lock()
+	defer lfs.genMu.Unlock()
+	return lfs.generation(charmID, path), nil
+}
+
+// bumpGenerations increments the generation of every directory containing
+// path, and of path itself if it's a directory. Writes call it once they're
+// committed, so they don't fail if it does: a counter not bumped only delays
+// a client noticing the change.
+func (lfs *LocalFileStore) bumpGenerations(charmID, path string) error {
+	lfs.genMu.Lock()
+	defer lfs.genMu.Unlock()
+	p := filepath.ToSlash(filepath.Clean("/" + path))
+	if info, err := os.Stat(filepath.Join(lfs.root(), charmID, p)); err != nil || !info.IsDir() {
+		p = filepath.ToSlash(filepath.Dir(p))
+	}
+	for {
+		gp := lfs.generationPath(charmID, p)
+		if err := os.MkdirAll(filepath.Dir(gp), 0o700); err != nil {
+			return err
+		}
+		n := lfs.generation(charmID, p)
+		if err := writeSidecar(gp, []byte(strconv.FormatUint(n+1, 10))); err != nil {
+			return err
+		}
+		if p == "/" {
+			return nil
+		}
+		p = filepath.ToSlash(filepath.Dir(p))
+	}
+}
+
+// dropGenerations removes the generations of the directory at path for the
+// Charm ID and those below it, before it's deleted.
+func (lfs *LocalFileStore) dropGenerations(charmID, path string) {
+	lfs.genMu.Lock()
+	defer lfs.genMu.Unlock()
+	root := filepath.Join(lfs.root(), charmID)
+	filepath.WalkDir(filepath.Join(root, path), func(fp string, d fs.DirEntry, err error) error { // nolint:errcheck
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if rel, err := filepath.Rel(root, fp); err == nil {
+			os.Remove(lfs.generationPath(charmID, rel)) // nolint:errcheck
+		}
+		return nil
+	})
+}
+
+// generation returns the generation of the directory at path. A counter that
+// can't be read, such as one a crash left empty, counts from zero.
+func (lfs *LocalFileStore) generation(charmID, path string) uint64 {
+	b, err := os.ReadFile(lfs.generationPath(charmID, path))
+	if err != nil {
+		return 0
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+func (lfs *LocalFileStore) generationPath(charmID, path string) string {
+	sum := sha256.Sum256([]byte(filepath.ToSlash(filepath.Clean("/" + path))))
+	return filepath.Join(lfs.root(), generationsDir, charmID, hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,128 @@
+package localstorage
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestDirGeneration(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/foo/bar/a.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	gen := func(path string) uint64 {
+		t.Helper()
+		n, err := lfs.DirGeneration(charmID, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	expectBumped := func(paths ...string) func(string) {
+		before := make(map[string]uint64)
+		for _, p := range []string{"/", "/foo", "/foo/bar", "/other"} {
+			before[p] = gen(p)
+		}
+		return func(op string) {
+			t.Helper()
+			bumped := make(map[string]bool)
+			for _, p := range paths {
+				bumped[p] = true
+			}
+			for p, n := range before {
+				after := gen(p)
+				if bumped[p] && after <= n {
+					t.Fatalf("expected %s to bump the generation of %s", op, p)
+				}
+				if !bumped[p] && after != n {
+					t.Fatalf("expected %s not to change the generation of %s", op, p)
+				}
+			}
+		}
+	}
+	if err := lfs.Put(charmID, "/other", nil, fs.ModeDir|0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	check := expectBumped("/", "/foo", "/foo/bar")
+	if err := lfs.Put(charmID, "/foo/bar/b.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	check("Put")
+
+	check = expectBumped("/", "/foo", "/foo/bar")
+	if err := lfs.Delete(charmID, "/foo/bar/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	check("Delete")
+
+	check = expectBumped("/", "/foo", "/foo/bar", "/other")
+	tx, err := lfs.Transaction(charmID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Move("/foo/bar/b.txt", "/other/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	check("Move")
+
+	check = expectBumped()
+	for _, p := range []string{"/", "/foo", "/other/b.txt"} {
+		f, err := lfs.Get(charmID, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close() // nolint:errcheck
+		if _, err := lfs.Stat(charmID, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check("reading")
+}
+
+func TestDirGenerationBookkeeping(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/foo/bar/a.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A counter a crash left empty starts over rather than failing writes.
+	if err := os.WriteFile(lfs.generationPath(charmID, "/foo"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/foo/b.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+		t.Fatalf("expected a torn counter not to fail the write, got %v", err)
+	}
+	if n, err := lfs.DirGeneration(charmID, "/foo"); err != nil || n != 1 {
+		t.Fatalf("expected the torn counter to start over at 1, got %d, %v", n, err)
+	}
+
+	// Deleting a directory deletes the counters below it, and files have
+	// none.
+	if err := lfs.Delete(charmID, "/foo"); err != nil {
+		t.Fatal(err)
+	}
+	des, err := os.ReadDir(filepath.Join(lfs.Path, generationsDir, charmID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(des) != 1 || des[0].Name() != filepath.Base(lfs.generationPath(charmID, "/")) {
+		t.Fatalf("expected only the counter of the root to be left, got %v", des)
+	}
+}
@@ -0,0 +1,92 @@
+package localstorage
+
+import (
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// defaultIdempotencyTTL is how long completed idempotent writes are
+// remembered when IdempotencyTTL isn't set.
+const defaultIdempotencyTTL = 10 * time.Minute
+
+// idempotentPut is a write made with a storage.PutOptions IdempotencyKey.
+type idempotentPut struct {
+	path    string
+	done    chan struct{}
+	res     storage.PutResult
+	err     error
+	expires time.Time
+}
+
+// idempotent runs put unless a write with the same IdempotencyKey in opts was
+// already made for the Charm ID, in which case that write's result is used.
+// A write that's still in progress is waited on. Failed writes aren't
+// remembered, so they can be retried.
+func (lfs *LocalFileStore) idempotent(charmID, path string, opts storage.PutOptions, put func(storage.PutOptions) error) error {
+	if opts.IdempotencyKey == "" {
+		return put(opts)
+	}
+	key := charmID + "\x00" + opts.IdempotencyKey
+	now := time.Now()
+	lfs.idemMu.Lock()
+	if lfs.idem == nil {
+		lfs.idem = make(map[string]*idempotentPut)
+	}
+	for k, ip := range lfs.idem {
+		if !ip.expires.IsZero() && now.After(ip.expires) {
+			delete(lfs.idem, k)
+		}
+	}
+	ip, ok := lfs.idem[key]
+	if !ok {
+		ip = &idempotentPut{path: path, done: make(chan struct{})}
+		lfs.idem[key] = ip
+	}
+	lfs.idemMu.Unlock()
+
+	if ok {
+		<-ip.done
+		if ip.path != path {
+			return storage.ErrIdempotencyKeyReused
+		}
+		if ip.err == nil && opts.Result != nil {
+			*opts.Result = ip.res
+		}
+		return ip.err
+	}
+
+	caller := opts.Result
+	opts.Result = &ip.res
+	ip.err = put(opts)
+	if caller != nil && ip.err == nil {
+		*caller = ip.res
+	}
+	ttl := lfs.IdempotencyTTL
+	if ttl <= 0 {
+		ttl = defaultIdempotencyTTL
+	}
+	lfs.idemMu.Lock()
+	if ip.err != nil {
+		delete(lfs.idem, key)
+	} else {
+		ip.expires = time.Now().Add(ttl)
+	}
+	lfs.idemMu.Unlock()
+	close(ip.done)
+	return ip.err
+}
+
+// renameIdempotent moves the writes remembered for oldID to newID.
+func (lfs *LocalFileStore) renameIdempotent(oldID, newID string) {
+	lfs.idemMu.Lock()
+	defer lfs.idemMu.Unlock()
+	prefix := oldID + "\x00"
+	for k, ip := range lfs.idem {
+		if strings.HasPrefix(k, prefix) {
+			delete(lfs.idem, k)
+			lfs.idem[newID+"\x00"+strings.TrimPrefix(k, prefix)] = ip
+		}
+	}
+}
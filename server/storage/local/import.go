@@ -0,0 +1,140 @@
+package localstorage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// defaultImportTimeout is how long PutFromURL waits for a download when
+// ImportTimeout isn't set.
+const defaultImportTimeout = time.Minute
+
+// importRedirects is how many redirects PutFromURL follows.
+const importRedirects = 5
+
+// PutFromURL downloads the file at rawURL and stores it at path for the Charm
+// ID through Put, so the download is subject to MaxFileBytes and the quota
+// like any upload. Only http and https URLs on the hosts listed in ImportHosts
+// may be fetched, including through redirects, and other hosts fail with
+// storage.ErrHostNotAllowed. Downloads larger than ImportMaxBytes fail with
+// storage.ErrFileTooLarge, and ones whose Content-Type isn't one of
+// ImportContentTypes fail with storage.ErrContentTypeNotAllowed.
+func (lfs *LocalFileStore) PutFromURL(ctx context.Context, charmID, path, rawURL string, mode fs.FileMode) error {
+	defer lfs.op()()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if err := lfs.allowImport(u); err != nil {
+		return err
+	}
+	timeout := lfs.ImportTimeout
+	if timeout <= 0 {
+		timeout = defaultImportTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= importRedirects {
+				return fmt.Errorf("stopped after %d redirects", importRedirects)
+			}
+			return lfs.allowImport(req.URL)
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("import %s: %s", u.Redacted(), resp.Status)
+	}
+	if err := lfs.allowContentType(resp.Header.Get("Content-Type")); err != nil {
+		return err
+	}
+	var r io.Reader = resp.Body
+	if lfs.ImportMaxBytes > 0 {
+		if resp.ContentLength > lfs.ImportMaxBytes {
+			return storage.ErrFileTooLarge
+		}
+		r = &maxBytesReader{r: r, n: lfs.ImportMaxBytes}
+	}
+	return lfs.Put(charmID, path, r, mode)
+}
+
+// allowImport returns an error unless u may be fetched by PutFromURL.
+func (lfs *LocalFileStore) allowImport(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported url scheme: %q", u.Scheme)
+	}
+	host := strings.TrimSuffix(u.Hostname(), ".")
+	for _, h := range lfs.ImportHosts {
+		if strings.EqualFold(h, host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: %w", host, storage.ErrHostNotAllowed)
+}
+
+// allowContentType returns an error unless a download with the Content-Type
+// header ct may be stored.
+func (lfs *LocalFileStore) allowContentType(ct string) error {
+	if len(lfs.ImportContentTypes) == 0 {
+		return nil
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return fmt.Errorf("%q: %w", ct, storage.ErrContentTypeNotAllowed)
+	}
+	if matchMediaType(lfs.ImportContentTypes, mt) {
+		return nil
+	}
+	return fmt.Errorf("%s: %w", mt, storage.ErrContentTypeNotAllowed)
+}
+
+// matchMediaType reports whether the media type mt is one of types, which may
+// include wildcards such as "image/*".
+func matchMediaType(types []string, mt string) bool {
+	for _, t := range types {
+		if strings.EqualFold(t, mt) {
+			return true
+		}
+		if strings.HasSuffix(t, "/*") && strings.HasPrefix(strings.ToLower(mt), strings.ToLower(strings.TrimSuffix(t, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxBytesReader fails with storage.ErrFileTooLarge once more than n bytes are
+// read from r.
+type maxBytesReader struct {
+	r io.Reader
+	n int64
+}
+
+func (mr *maxBytesReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > mr.n+1 {
+		p = p[:mr.n+1]
+	}
+	n, err := mr.r.Read(p)
+	if int64(n) > mr.n {
+		return 0, storage.ErrFileTooLarge
+	}
+	mr.n -= int64(n)
+	return n, err
+}
@@ -0,0 +1,78 @@
+package localstorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+func TestPutFromURL(t *testing.T) {
+	charmID := uuid.New().String()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small.txt":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte("hello")) // nolint:errcheck
+		case "/large.bin":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(bytes.Repeat([]byte("x"), 1000)) // nolint:errcheck
+		case "/elsewhere":
+			http.Redirect(w, r, "http://example.com/small.txt", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.ImportHosts = []string{u.Hostname()}
+	lfs.ImportMaxBytes = 100
+	ctx := context.Background()
+
+	if err := lfs.PutFromURL(ctx, charmID, "/small.txt", ts.URL+"/small.txt", fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(lfs.Path, charmID, "small.txt"))
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("expected the download to be stored, got %q, %v", data, err)
+	}
+
+	if err := lfs.PutFromURL(ctx, charmID, "/large.bin", ts.URL+"/large.bin", fs.FileMode(0o644)); !errors.Is(err, storage.ErrFileTooLarge) {
+		t.Fatalf("expected storage.ErrFileTooLarge, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(lfs.Path, charmID, "large.bin")); !os.IsNotExist(err) {
+		t.Fatalf("expected the oversize download not to be stored, got %v", err)
+	}
+
+	if err := lfs.PutFromURL(ctx, charmID, "/other.txt", "http://example.com/small.txt", fs.FileMode(0o644)); !errors.Is(err, storage.ErrHostNotAllowed) {
+		t.Fatalf("expected storage.ErrHostNotAllowed, got %v", err)
+	}
+	if err := lfs.PutFromURL(ctx, charmID, "/other.txt", ts.URL+"/elsewhere", fs.FileMode(0o644)); !errors.Is(err, storage.ErrHostNotAllowed) {
+		t.Fatalf("expected storage.ErrHostNotAllowed for a redirect, got %v", err)
+	}
+
+	lfs.ImportContentTypes = []string{"image/*"}
+	if err := lfs.PutFromURL(ctx, charmID, "/small.txt", ts.URL+"/small.txt", fs.FileMode(0o644)); !errors.Is(err, storage.ErrContentTypeNotAllowed) {
+		t.Fatalf("expected storage.ErrContentTypeNotAllowed, got %v", err)
+	}
+	lfs.ImportContentTypes = []string{"text/*"}
+	if err := lfs.PutFromURL(ctx, charmID, "/small.txt", ts.URL+"/small.txt", fs.FileMode(0o644)); err != nil {
+		t.Fatalf("expected the content type to be allowed, got %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+package localstorage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	charmfs "github.com/charmbracelet/charm/fs"
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// FSFor returns an fs.FS of the files stored for the Charm ID. The returned
+// fs.FS also implements fs.StatFS, fs.ReadFileFS and fs.ReadDirFS. Directory
+// entries and file info come from the same listing used by Get.
+func (lfs *LocalFileStore) FSFor(charmID string) fs.FS {
+	return lfs.FSForAs(charmID, charmID)
+}
+
+// FSForAs is like FSFor on behalf of requesterID. Opening, reading or listing
+// anything requesterID may not read fails with storage.ErrAccessDenied.
+func (lfs *LocalFileStore) FSForAs(requesterID, charmID string) fs.FS {
+	return &charmIDFS{lfs: lfs, charmID: charmID, requesterID: requesterID}
+}
+
+type charmIDFS struct {
+	lfs         *LocalFileStore
+	charmID     string
+	requesterID string
+}
+
+// Open implements fs.FS.
+func (cfs *charmIDFS) Open(name string) (fs.File, error) {
+	defer cfs.lfs.op()()
+	info, err := cfs.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		des, err := cfs.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &dirHandle{info: info, entries: des}, nil
+	}
+	f, err := cfs.lfs.Get(cfs.charmID, name)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	return f, nil
+}
+
+// Stat implements fs.StatFS.
+func (cfs *charmIDFS) Stat(name string) (fs.FileInfo, error) {
+	defer cfs.lfs.op()()
+	if !fs.ValidPath(name) {
+		return nil, pathError("stat", name, fs.ErrInvalid)
+	}
+	// Reserved entries aren't listed, so they don't exist as far as the
+	// fs.FS is concerned.
+	if inReserved(name) {
+		return nil, pathError("stat", name, fs.ErrNotExist)
+	}
+	if err := cfs.checkRead("stat", name); err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(filepath.Join(cfs.lfs.root(), cfs.charmID, name))
+	if err != nil {
+		return nil, pathError("stat", name, unwrapPathError(err))
+	}
+	fin, err := cfs.lfs.fileInfo(cfs.charmID, name, fi)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return &charmfs.FileInfo{FileInfo: fin}, nil
+}
+
+// ReadFile implements fs.ReadFileFS.
+func (cfs *charmIDFS) ReadFile(name string) ([]byte, error) {
+	info, err := cfs.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, pathError("read", name, fs.ErrInvalid)
+	}
+	f, err := cfs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // nolint:errcheck
+	return io.ReadAll(f)
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (cfs *charmIDFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	defer cfs.lfs.op()()
+	if !fs.ValidPath(name) {
+		return nil, pathError("readdir", name, fs.ErrInvalid)
+	}
+	if inReserved(name) {
+		return nil, pathError("readdir", name, fs.ErrNotExist)
+	}
+	if err := cfs.checkRead("readdir", name); err != nil {
+		return nil, err
+	}
+	fis, err := cfs.lfs.list(cfs.charmID, name)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	des := make([]fs.DirEntry, 0, len(fis))
+	for _, fi := range fis {
+		des = append(des, &charmfs.FileInfo{FileInfo: fi})
+	}
+	return des, nil
+}
+
+// checkRead fails with storage.ErrAccessDenied unless the requester may read
+// name.
+func (cfs *charmIDFS) checkRead(op, name string) error {
+	ok, err := cfs.lfs.CheckAccess(cfs.requesterID, cfs.charmID, name, storage.OpRead)
+	if err != nil {
+		return pathError(op, name, err)
+	}
+	if !ok {
+		return pathError(op, name, storage.ErrAccessDenied)
+	}
+	return nil
+}
+
+// dirHandle is the fs.ReadDirFile returned when opening a directory.
+type dirHandle struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+}
+
+func (dh *dirHandle) Stat() (fs.FileInfo, error) {
+	return dh.info, nil
+}
+
+func (dh *dirHandle) Read([]byte) (int, error) {
+	return 0, pathError("read", dh.info.Name(), fs.ErrInvalid)
+}
+
+func (dh *dirHandle) Close() error {
+	return nil
+}
+
+func (dh *dirHandle) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		des := dh.entries
+		dh.entries = nil
+		return des, nil
+	}
+	if len(dh.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(dh.entries) {
+		n = len(dh.entries)
+	}
+	des := dh.entries[:n]
+	dh.entries = dh.entries[n:]
+	return des, nil
+}
+
+func unwrapPathError(err error) error {
+	var perr *fs.PathError
+	if errors.As(err, &perr) {
+		return perr.Err
+	}
+	return err
+}
+
+func pathError(op, path string, err error) *fs.PathError {
+	return &fs.PathError{Op: op, Path: path, Err: err}
+}
+
+var _ fs.ReadDirFile = &dirHandle{}
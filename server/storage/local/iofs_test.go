@@ -0,0 +1,89 @@
+package localstorage
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/uuid"
+)
+
+func TestFSFor(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"hello.txt":     "hello",
+		"foo/bar.txt":   "bar",
+		"foo/baz/a.txt": "a",
+	}
+	for path, content := range files {
+		if err := lfs.Put(charmID, path, bytes.NewBufferString(content), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := lfs.PutScratch(charmID, "/tmp.txt", bytes.NewBufferString("scratch"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.PutDraft(charmID, "/hello.txt", bytes.NewBufferString("draft"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	staging := filepath.Join(lfs.Path, charmID, "foo", ".bar.txt.123.tmp")
+	if err := os.WriteFile(staging, []byte("staged"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fsys := lfs.FSFor(charmID)
+
+	info, err := fs.Stat(fsys, "foo/bar.txt")
+	if err != nil {
+		t.Fatalf("expected no error from fs.Stat, %v", err)
+	}
+	if info.IsDir() || info.Size() != 3 {
+		t.Fatalf("expected foo/bar.txt to be a 3 byte file, got %+v", info)
+	}
+	if _, err := fs.Stat(fsys, "missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist from fs.Stat, got %v", err)
+	}
+
+	for path, content := range files {
+		b, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			t.Fatalf("expected no error from fs.ReadFile(%s), %v", path, err)
+		}
+		if string(b) != content {
+			t.Fatalf("expected content of %s to be %s, got %s", path, content, string(b))
+		}
+	}
+
+	des, err := fs.ReadDir(fsys, "foo")
+	if err != nil {
+		t.Fatalf("expected no error from fs.ReadDir, %v", err)
+	}
+	if len(des) != 2 || des[0].Name() != "bar.txt" || des[1].Name() != "baz" || !des[1].IsDir() {
+		t.Fatalf("expected foo to contain bar.txt and baz/, got %v", des)
+	}
+
+	// Reserved entries are hidden from listings, so they can't be opened by
+	// name either.
+	for _, name := range []string{scratchDir, scratchDir + "/tmp.txt", draftsDir, draftsDir + "/hello.txt", "foo/.bar.txt.123.tmp"} {
+		if _, err := fs.Stat(fsys, name); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected fs.ErrNotExist from fs.Stat(%s), got %v", name, err)
+		}
+		if _, err := fsys.Open(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected fs.ErrNotExist opening %s, got %v", name, err)
+		}
+		if _, err := fs.ReadDir(fsys, name); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected fs.ErrNotExist from fs.ReadDir(%s), got %v", name, err)
+		}
+	}
+
+	if err := fstest.TestFS(fsys, "hello.txt", "foo/bar.txt", "foo/baz/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,110 @@
+package localstorage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	charmfs "github.com/charmbracelet/charm/fs"
+	charm "github.com/charmbracelet/charm/proto"
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// ListingFormat is an encoding for directory listings.
+type ListingFormat string
+
+// Supported listing formats. MessagePack listings use the same field names as
+// JSON ones.
+const (
+	ListingJSON    ListingFormat = "json"
+	ListingMsgpack ListingFormat = "msgpack"
+	// ListingNDJSON lists one JSON charm.FileInfo per line for each entry,
+	// without the directory itself. Get streams these listings as the
+	// directory is read, in directory order rather than sorted by name.
+	ListingNDJSON ListingFormat = "ndjson"
+)
+
+// listBatch is how many entries a streamed listing reads from the directory
+// at a time.
+const listBatch = 256
+
+// encode encodes the directory listing dir and returns it with its media type.
+// ListingNDJSON listings are streamed by streamListing instead.
+func (lf ListingFormat) encode(dir charm.FileInfo) (*bytes.Buffer, string, error) {
+	buf := bytes.NewBuffer(nil)
+	switch lf {
+	case "", ListingJSON:
+		if err := json.NewEncoder(buf).Encode(dir); err != nil {
+			return nil, "", err
+		}
+		return buf, "application/json", nil
+	case ListingMsgpack:
+		enc := msgpack.NewEncoder(buf)
+		enc.SetCustomStructTag("json")
+		if err := enc.Encode(dir); err != nil {
+			return nil, "", err
+		}
+		return buf, "application/msgpack", nil
+	}
+	return nil, "", fmt.Errorf("unknown listing format: %q", string(lf))
+}
+
+// streamListing returns the ListingNDJSON listing of the directory at path
+// for the Charm ID, described by info, encoding entries as they're read.
+// Errors listing the directory, such as storage.ErrTooManyEntries, are
+// returned by Read once the entries before them are read.
+func (lfs *LocalFileStore) streamListing(charmID, path string, info fs.FileInfo) (*charmfs.DirFile, error) {
+	d, err := os.Open(filepath.Join(lfs.root(), charmID, path))
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	end := lfs.op()
+	go func() {
+		defer end()
+		defer d.Close()                                           // nolint:errcheck
+		pw.CloseWithError(lfs.writeEntries(pw, d, charmID, path)) // nolint:errcheck
+	}()
+	return &charmfs.DirFile{
+		Reader:      pr,
+		FileInfo:    info,
+		ContentType: "application/x-ndjson",
+	}, nil
+}
+
+// writeEntries writes a JSON line to w for each entry of the open directory d
+// at path for the Charm ID.
+func (lfs *LocalFileStore) writeEntries(w io.Writer, d *os.File, charmID, path string) error {
+	enc := json.NewEncoder(w)
+	n := 0
+	for {
+		des, err := d.ReadDir(listBatch)
+		for _, v := range des {
+			if !lfs.ShowReserved && isReserved(v.Name()) {
+				continue
+			}
+			if lfs.MaxListEntries > 0 && n == lfs.MaxListEntries {
+				return storage.ErrTooManyEntries
+			}
+			fin, err := lfs.entryInfo(charmID, path, v)
+			if err != nil {
+				return err
+			}
+			if err := enc.Encode(fin); err != nil {
+				return err
+			}
+			n++
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package localstorage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+
+	charmfs "github.com/charmbracelet/charm/fs"
+	charm "github.com/charmbracelet/charm/proto"
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestListingFormat(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/a.txt", "/b.txt", "/sub/c.txt"} {
+		if err := lfs.Put(charmID, path, bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(format ListingFormat) *charmfs.DirFile {
+		lfs.ListingFormat = format
+		f, err := lfs.Get(charmID, "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		df, ok := f.(*charmfs.DirFile)
+		if !ok {
+			t.Fatalf("expected a DirFile, got %T", f)
+		}
+		return df
+	}
+
+	var fromJSON charm.FileInfo
+	df := get("")
+	if df.ContentType != "application/json" {
+		t.Fatalf("expected JSON listing by default, got %s", df.ContentType)
+	}
+	if err := json.NewDecoder(df.Buffer).Decode(&fromJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	var fromMsgpack charm.FileInfo
+	df = get(ListingMsgpack)
+	if df.ContentType != "application/msgpack" {
+		t.Fatalf("expected MessagePack listing, got %s", df.ContentType)
+	}
+	dec := msgpack.NewDecoder(df.Buffer)
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(&fromMsgpack); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fromJSON.Files) != 3 || len(fromMsgpack.Files) != len(fromJSON.Files) {
+		t.Fatalf("expected 3 entries in both listings, got %d and %d", len(fromJSON.Files), len(fromMsgpack.Files))
+	}
+	for i, j := range fromJSON.Files {
+		m := fromMsgpack.Files[i]
+		if j.Name != m.Name || j.IsDir != m.IsDir || j.Size != m.Size || j.Mode != m.Mode || j.ETag != m.ETag || !j.ModTime.Equal(m.ModTime) {
+			t.Fatalf("expected listings to match, got %+v and %+v", j, m)
+		}
+	}
+
+	df = get(ListingNDJSON)
+	defer df.Close() // nolint:errcheck
+	if df.ContentType != "application/x-ndjson" {
+		t.Fatalf("expected ndjson listing, got %s", df.ContentType)
+	}
+	want := make(map[string]charm.FileInfo)
+	for _, fi := range fromJSON.Files {
+		want[fi.Name] = fi
+	}
+	sc := bufio.NewScanner(df)
+	lines := 0
+	for sc.Scan() {
+		var fi charm.FileInfo
+		if err := json.Unmarshal(sc.Bytes(), &fi); err != nil {
+			t.Fatalf("expected a JSON object per line, got %q: %v", sc.Text(), err)
+		}
+		j, ok := want[fi.Name]
+		if !ok || j.IsDir != fi.IsDir || j.Size != fi.Size || j.Mode != fi.Mode || !j.ModTime.Equal(fi.ModTime) {
+			t.Fatalf("unexpected entry %+v", fi)
+		}
+		lines++
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if lines != len(want) {
+		t.Fatalf("expected %d lines, got %d", len(want), lines)
+	}
+
+	lfs.MaxListEntries = 2
+	df = get(ListingNDJSON)
+	defer df.Close() // nolint:errcheck
+	if _, err := io.ReadAll(df); !errors.Is(err, storage.ErrTooManyEntries) {
+		t.Fatalf("expected storage.ErrTooManyEntries from the stream, got %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package localstorage
+
+import "sync"
+
+// pathLock serializes writes to a single path.
+type pathLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lockPath locks the file at fp against other writes through the store and
+// returns a function that unlocks it. Locks are dropped once nobody holds or
+// waits on them.
+func (lfs *LocalFileStore) lockPath(fp string) func() {
+	lfs.locksMu.Lock()
+	if lfs.locks == nil {
+		lfs.locks = make(map[string]*pathLock)
+	}
+	l, ok := lfs.locks[fp]
+	if !ok {
+		l = &pathLock{}
+		lfs.locks[fp] = l
+	}
+	l.refs++
+	lfs.locksMu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		lfs.locksMu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(lfs.locks, fp)
+		}
+		lfs.locksMu.Unlock()
+	}
+}
@@ -0,0 +1,82 @@
+package localstorage
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// CheckModes walks the files and directories stored for the Charm ID and
+// reports those whose permission bits differ from what want expects. want is
+// called with each path, relative to the Charm ID directory, and its current
+// mode, and returns the expected mode, or false if the path shouldn't be
+// checked.
+func (lfs *LocalFileStore) CheckModes(charmID string, want func(path string, mode fs.FileMode) (fs.FileMode, bool)) ([]storage.ModeDrift, error) {
+	defer lfs.op()()
+	return lfs.checkModes(charmID, want, false)
+}
+
+// FixModes is like CheckModes but also changes the mode of each path it
+// reports to the expected one.
+func (lfs *LocalFileStore) FixModes(charmID string, want func(path string, mode fs.FileMode) (fs.FileMode, bool)) ([]storage.ModeDrift, error) {
+	defer lfs.op()()
+	return lfs.checkModes(charmID, want, true)
+}
+
+func (lfs *LocalFileStore) checkModes(charmID string, want func(path string, mode fs.FileMode) (fs.FileMode, bool), fix bool) ([]storage.ModeDrift, error) {
+	drift := make([]storage.ModeDrift, 0)
+	err := lfs.walk(charmID, "", func(rel string, d fs.DirEntry) error {
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		mode, ok := want(rel, info.Mode())
+		if !ok || mode.Perm() == info.Mode().Perm() {
+			return nil
+		}
+		drift = append(drift, storage.ModeDrift{Path: rel, Mode: info.Mode().Perm(), Want: mode.Perm()})
+		if !fix {
+			return nil
+		}
+		fp := filepath.Join(lfs.root(), charmID, filepath.FromSlash(rel))
+		if info.IsDir() {
+			return os.Chmod(fp, mode.Perm())
+		}
+		return lfs.fixMode(charmID, rel, fp, mode.Perm())
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return drift, nil
+}
+
+// fixMode changes the mode of the file at fp, which is rel for the Charm ID.
+// A deduplicated file is given its own copy first, so the blob and the other
+// files linked to it keep their mode, and is then deduplicated again under its
+// new mode.
+func (lfs *LocalFileStore) fixMode(charmID, rel, fp string, mode fs.FileMode) error {
+	defer lfs.lockPath(fp)()
+	info, err := os.Stat(fp)
+	if err != nil {
+		return err
+	}
+	n, ok := linkCount(info)
+	shared := ok && n > 1
+	if err := unshare(fp); err != nil {
+		return err
+	}
+	if err := os.Chmod(fp, mode); err != nil {
+		return err
+	}
+	if shared && lfs.Dedup {
+		if sum, err := lfs.checksum(charmID, rel); err == nil {
+			lfs.dedup(fp, sum) // nolint:errcheck
+		}
+	}
+	return nil
+}
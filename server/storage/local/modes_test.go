@@ -0,0 +1,71 @@
+package localstorage
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCheckModes(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/keys/id_ed25519", bytes.NewBufferString("secret"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/keys/id_ed25519.pub", bytes.NewBufferString("public"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	// Private keys should only be readable by their owner.
+	want := func(path string, mode fs.FileMode) (fs.FileMode, bool) {
+		if mode.IsDir() || strings.HasSuffix(path, ".pub") {
+			return 0, false
+		}
+		return 0o600, true
+	}
+
+	drift, err := lfs.CheckModes(charmID, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drift) != 1 || drift[0].Path != "keys/id_ed25519" || drift[0].Mode != 0o644 || drift[0].Want != 0o600 {
+		t.Fatalf("expected drift of the private key to be reported, got %+v", drift)
+	}
+	fp := filepath.Join(lfs.Path, charmID, "keys", "id_ed25519")
+	info, err := os.Stat(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o644 {
+		t.Fatalf("expected CheckModes not to change the mode, got %v", info.Mode().Perm())
+	}
+
+	drift, err = lfs.FixModes(charmID, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drift) != 1 {
+		t.Fatalf("expected drift to be reported when fixing, got %+v", drift)
+	}
+	info, err = os.Stat(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected mode to be fixed, got %v", info.Mode().Perm())
+	}
+	drift, err = lfs.CheckModes(charmID, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drift) != 0 {
+		t.Fatalf("expected no drift after fixing, got %+v", drift)
+	}
+}
@@ -0,0 +1,83 @@
+package localstorage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/textproto"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// MultipartBody is the multipart/mixed stream returned by GetMultiple.
+type MultipartBody struct {
+	io.ReadCloser
+	// Boundary separates the parts of the stream.
+	Boundary string
+}
+
+// ContentType returns the media type of the stream, including its boundary.
+func (mb *MultipartBody) ContentType() string {
+	return "multipart/mixed; boundary=" + mb.Boundary
+}
+
+// GetMultiple streams the files at paths for the Charm ID as a *MultipartBody.
+// Each part has X-File-Path, X-File-Size and X-File-Mode headers describing
+// the file, and is read as by Get. Paths that don't exist or aren't files are
+// skipped, and paths to reserved entries fail with storage.ErrInvalidPath.
+func (lfs *LocalFileStore) GetMultiple(charmID string, paths []string) (io.ReadCloser, error) {
+	defer lfs.op()()
+	for _, path := range paths {
+		if inReserved(path) {
+			return nil, fmt.Errorf("%s: %w", path, storage.ErrInvalidPath)
+		}
+	}
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	end := lfs.op()
+	go func() {
+		defer end()
+		var err error
+		for _, path := range paths {
+			if err = lfs.addPart(mw, charmID, path); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err) // nolint:errcheck
+	}()
+	return &MultipartBody{ReadCloser: pr, Boundary: mw.Boundary()}, nil
+}
+
+func (lfs *LocalFileStore) addPart(mw *multipart.Writer, charmID, path string) error {
+	f, err := lfs.GetFile(charmID, path)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, storage.ErrIsDirectory) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint:errcheck
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", "application/octet-stream")
+	h.Set("X-File-Path", path)
+	h.Set("X-File-Size", fmt.Sprintf("%d", info.Size()))
+	h.Set("X-File-Mode", fmt.Sprintf("%d", info.Mode()))
+	w, err := mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	_, err = lfs.copy(w, f)
+	return err
+}
@@ -0,0 +1,115 @@
+package localstorage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+func TestGetMultiple(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"/a.txt":     "hello",
+		"/foo/b.txt": "hello world",
+		"/empty.txt": "",
+	}
+	for path, content := range files {
+		if err := lfs.Put(charmID, path, bytes.NewBufferString(content), fs.FileMode(0o600)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rc, err := lfs.GetMultiple(charmID, []string{"/a.txt", "/missing.txt", "/foo", "/foo/b.txt", "/empty.txt"})
+	if err != nil {
+		t.Fatalf("expected no error, %v", err)
+	}
+	defer rc.Close() // nolint:errcheck
+	mb, ok := rc.(*MultipartBody)
+	if !ok {
+		t.Fatalf("expected a MultipartBody, got %T", rc)
+	}
+	mt, params, err := mime.ParseMediaType(mb.ContentType())
+	if err != nil || mt != "multipart/mixed" {
+		t.Fatalf("unexpected content type %s, %v", mb.ContentType(), err)
+	}
+
+	got := make(map[string]string)
+	mr := multipart.NewReader(rc, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := p.Header.Get("X-File-Path")
+		if size := p.Header.Get("X-File-Size"); size != strconv.Itoa(len(b)) {
+			t.Fatalf("expected %s size header %d, got %s", path, len(b), size)
+		}
+		if mode := p.Header.Get("X-File-Mode"); mode != strconv.Itoa(0o600) {
+			t.Fatalf("expected %s mode header %d, got %s", path, 0o600, mode)
+		}
+		got[path] = string(b)
+	}
+	if len(got) != len(files) {
+		t.Fatalf("expected %d parts, got %d", len(files), len(got))
+	}
+	for path, content := range files {
+		if got[path] != content {
+			t.Fatalf("expected %s to be %q, got %q", path, content, got[path])
+		}
+	}
+}
+
+func TestGetMultipleReadsLikeGet(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/a.txt", bytes.NewBufferString("hello"), fs.FileMode(0o600)); err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.PutScratch(charmID, "/tmp.txt", bytes.NewBufferString("scratch"), fs.FileMode(0o600)); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/.scratch/tmp.txt", "/.a.txt.123.tmp"} {
+		if _, err := lfs.GetMultiple(charmID, []string{"/a.txt", path}); !errors.Is(err, storage.ErrInvalidPath) {
+			t.Fatalf("expected ErrInvalidPath getting %s, got %v", path, err)
+		}
+	}
+
+	lfs.ReadTransform = func(r io.Reader) io.Reader {
+		return io.MultiReader(r, strings.NewReader("!"))
+	}
+	rc, err := lfs.GetMultiple(charmID, []string{"/a.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close() // nolint:errcheck
+	p, err := multipart.NewReader(rc, rc.(*MultipartBody).Boundary).NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := io.ReadAll(p); err != nil || string(b) != "hello!" {
+		t.Fatalf("expected the part to be read through ReadTransform, got %q, %v", b, err)
+	}
+}
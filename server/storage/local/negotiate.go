@@ -0,0 +1,122 @@
+package localstorage
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// GetNegotiated returns the file at path for the Charm ID in a content
+// encoding the client accepts, along with the encoding, storage.EncodingGzip
+// or storage.EncodingIdentity. A gzip-compressed variant stored at path plus
+// ".gz" is returned as is when acceptGzip is set. When the gzipped variant is
+// the only one stored and the client doesn't accept gzip, it's decompressed
+// as it's read, with Stat reporting the size recorded in its gzip trailer.
+func (lfs *LocalFileStore) GetNegotiated(charmID, path string, acceptGzip bool) (fs.File, string, error) {
+	defer lfs.op()()
+	gzPath := path + ".gz"
+	gzInfo, err := os.Stat(filepath.Join(lfs.root(), charmID, gzPath))
+	hasGzip := err == nil && gzInfo.Mode().IsRegular()
+	if acceptGzip && hasGzip {
+		f, err := lfs.Get(charmID, gzPath)
+		return f, storage.EncodingGzip, err
+	}
+	f, err := lfs.Get(charmID, path)
+	if !hasGzip || !errors.Is(err, fs.ErrNotExist) {
+		return f, storage.EncodingIdentity, err
+	}
+	size, err := lfs.gzipSize(filepath.Join(lfs.root(), charmID, gzPath))
+	if err != nil {
+		return nil, "", err
+	}
+	f, err = lfs.Get(charmID, gzPath)
+	if err != nil {
+		return nil, "", err
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close() // nolint:errcheck
+		return nil, "", err
+	}
+	return &gunzipFile{File: f, zr: zr, name: filepath.Base(path), size: size}, storage.EncodingIdentity, nil
+}
+
+// gzipSize returns the uncompressed size recorded in the trailer of the gzip
+// file at fp, which is the size modulo 4GiB.
+func (lfs *LocalFileStore) gzipSize(fp string) (int64, error) {
+	f, err := lfs.openStored(fp)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close() // nolint:errcheck
+	var trailer [4]byte
+	if of, ok := f.(*os.File); ok {
+		if _, err := of.Seek(-int64(len(trailer)), io.SeekEnd); err != nil {
+			return 0, err
+		}
+		if _, err := io.ReadFull(of, trailer[:]); err != nil {
+			return 0, err
+		}
+	} else if err := readTail(f, trailer[:]); err != nil {
+		// Files stored compressed have to be read through to their end.
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint32(trailer[:])), nil
+}
+
+// readTail reads r to its end and fills tail with its last bytes.
+func readTail(r io.Reader, tail []byte) error {
+	buf := make([]byte, 32<<10)
+	n := 0
+	for {
+		m, err := io.ReadFull(r, buf[n:])
+		n += m
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if n < len(tail) {
+				return io.ErrUnexpectedEOF
+			}
+			copy(tail, buf[n-len(tail):n])
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		n = copy(buf, buf[n-len(tail):n])
+	}
+}
+
+// gunzipFile is a gzipped file decompressed as it's read.
+type gunzipFile struct {
+	fs.File
+	zr   *gzip.Reader
+	name string
+	size int64
+}
+
+func (gf *gunzipFile) Read(p []byte) (int, error) {
+	return gf.zr.Read(p)
+}
+
+func (gf *gunzipFile) Stat() (fs.FileInfo, error) {
+	info, err := gf.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return gunzipInfo{logicalInfo{info, gf.size}, gf.name}, nil
+}
+
+// gunzipInfo reports the name and size of a gzipped file once decompressed.
+type gunzipInfo struct {
+	logicalInfo
+	name string
+}
+
+func (gi gunzipInfo) Name() string {
+	return gi.name
+}
@@ -0,0 +1,91 @@
+package localstorage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+func TestGetNegotiated(t *testing.T) {
+	content := bytes.Repeat([]byte("console.log('hello');\n"), 100)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	if _, err := zw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	read := func(f fs.File) []byte {
+		t.Helper()
+		defer f.Close() // nolint:errcheck
+		data, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	for _, compress := range []bool{false, true} {
+		charmID := uuid.New().String()
+		lfs, err := NewLocalFileStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		lfs.Compress = compress
+		if err := lfs.Put(charmID, "/app.js.gz", bytes.NewReader(gz.Bytes()), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+
+		f, enc, err := lfs.GetNegotiated(charmID, "/app.js", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if enc != storage.EncodingGzip {
+			t.Fatalf("expected gzip encoding, got %s", enc)
+		}
+		if got := read(f); !bytes.Equal(got, gz.Bytes()) {
+			t.Fatal("expected the gzipped variant to be passed through")
+		}
+
+		f, enc, err = lfs.GetNegotiated(charmID, "/app.js", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if enc != storage.EncodingIdentity {
+			t.Fatalf("expected identity encoding, got %s", enc)
+		}
+		info, err := f.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Name() != "app.js" || info.Size() != int64(len(content)) {
+			t.Fatalf("expected the decompressed name and size, got %s and %d", info.Name(), info.Size())
+		}
+		if got := read(f); !bytes.Equal(got, content) {
+			t.Fatalf("expected the variant to be decompressed on read (compress %v)", compress)
+		}
+
+		if err := lfs.Put(charmID, "/app.js", bytes.NewReader(content), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+		f, enc, err = lfs.GetNegotiated(charmID, "/app.js", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := f.(*gunzipFile); ok || enc != storage.EncodingIdentity {
+			t.Fatal("expected the stored identity variant to be served")
+		}
+		read(f)
+
+		if _, _, err := lfs.GetNegotiated(charmID, "/missing.js", false); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected fs.ErrNotExist, got %v", err)
+		}
+	}
+}
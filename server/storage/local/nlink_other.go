@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package localstorage
+
+import "io/fs"
+
+// linkCount isn't supported on this platform, so blobs are never considered
+// orphaned.
+func linkCount(info fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,164 @@
+package localstorage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// PutAt writes the data from r into the file at path for the Charm ID,
+// starting at offset. The file is created if it doesn't exist and extended if
+// the data runs past its end, with any gap filled with zeros. Unlike Put, the
+// file is changed in place, but writes to the same path are serialized. The
+// data is staged first, so a write that exceeds MaxFileBytes or the quota
+// leaves the file untouched. Files stored compressed can't be patched and fail
+// with storage.ErrInvalidTarget.
+func (lfs *LocalFileStore) PutAt(charmID, path string, offset int64, r io.Reader) error {
+	defer lfs.op()()
+	_, err := lfs.putAt(charmID, path, offset, r, nil)
+	return err
+}
+
+// ResumablePut appends the data from r to the file at path for the Charm ID
+// and returns the file's new length. knownOffset must be the file's current
+// length, or zero if it doesn't exist yet, otherwise storage.ErrOffsetMismatch
+// is returned and nothing is written. A client resuming an interrupted upload
+// can Stat the file for its length and continue from there.
+func (lfs *LocalFileStore) ResumablePut(charmID, path string, r io.Reader, knownOffset int64) (int64, error) {
+	defer lfs.op()()
+	n, err := lfs.putAt(charmID, path, knownOffset, r, func(size int64) error {
+		if size != knownOffset {
+			return storage.ErrOffsetMismatch
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return knownOffset + n, nil
+}
+
+// putAt writes the data from r into the file at path starting at offset and
+// returns the number of bytes written. If check is set, it's called with the
+// file's current size while the path is locked and the write is aborted if it
+// returns an error.
+func (lfs *LocalFileStore) putAt(charmID, path string, offset int64, r io.Reader, check func(size int64) error) (int64, error) {
+	if offset < 0 {
+		return 0, storage.ErrInvalidOffset
+	}
+	if cpath := filepath.Clean(path); cpath == string(os.PathSeparator) || inReserved(cpath) {
+		return 0, fmt.Errorf("invalid path specified: %s", cpath)
+	}
+	fp := filepath.Join(lfs.root(), charmID, path)
+	defer lfs.lockPath(fp)()
+	var reservationID string
+	defer func() { lfs.releaseQuota(charmID, reservationID) }()
+	defer lfs.trackUsage(charmID, fp)()
+	var size int64
+	if info, err := os.Lstat(fp); err == nil {
+		if info.IsDir() || info.Mode()&specialModes != 0 {
+			return 0, storage.ErrInvalidTarget
+		}
+		size = info.Size()
+	}
+	if _, ok, err := lfs.logicalSize(fp); err != nil || ok {
+		if err != nil {
+			return 0, err
+		}
+		return 0, storage.ErrInvalidTarget
+	}
+	if check != nil {
+		if err := check(size); err != nil {
+			return 0, err
+		}
+	}
+	if err := lfs.ensureParent(charmID, path, 0o755); err != nil {
+		return 0, err
+	}
+
+	max := int64(-1)
+	if lfs.MaxFileBytes > 0 {
+		max = lfs.MaxFileBytes - offset
+		if max < 0 {
+			return 0, storage.ErrFileTooLarge
+		}
+	}
+	allowed, err := lfs.allowance(charmID, fp, "")
+	if err != nil {
+		return 0, err
+	}
+	if allowed >= 0 && (max < 0 || allowed-offset < max) {
+		max = allowed - offset
+		if max < 0 {
+			return 0, storage.ErrQuotaExceeded
+		}
+	}
+	if max >= 0 {
+		r = io.LimitReader(r, max+1)
+	}
+	tmp, err := lfs.createTemp(fp)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name()) // nolint:errcheck
+	defer tmp.Close()           // nolint:errcheck
+	n, err := lfs.copy(tmp, r)
+	if err != nil {
+		return 0, err
+	}
+	if max >= 0 && n > max {
+		if lfs.MaxFileBytes > 0 && offset+n > lfs.MaxFileBytes {
+			return 0, storage.ErrFileTooLarge
+		}
+		return 0, storage.ErrQuotaExceeded
+	}
+	if err := lfs.sniffPatch(fp, offset, tmp, n); err != nil {
+		return 0, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if end := offset + n; end > size {
+		if reservationID, err = lfs.claimQuota(charmID, end, size); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := unshare(fp); err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	f, err := os.OpenFile(fp, os.O_WRONLY|os.O_CREATE, defaultFileMode(fp))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close() // nolint:errcheck
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if _, err := lfs.copy(f, tmp); err != nil {
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	lfs.patched(charmID, path, fp)
+	return n, nil
+}
+
+// patched updates the generations and checksum of the file at path after it's
+// been changed in place. The change is already made, so failing to record its
+// checksum only drops the old one, leaving the file unverified.
+func (lfs *LocalFileStore) patched(charmID, path, fp string) {
+	lfs.touch(charmID)                 // nolint:errcheck
+	lfs.bumpGenerations(charmID, path) // nolint:errcheck
+	sum, err := lfs.checksumFile(fp, lfs.checksumAlgo(), nil)
+	if err == nil {
+		err = lfs.writeChecksum(charmID, path, sum)
+	}
+	if err != nil {
+		lfs.removeChecksum(charmID, path) // nolint:errcheck
+	}
+}
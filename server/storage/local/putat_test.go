@@ -0,0 +1,118 @@
+package localstorage
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+func TestPutAt(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp := filepath.Join(lfs.Path, charmID, "data.bin")
+	read := func() string {
+		t.Helper()
+		b, err := os.ReadFile(fp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	if err := lfs.Put(charmID, "/data.bin", bytes.NewBufferString("hello world"), fs.FileMode(0o600)); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("middle", func(t *testing.T) {
+		if err := lfs.PutAt(charmID, "/data.bin", 6, bytes.NewBufferString("charm")); err != nil {
+			t.Fatalf("expected no error patching, %v", err)
+		}
+		if got := read(); got != "hello charm" {
+			t.Fatalf("expected patched content, got %q", got)
+		}
+		info, err := os.Stat(fp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0o600 {
+			t.Fatalf("expected mode to be kept, got %v", info.Mode().Perm())
+		}
+		ok, err := lfs.Verify(charmID, "/data.bin")
+		if err != nil || !ok {
+			t.Fatalf("expected checksum to be updated, %t %v", ok, err)
+		}
+	})
+
+	t.Run("past end", func(t *testing.T) {
+		if err := lfs.PutAt(charmID, "/data.bin", 14, bytes.NewBufferString("!")); err != nil {
+			t.Fatalf("expected no error extending, %v", err)
+		}
+		if got := read(); got != "hello charm\x00\x00\x00!" {
+			t.Fatalf("expected zero-filled gap, got %q", got)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		if err := lfs.PutAt(charmID, "/data.bin", -1, bytes.NewBufferString("x")); !errors.Is(err, storage.ErrInvalidOffset) {
+			t.Fatalf("expected ErrInvalidOffset, got %v", err)
+		}
+		lfs.MaxFileBytes = 16
+		defer func() { lfs.MaxFileBytes = 0 }()
+		before := read()
+		if err := lfs.PutAt(charmID, "/data.bin", 10, bytes.NewBufferString("too much data")); !errors.Is(err, storage.ErrFileTooLarge) {
+			t.Fatalf("expected ErrFileTooLarge, got %v", err)
+		}
+		if got := read(); got != before {
+			t.Fatalf("expected file to be untouched, got %q", got)
+		}
+	})
+}
+
+func TestResumablePut(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := lfs.ResumablePut(charmID, "/upload.bin", bytes.NewBufferString("hello "), 0)
+	if err != nil {
+		t.Fatalf("expected no error starting upload, %v", err)
+	}
+	if n != 6 {
+		t.Fatalf("expected length 6, got %d", n)
+	}
+	info, err := lfs.Stat(charmID, "/upload.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err = lfs.ResumablePut(charmID, "/upload.bin", bytes.NewBufferString("world"), info.Size())
+	if err != nil {
+		t.Fatalf("expected no error resuming upload, %v", err)
+	}
+	if n != 11 {
+		t.Fatalf("expected length 11, got %d", n)
+	}
+
+	if _, err := lfs.ResumablePut(charmID, "/upload.bin", bytes.NewBufferString("!"), 6); !errors.Is(err, storage.ErrOffsetMismatch) {
+		t.Fatalf("expected ErrOffsetMismatch, got %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(lfs.Path, charmID, "upload.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello world" {
+		t.Fatalf("expected resumed content, got %q", b)
+	}
+	ok, err := lfs.Verify(charmID, "/upload.bin")
+	if err != nil || !ok {
+		t.Fatalf("expected checksum to match, %t %v", ok, err)
+	}
+}
@@ -0,0 +1,294 @@
+package localstorage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+type reservation struct {
+	charmID string
+	bytes   int64
+}
+
+// usage is the cached number of bytes stored for a Charm ID, so checking the
+// quota doesn't walk the Charm ID directory on every write. Writes adjust it
+// by the bytes they add or free.
+type usage struct {
+	mu    sync.Mutex
+	bytes int64
+	valid bool
+	// epoch counts the changes made. A walk that raced with a change doesn't
+	// cache its total, as it may or may not include the change.
+	epoch uint64
+}
+
+// Usage returns the number of bytes stored for the Charm ID.
+func (lfs *LocalFileStore) Usage(charmID string) (int64, error) {
+	defer lfs.op()()
+	u := lfs.usageOf(charmID)
+	u.mu.Lock()
+	if u.valid {
+		defer u.mu.Unlock()
+		return u.bytes, nil
+	}
+	epoch := u.epoch
+	u.mu.Unlock()
+	n, err := lfs.dirSize(charmID, "")
+	if errors.Is(err, fs.ErrNotExist) {
+		n, err = 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	u.mu.Lock()
+	if u.epoch == epoch {
+		u.bytes, u.valid = n, true
+	}
+	u.mu.Unlock()
+	return n, nil
+}
+
+// usageOf returns the cached usage of the Charm ID.
+func (lfs *LocalFileStore) usageOf(charmID string) *usage {
+	lfs.usageMu.Lock()
+	defer lfs.usageMu.Unlock()
+	if lfs.usages == nil {
+		lfs.usages = make(map[string]*usage)
+	}
+	u, ok := lfs.usages[charmID]
+	if !ok {
+		u = &usage{}
+		lfs.usages[charmID] = u
+	}
+	return u
+}
+
+// addUsage adjusts the cached usage of the Charm ID by the delta bytes a write
+// added or freed.
+func (lfs *LocalFileStore) addUsage(charmID string, delta int64) {
+	u := lfs.usageOf(charmID)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.bytes += delta
+	u.epoch++
+}
+
+// resetUsage drops the cached usage of the Charm ID after a change whose size
+// isn't tracked, so it's counted again when it's next needed.
+func (lfs *LocalFileStore) resetUsage(charmID string) {
+	u := lfs.usageOf(charmID)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.valid = false
+	u.epoch++
+}
+
+// trackUsage notes the size of the file at fp before a write and returns a
+// function that adjusts the cached usage of the Charm ID by what the write
+// changed, to be deferred while fp is locked. The cached usage is reset
+// instead if fp is, or was, something other than a regular file, such as a
+// directory.
+func (lfs *LocalFileStore) trackUsage(charmID, fp string) func() {
+	before, ok := regularSize(fp)
+	return func() {
+		after, aok := regularSize(fp)
+		if !ok || !aok {
+			lfs.resetUsage(charmID)
+			return
+		}
+		lfs.addUsage(charmID, after-before)
+	}
+}
+
+// regularSize returns the size on disk of the regular file at fp, or zero if
+// there's nothing there, and false if it's anything else.
+func regularSize(fp string) (int64, bool) {
+	info, err := os.Lstat(fp)
+	if os.IsNotExist(err) {
+		return 0, true
+	}
+	if err != nil || !info.Mode().IsRegular() {
+		return 0, false
+	}
+	return info.Size(), true
+}
+
+// Headroom returns the bytes counting against the Charm ID's quota, including
+// those set aside with ReserveQuota, and the quota itself, or -1 if there's no
+// quota. Clients can use it to check an upload will fit before starting it.
+func (lfs *LocalFileStore) Headroom(charmID string) (used int64, limit int64, err error) {
+	defer lfs.op()()
+	used, err = lfs.Usage(charmID)
+	if err != nil {
+		return 0, 0, err
+	}
+	lfs.quotaMu.Lock()
+	used += lfs.reserved(charmID)
+	lfs.quotaMu.Unlock()
+	if lfs.QuotaBytes <= 0 {
+		return used, -1, nil
+	}
+	return used, lfs.QuotaBytes, nil
+}
+
+// dirSize returns the total size of the files below path for the Charm ID.
+func (lfs *LocalFileStore) dirSize(charmID, path string) (int64, error) {
+	var n int64
+	err := lfs.walk(charmID, path, func(rel string, d fs.DirEntry) error {
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		n += info.Size()
+		return nil
+	})
+	return n, err
+}
+
+// ReserveQuota sets aside bytes of the Charm ID's quota for an upload and
+// returns the ID of the reservation. Reserved bytes count against the quota
+// until the reservation is used by Put, through storage.PutOptions, or freed
+// with ReleaseQuota. Reserving makes concurrent uploads unable to overshoot
+// the quota together. storage.ErrQuotaExceeded is returned if the quota can't
+// fit the reservation.
+func (lfs *LocalFileStore) ReserveQuota(charmID string, bytes int64) (string, error) {
+	defer lfs.op()()
+	lfs.quotaMu.Lock()
+	defer lfs.quotaMu.Unlock()
+	return lfs.reserve(charmID, bytes)
+}
+
+// claimQuota checks a write growing a file of the Charm ID from existing to n
+// bytes on disk fits in the quota and reserves the bytes it adds, returning
+// the ID of the reservation or an empty ID if nothing needs reserving.
+// Checking and reserving under one lock keeps concurrent writes from
+// overshooting the quota together. The reservation is to be released with
+// releaseQuota once the write is counted in the Charm ID's usage.
+func (lfs *LocalFileStore) claimQuota(charmID string, n, existing int64) (string, error) {
+	if lfs.QuotaBytes <= 0 || n <= existing {
+		return "", nil
+	}
+	lfs.quotaMu.Lock()
+	defer lfs.quotaMu.Unlock()
+	return lfs.reserve(charmID, n-existing)
+}
+
+// reserve sets aside bytes of the Charm ID's quota and returns the ID of the
+// reservation. The caller must hold quotaMu.
+func (lfs *LocalFileStore) reserve(charmID string, bytes int64) (string, error) {
+	if lfs.QuotaBytes > 0 {
+		usage, err := lfs.Usage(charmID)
+		if err != nil {
+			return "", err
+		}
+		if usage+lfs.reserved(charmID)+bytes > lfs.QuotaBytes {
+			return "", storage.ErrQuotaExceeded
+		}
+	}
+	if lfs.reservations == nil {
+		lfs.reservations = make(map[string]reservation)
+	}
+	id := uuid.New().String()
+	lfs.reservations[id] = reservation{charmID: charmID, bytes: bytes}
+	return id, nil
+}
+
+// ReleaseQuota frees a reservation made with ReserveQuota, such as when an
+// upload is aborted. Releasing an unknown reservation is a no-op.
+func (lfs *LocalFileStore) ReleaseQuota(reservationID string) {
+	lfs.quotaMu.Lock()
+	defer lfs.quotaMu.Unlock()
+	delete(lfs.reservations, reservationID)
+}
+
+// releaseQuota frees the reservation used by a write for the Charm ID, unless
+// it belongs to another Charm ID.
+func (lfs *LocalFileStore) releaseQuota(charmID, reservationID string) {
+	lfs.quotaMu.Lock()
+	defer lfs.quotaMu.Unlock()
+	if res, ok := lfs.reservations[reservationID]; ok && res.charmID == charmID {
+		delete(lfs.reservations, reservationID)
+	}
+}
+
+// renameReservations moves the reservations of oldID to newID.
+func (lfs *LocalFileStore) renameReservations(oldID, newID string) {
+	lfs.quotaMu.Lock()
+	defer lfs.quotaMu.Unlock()
+	for id, res := range lfs.reservations {
+		if res.charmID == oldID {
+			res.charmID = newID
+			lfs.reservations[id] = res
+		}
+	}
+}
+
+// allowance returns how many bytes may be written to fp for the Charm ID, or
+// -1 if there's no limit. Bytes of the file being replaced are freed by the
+// write so they count towards the allowance.
+func (lfs *LocalFileStore) allowance(charmID, fp, reservationID string) (int64, error) {
+	if lfs.QuotaBytes <= 0 {
+		return -1, nil
+	}
+	var existing int64
+	if info, err := os.Stat(fp); err == nil && info.Mode().IsRegular() {
+		existing = info.Size()
+	}
+	if reservationID != "" {
+		lfs.quotaMu.Lock()
+		defer lfs.quotaMu.Unlock()
+		res, ok := lfs.reservations[reservationID]
+		if !ok || res.charmID != charmID {
+			return 0, storage.ErrUnknownReservation
+		}
+		return res.bytes + existing, nil
+	}
+	usage, err := lfs.Usage(charmID)
+	if err != nil {
+		return 0, err
+	}
+	lfs.quotaMu.Lock()
+	defer lfs.quotaMu.Unlock()
+	n := lfs.QuotaBytes - usage - lfs.reserved(charmID) + existing
+	if n < 0 {
+		n = 0
+	}
+	return n, nil
+}
+
+// reserved returns the bytes reserved for the Charm ID. The caller must hold
+// quotaMu.
+func (lfs *LocalFileStore) reserved(charmID string) int64 {
+	var n int64
+	for _, res := range lfs.reservations {
+		if res.charmID == charmID {
+			n += res.bytes
+		}
+	}
+	return n
+}
+
+// limitWriter writes to w, failing with err rather than write more than n
+// bytes in all.
+type limitWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (lw *limitWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > lw.n {
+		return 0, lw.err
+	}
+	lw.n -= int64(len(p))
+	return lw.w.Write(p)
+}
@@ -0,0 +1,255 @@
+package localstorage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+	"testing"
+
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+func TestReserveQuota(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.QuotaBytes = 10
+	if err := lfs.Put(charmID, "/a.txt", bytes.NewBufferString("1234"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two uploads of 4 bytes each would fit alone, but not together.
+	var wg sync.WaitGroup
+	ids := make([]string, 2)
+	errs := make([]error, 2)
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ids[i], errs[i] = lfs.ReserveQuota(charmID, 4)
+		}(i)
+	}
+	wg.Wait()
+	var id string
+	failed := 0
+	for i, err := range errs {
+		switch {
+		case errors.Is(err, storage.ErrQuotaExceeded):
+			failed++
+		case err != nil:
+			t.Fatalf("unexpected error reserving quota, %v", err)
+		default:
+			id = ids[i]
+		}
+	}
+	if failed != 1 || id == "" {
+		t.Fatalf("expected exactly one reservation to fail, got %v", errs)
+	}
+
+	// Unreserved writes can't use reserved bytes.
+	err = lfs.Put(charmID, "/b.txt", &opaqueReader{bytes.NewBufferString("1234")}, fs.FileMode(0o644))
+	if !errors.Is(err, storage.ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded for an unreserved write, got %v", err)
+	}
+
+	opts := storage.PutOptions{ReservationID: id}
+	if err := lfs.PutWithOptions(charmID, "/b.txt", &opaqueReader{bytes.NewBufferString("1234")}, fs.FileMode(0o644), opts); err != nil {
+		t.Fatalf("expected reserved write to succeed, %v", err)
+	}
+	if err := lfs.PutWithOptions(charmID, "/c.txt", bytes.NewBufferString("1"), fs.FileMode(0o644), opts); !errors.Is(err, storage.ErrUnknownReservation) {
+		t.Fatalf("expected reservation to be consumed, got %v", err)
+	}
+	if _, err := lfs.ReserveQuota(charmID, 3); !errors.Is(err, storage.ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded once quota is used, got %v", err)
+	}
+
+	id, err = lfs.ReserveQuota(charmID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lfs.ReserveQuota(charmID, 1); !errors.Is(err, storage.ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded while quota is reserved, got %v", err)
+	}
+	lfs.ReleaseQuota(id)
+	if _, err := lfs.ReserveQuota(charmID, 1); err != nil {
+		t.Fatalf("expected released quota to be available, %v", err)
+	}
+
+	usage, err := lfs.Usage(charmID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage != 8 {
+		t.Fatalf("expected usage of 8 bytes, got %d", usage)
+	}
+}
+
+func TestHeadroom(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(wantUsed, wantLimit int64) {
+		t.Helper()
+		used, limit, err := lfs.Headroom(charmID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if used != wantUsed || limit != wantLimit {
+			t.Fatalf("expected %d of %d used, got %d of %d", wantUsed, wantLimit, used, limit)
+		}
+	}
+	check(0, -1)
+	if err := lfs.Put(charmID, "/a.txt", bytes.NewBufferString("1234"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	check(4, -1)
+
+	lfs.QuotaBytes = 10
+	check(4, 10)
+	id, err := lfs.ReserveQuota(charmID, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(10, 10)
+	lfs.ReleaseQuota(id)
+	if err := lfs.Put(charmID, "/b.txt", bytes.NewBufferString("123456"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	check(10, 10)
+}
+
+func TestUsageCached(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(want int64) {
+		t.Helper()
+		got, err := lfs.Usage(charmID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("expected usage of %d bytes, got %d", want, got)
+		}
+		walked, err := lfs.dirSize(charmID, "")
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			t.Fatal(err)
+		}
+		if walked != want {
+			t.Fatalf("expected %d bytes on disk, got %d", want, walked)
+		}
+	}
+	check(0)
+	if err := lfs.Put(charmID, "/a.txt", bytes.NewBufferString("1234"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	check(4)
+	if err := lfs.Put(charmID, "/d/b.txt", bytes.NewBufferString("123456"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	check(10)
+	if err := lfs.Put(charmID, "/a.txt", bytes.NewBufferString("12"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	check(8)
+	if err := lfs.PutAt(charmID, "/a.txt", 2, bytes.NewBufferString("345")); err != nil {
+		t.Fatal(err)
+	}
+	check(11)
+	tx, err := lfs.Transaction(charmID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Put("/c.txt", bytes.NewBufferString("1"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Delete("/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	check(7)
+	if err := lfs.Delete(charmID, "/d"); err != nil {
+		t.Fatal(err)
+	}
+	check(1)
+	if err := lfs.Delete(charmID, "/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	check(0)
+}
+
+func TestPutOthersReservation(t *testing.T) {
+	alice, bob := uuid.New().String(), uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.QuotaBytes = 10
+	id, err := lfs.ReserveQuota(alice, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := storage.PutOptions{ReservationID: id}
+	err = lfs.PutWithOptions(bob, "/a.txt", bytes.NewBufferString("1234"), fs.FileMode(0o644), opts)
+	if !errors.Is(err, storage.ErrUnknownReservation) {
+		t.Fatalf("expected ErrUnknownReservation using another Charm ID's reservation, got %v", err)
+	}
+	if err := lfs.PutWithOptions(alice, "/a.txt", &opaqueReader{bytes.NewBufferString("1234")}, fs.FileMode(0o644), opts); err != nil {
+		t.Fatalf("expected the reservation to still be usable by its owner, %v", err)
+	}
+}
+
+func TestConcurrentPutsQuota(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.QuotaBytes = 10
+
+	// Each write fits alone, but only two fit together.
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	errs := make([]error, 20)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs[i] = lfs.Put(charmID, fmt.Sprintf("/%d.txt", i), &opaqueReader{bytes.NewBufferString("1234")}, fs.FileMode(0o644))
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	stored := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			stored++
+		case !errors.Is(err, storage.ErrQuotaExceeded):
+			t.Fatalf("unexpected error writing, %v", err)
+		}
+	}
+	if stored != 2 {
+		t.Fatalf("expected 2 writes to fit in the quota, got %d", stored)
+	}
+	n, err := lfs.dirSize(charmID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 8 {
+		t.Fatalf("expected 8 bytes on disk, got %d", n)
+	}
+}
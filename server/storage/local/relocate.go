@@ -0,0 +1,200 @@
+package localstorage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// Relocate moves everything in the store to newPath, such as onto a bigger
+// volume, and switches the store to it. Calls in flight are waited for, as are
+// listings and archives still being streamed, and new calls wait until the
+// store has moved. It fails with storage.ErrStuck while calls that timed out
+// after OpTimeout are still running. The store is renamed into place when
+// newPath is on the same volume. Otherwise it's copied, the copy is verified
+// against the original, and the original is removed once the store has
+// switched. newPath must not exist or be an empty directory.
+func (lfs *LocalFileStore) Relocate(newPath string) error {
+	newPath, err := filepath.Abs(newPath)
+	if err != nil {
+		return err
+	}
+	unlock, err := lfs.exclusive()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	lfs.txMu.Lock()
+	defer lfs.txMu.Unlock()
+	old, err := filepath.Abs(lfs.root())
+	if err != nil {
+		return err
+	}
+	if old == newPath {
+		return nil
+	}
+	if rel, err := filepath.Rel(old, newPath); err == nil && rel != ".." && !startsWithParent(rel) {
+		return fmt.Errorf("can't relocate %s into itself", old)
+	}
+	des, err := os.ReadDir(newPath)
+	switch {
+	case err == nil && len(des) > 0:
+		return fmt.Errorf("relocate: %s isn't empty", newPath)
+	case err == nil:
+		if err := os.Remove(newPath); err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(newPath), 0o700); err != nil {
+		return err
+	}
+	err = rename(old, newPath)
+	if err == nil {
+		lfs.setRoot(newPath)
+		return nil
+	}
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyTree(old, newPath); err != nil {
+		os.RemoveAll(newPath) // nolint:errcheck
+		return err
+	}
+	if err := verifyTree(old, newPath); err != nil {
+		os.RemoveAll(newPath) // nolint:errcheck
+		return err
+	}
+	lfs.setRoot(newPath)
+	return os.RemoveAll(old)
+}
+
+// op marks a call as in flight until the returned func is called, waiting
+// first for a Relocate or RenameCharmID in progress to finish. Calls may nest:
+// exclusive waits until none are in flight rather than holding new ones off,
+// so a call made while one is running never waits.
+func (lfs *LocalFileStore) op() func() {
+	lfs.opMu.Lock()
+	for lfs.moving != nil {
+		moving := lfs.moving
+		lfs.opMu.Unlock()
+		<-moving
+		lfs.opMu.Lock()
+	}
+	lfs.inflight++
+	lfs.opMu.Unlock()
+	return lfs.endOp
+}
+
+func (lfs *LocalFileStore) endOp() {
+	lfs.opMu.Lock()
+	defer lfs.opMu.Unlock()
+	lfs.inflight--
+	if lfs.inflight == 0 && lfs.drained != nil {
+		close(lfs.drained)
+		lfs.drained = nil
+	}
+}
+
+// exclusive waits until no calls are in flight and holds new ones off until
+// the returned func is called. Nothing called meanwhile may take op. It fails
+// with storage.ErrStuck rather than wait for calls that timed out.
+func (lfs *LocalFileStore) exclusive() (func(), error) {
+	lfs.exclMu.Lock()
+	lfs.opMu.Lock()
+	for lfs.inflight > 0 {
+		if lfs.stuck > 0 {
+			lfs.opMu.Unlock()
+			lfs.exclMu.Unlock()
+			return nil, storage.ErrStuck
+		}
+		if lfs.drained == nil {
+			lfs.drained = make(chan struct{})
+		}
+		drained := lfs.drained
+		lfs.opMu.Unlock()
+		<-drained
+		lfs.opMu.Lock()
+	}
+	lfs.moving = make(chan struct{})
+	lfs.opMu.Unlock()
+	return func() {
+		lfs.opMu.Lock()
+		close(lfs.moving)
+		lfs.moving = nil
+		lfs.opMu.Unlock()
+		lfs.exclMu.Unlock()
+	}, nil
+}
+
+// root returns the directory the store keeps its data in. It's read through
+// here rather than from Path so Relocate can switch it safely.
+func (lfs *LocalFileStore) root() string {
+	lfs.pathMu.RLock()
+	defer lfs.pathMu.RUnlock()
+	return lfs.Path
+}
+
+// setRoot switches the store to the directory path. The case sensitivity of
+// the new volume is probed again when it's next needed.
+func (lfs *LocalFileStore) setRoot(path string) {
+	lfs.pathMu.Lock()
+	lfs.Path = path
+	lfs.pathMu.Unlock()
+	lfs.caseMu.Lock()
+	lfs.caseProbed = false
+	lfs.caseMu.Unlock()
+}
+
+// startsWithParent reports whether the relative path rel leaves its base.
+func startsWithParent(rel string) bool {
+	return len(rel) >= 3 && rel[:3] == ".."+string(os.PathSeparator)
+}
+
+// verifyTree checks that every file below src has a copy with the same
+// contents below dst.
+func verifyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(src, fp)
+		if err != nil {
+			return err
+		}
+		want, err := fileDigest(fp)
+		if err != nil {
+			return err
+		}
+		got, err := fileDigest(filepath.Join(dst, rel))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got, want) {
+			return fmt.Errorf("relocate: copy of %s differs", rel)
+		}
+		return nil
+	})
+}
+
+func fileDigest(fp string) ([]byte, error) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // nolint:errcheck
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,200 @@
+package localstorage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRelocate(t *testing.T) {
+	for _, crossVolume := range []bool{false, true} {
+		t.Run(fmt.Sprintf("cross volume %v", crossVolume), func(t *testing.T) {
+			charmID := uuid.New().String()
+			oldPath := filepath.Join(t.TempDir(), "data")
+			if crossVolume {
+				// Only the move of the store root crosses volumes.
+				rename = func(oldpath, newpath string) error {
+					if oldpath == oldPath {
+						return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+					}
+					return os.Rename(oldpath, newpath)
+				}
+				defer func() { rename = os.Rename }()
+			}
+			lfs, err := NewLocalFileStore(oldPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 10; i++ {
+				if err := lfs.Put(charmID, fmt.Sprintf("/dir/%d.txt", i), bytes.NewBufferString(fmt.Sprint(i)), fs.FileMode(0o644)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := lfs.Relocate(filepath.Join(lfs.Path, "nested")); err == nil {
+				t.Fatal("expected an error relocating into the store")
+			}
+
+			// Operations keep working while the store moves.
+			var wg sync.WaitGroup
+			errs := make(chan error, 100)
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; j < 20; j++ {
+						path := fmt.Sprintf("/busy/%d-%d.txt", i, j)
+						if err := lfs.Put(charmID, path, bytes.NewBufferString("busy"), fs.FileMode(0o644)); err != nil {
+							errs <- err
+							return
+						}
+						if _, err := lfs.Stat(charmID, path); err != nil {
+							errs <- err
+							return
+						}
+						if _, err := lfs.Usage(charmID); err != nil {
+							errs <- err
+							return
+						}
+					}
+				}(i)
+			}
+			newPath := filepath.Join(t.TempDir(), "moved")
+			if err := lfs.Relocate(newPath); err != nil {
+				t.Fatal(err)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Fatalf("expected operations to continue during the move, got %v", err)
+			}
+
+			if lfs.Path != newPath {
+				t.Fatalf("expected the store to be at %s, got %s", newPath, lfs.Path)
+			}
+			if _, err := os.Stat(oldPath); !os.IsNotExist(err) {
+				t.Fatalf("expected the old path to be gone, got %v", err)
+			}
+			for i := 0; i < 10; i++ {
+				f, err := lfs.Get(charmID, fmt.Sprintf("/dir/%d.txt", i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, err := io.ReadAll(f)
+				f.Close() // nolint:errcheck
+				if err != nil || string(data) != fmt.Sprint(i) {
+					t.Fatalf("expected the data to be readable after the move, got %q, %v", data, err)
+				}
+			}
+			fis, err := lfs.ListRecursive(charmID, "/busy")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(fis) != 80 {
+				t.Fatalf("expected 80 files written during the move, got %d", len(fis))
+			}
+			if ok, err := lfs.Verify(charmID, "/dir/3.txt"); err != nil || !ok {
+				t.Fatalf("expected checksums to move with the data, got %v, %v", ok, err)
+			}
+		})
+	}
+}
+
+func TestRelocateConcurrentWrites(t *testing.T) {
+	for _, crossVolume := range []bool{false, true} {
+		t.Run(fmt.Sprintf("cross volume %v", crossVolume), func(t *testing.T) {
+			charmID := uuid.New().String()
+			base := t.TempDir()
+			if crossVolume {
+				rename = func(oldpath, newpath string) error {
+					if filepath.Dir(oldpath) == base {
+						return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+					}
+					return os.Rename(oldpath, newpath)
+				}
+				defer func() { rename = os.Rename }()
+			}
+			lfs, err := NewLocalFileStore(filepath.Join(base, "0"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := lfs.Put(charmID, "/a.txt", bytes.NewBufferString("a"), fs.FileMode(0o644)); err != nil {
+				t.Fatal(err)
+			}
+			if err := lfs.Put(charmID, "/b.txt", bytes.NewBufferString("b"), fs.FileMode(0o644)); err != nil {
+				t.Fatal(err)
+			}
+
+			const writes = 40
+			var wg sync.WaitGroup
+			errs := make(chan error, 10)
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					path := fmt.Sprintf("/appended/%d.txt", i)
+					for j := 0; j < writes; j++ {
+						if err := lfs.PutAt(charmID, path, int64(j*4), bytes.NewBufferString(fmt.Sprintf("%04d", j))); err != nil {
+							errs <- err
+							return
+						}
+					}
+				}(i)
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < writes; j++ {
+					if err := lfs.Swap(charmID, "/a.txt", "/b.txt"); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}()
+			for i := 1; i <= 3; i++ {
+				if err := lfs.Relocate(filepath.Join(base, fmt.Sprint(i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Fatalf("expected writes to continue during the move, got %v", err)
+			}
+
+			var want bytes.Buffer
+			for j := 0; j < writes; j++ {
+				fmt.Fprintf(&want, "%04d", j)
+			}
+			for i := 0; i < 4; i++ {
+				f, err := lfs.Get(charmID, fmt.Sprintf("/appended/%d.txt", i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, err := io.ReadAll(f)
+				f.Close() // nolint:errcheck
+				if err != nil || string(data) != want.String() {
+					t.Fatalf("expected every write at an offset to survive the moves, got %q, %v", data, err)
+				}
+			}
+			for path, want := range map[string]string{"/a.txt": "a", "/b.txt": "b"} {
+				f, err := lfs.Get(charmID, path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, err := io.ReadAll(f)
+				f.Close() // nolint:errcheck
+				if err != nil || string(data) != want {
+					t.Fatalf("expected %s to hold %q after an even number of swaps, got %q, %v", path, want, data, err)
+				}
+			}
+		})
+	}
+}
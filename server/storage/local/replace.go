@@ -0,0 +1,184 @@
+package localstorage
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// binarySniffLen is how much of a file is checked for null bytes to decide
+// whether it's binary.
+const binarySniffLen = 8000
+
+// ReplaceInFiles replaces every occurrence of old with new in the text files
+// stored for the Charm ID that match glob, and returns the paths of the files
+// that changed. A glob without a slash is matched against file names, and one
+// with a slash against paths relative to the Charm ID directory, using
+// path.Match. Files with a null byte near their start are taken to be binary
+// and skipped. Each file is rewritten through Put, so the rewrite is atomic
+// and subject to MaxFileBytes and the quota; the file's mode and extended
+// attributes are kept. On error, the files changed so far are returned.
+func (lfs *LocalFileStore) ReplaceInFiles(charmID, glob string, old, new []byte) (changed []string, err error) {
+	defer lfs.op()()
+	if len(old) == 0 {
+		return nil, errors.New("nothing to replace")
+	}
+	if _, err := path.Match(glob, ""); err != nil {
+		return nil, err
+	}
+	changed = make([]string, 0)
+	err = lfs.walk(charmID, "", func(rel string, d fs.DirEntry) error {
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		name := d.Name()
+		if strings.Contains(glob, "/") {
+			name = rel
+		}
+		if ok, _ := path.Match(glob, name); !ok {
+			return nil
+		}
+		ok, err := lfs.replaceInFile(charmID, rel, d, old, new)
+		if err != nil {
+			return err
+		}
+		if ok {
+			changed = append(changed, rel)
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	return changed, err
+}
+
+// replaceInFile rewrites the file at rel with old replaced by new and reports
+// whether it had any occurrences.
+func (lfs *LocalFileStore) replaceInFile(charmID, rel string, d fs.DirEntry, old, new []byte) (bool, error) {
+	fp := filepath.Join(lfs.root(), charmID, filepath.FromSlash(rel))
+	f, err := lfs.openStored(fp)
+	if err != nil {
+		return false, err
+	}
+	found, err := containsText(f, old)
+	f.Close() // nolint:errcheck
+	if err != nil || !found {
+		return false, err
+	}
+	info, err := d.Info()
+	if err != nil {
+		return false, err
+	}
+	xattrs, err := getXattrs(fp)
+	if err != nil {
+		return false, err
+	}
+	f, err = lfs.openStored(fp)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close() // nolint:errcheck
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(replaceStream(pw, f, old, new)) // nolint:errcheck
+	}()
+	err = lfs.PutWithOptions(charmID, rel, pr, info.Mode().Perm(), storage.PutOptions{Xattrs: xattrs})
+	pr.CloseWithError(err) // nolint:errcheck
+	return err == nil, err
+}
+
+// containsText reports whether r holds old and doesn't look binary.
+func containsText(r io.Reader, old []byte) (bool, error) {
+	br := bufio.NewReaderSize(r, binarySniffLen)
+	if head, _ := br.Peek(binarySniffLen); bytes.IndexByte(head, 0) >= 0 {
+		return false, nil
+	}
+	found := false
+	err := scanStream(br, len(old), func(chunk []byte, final bool) int {
+		if bytes.Contains(chunk, old) {
+			found = true
+			return -1
+		}
+		return safeKeep(chunk, len(old), final)
+	})
+	return found, err
+}
+
+// replaceStream copies r to w with every occurrence of old replaced by new.
+func replaceStream(w io.Writer, r io.Reader, old, new []byte) error {
+	var werr error
+	err := scanStream(r, len(old), func(chunk []byte, final bool) int {
+		// Occurrences starting before limit end within the chunk.
+		limit := len(chunk) - safeKeep(chunk, len(old), final)
+		var buf bytes.Buffer
+		start := 0
+		for {
+			i := bytes.Index(chunk[start:], old)
+			if i < 0 || start+i >= limit {
+				break
+			}
+			buf.Write(chunk[start : start+i])
+			buf.Write(new)
+			start += i + len(old)
+		}
+		if start < limit {
+			buf.Write(chunk[start:limit])
+			start = limit
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			werr = err
+			return -1
+		}
+		return len(chunk) - start
+	})
+	if werr != nil {
+		return werr
+	}
+	return err
+}
+
+// safeKeep returns how many trailing bytes of chunk could be the start of an
+// occurrence of a string of length n that continues in the next chunk.
+func safeKeep(chunk []byte, n int, final bool) int {
+	if final {
+		return 0
+	}
+	keep := n - 1
+	if keep > len(chunk) {
+		keep = len(chunk)
+	}
+	return keep
+}
+
+// scanStream reads r in chunks, passing fn each one along with whether it's
+// the last. fn returns how many trailing bytes to carry over to the start of
+// the next chunk, or -1 to stop.
+func scanStream(r io.Reader, n int, fn func(chunk []byte, final bool) int) error {
+	size := 32 << 10
+	if n*2 > size {
+		size = n * 2
+	}
+	buf := make([]byte, size)
+	carry := 0
+	for {
+		m, err := io.ReadFull(r, buf[carry:])
+		final := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !final {
+			return err
+		}
+		keep := fn(buf[:carry+m], final)
+		if keep < 0 || final {
+			return nil
+		}
+		copy(buf, buf[carry+m-keep:carry+m])
+		carry = keep
+	}
+}
@@ -0,0 +1,74 @@
+package localstorage
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+func TestReplaceInFiles(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An occurrence straddles the boundary between the chunks big.conf is
+	// read in.
+	big := strings.Repeat("x", 32<<10-3) + "OLDHOST" + strings.Repeat("y", 40<<10) + "OLDHOST"
+	files := map[string]string{
+		"app.conf":      "host = OLDHOST\nbackup = OLDHOST\n",
+		"sub/big.conf":  big,
+		"notes.txt":     "OLDHOST",
+		"sub/bin.conf":  "\x00\x01OLDHOST",
+		"sub/none.conf": "nothing to see",
+	}
+	for path, content := range files {
+		if err := lfs.Put(charmID, "/"+path, bytes.NewBufferString(content), fs.FileMode(0o600)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changed, err := lfs.ReplaceInFiles(charmID, "*.conf", []byte("OLDHOST"), []byte("new.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(changed, " ") != "app.conf sub/big.conf" {
+		t.Fatalf("expected app.conf and sub/big.conf to change, got %v", changed)
+	}
+	want := map[string]string{
+		"app.conf":      "host = new.example.com\nbackup = new.example.com\n",
+		"sub/big.conf":  strings.ReplaceAll(big, "OLDHOST", "new.example.com"),
+		"notes.txt":     "OLDHOST",
+		"sub/bin.conf":  "\x00\x01OLDHOST",
+		"sub/none.conf": "nothing to see",
+	}
+	for path, content := range want {
+		fp := filepath.Join(lfs.Path, charmID, filepath.FromSlash(path))
+		b, err := os.ReadFile(fp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != content {
+			t.Fatalf("unexpected content of %s: %.40q", path, b)
+		}
+		info, err := os.Stat(fp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0o600 {
+			t.Fatalf("expected mode of %s to be kept, got %v", path, info.Mode().Perm())
+		}
+	}
+
+	lfs.MaxFileBytes = int64(len(want["sub/big.conf"]))
+	if _, err := lfs.ReplaceInFiles(charmID, "sub/*.conf", []byte("new.example.com"), []byte("much.longer.example.com")); !errors.Is(err, storage.ErrFileTooLarge) {
+		t.Fatalf("expected ErrFileTooLarge when a file outgrows MaxFileBytes, got %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package localstorage
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// scratchDir is the directory in each Charm ID directory scratch files are
+// kept in. It's reserved, so it doesn't count towards usage or quota and is
+// left out of listings, snapshots and archives.
+const scratchDir = ".scratch"
+
+func init() {
+	reservedNames[scratchDir] = true
+}
+
+// PutScratch writes the data from r to path in the Charm ID's scratch area.
+// Scratch files aren't durable storage: they don't count against QuotaBytes and
+// can be removed all at once with ClearScratch.
+func (lfs *LocalFileStore) PutScratch(charmID, path string, r io.Reader, mode fs.FileMode) error {
+	defer lfs.op()()
+	return lfs.putHidden(lfs.scratchPath(charmID, path), r, mode)
+}
+
+// putHidden writes the data from r to fp, inside a reserved area that isn't
+// subject to the quota. Data of a DisallowedContentTypes media type is refused
+// as it is by Put.
+func (lfs *LocalFileStore) putHidden(fp string, r io.Reader, mode fs.FileMode) error {
+	if err := storage.EnsureDir(filepath.Dir(fp), mode); err != nil {
+		return err
+	}
+	if lfs.MaxFileBytes > 0 {
+		if n, ok := readerSize(r); ok && n > lfs.MaxFileBytes {
+			return storage.ErrFileTooLarge
+		}
+		r = io.LimitReader(r, lfs.MaxFileBytes+1)
+	}
+	r, err := lfs.sniff(r)
+	if err != nil {
+		return err
+	}
+	f, err := lfs.createTemp(fp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // nolint:errcheck
+	defer f.Close()           // nolint:errcheck
+	n, err := lfs.copy(f, r)
+	if err != nil {
+		return err
+	}
+	if lfs.MaxFileBytes > 0 && n > lfs.MaxFileBytes {
+		return storage.ErrFileTooLarge
+	}
+	if mode == 0 {
+		mode = defaultFileMode(fp)
+	}
+	if err := lfs.chmod(f, mode); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return commit(f.Name(), fp)
+}
+
+// GetScratch opens the file at path in the Charm ID's scratch area.
+func (lfs *LocalFileStore) GetScratch(charmID, path string) (fs.File, error) {
+	defer lfs.op()()
+	f, err := os.Open(lfs.scratchPath(charmID, path))
+	if os.IsNotExist(err) {
+		return nil, fs.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// ClearScratch removes everything in the Charm ID's scratch area.
+func (lfs *LocalFileStore) ClearScratch(charmID string) error {
+	defer lfs.op()()
+	return os.RemoveAll(filepath.Join(lfs.root(), charmID, scratchDir))
+}
+
+// scratchPath returns the location of path in the Charm ID's scratch area. The
+// path can't climb out of the scratch area.
+func (lfs *LocalFileStore) scratchPath(charmID, path string) string {
+	return filepath.Join(lfs.root(), charmID, scratchDir, filepath.Clean("/"+path))
+}
+
+// inReserved reports whether path is inside one of the reserved entries of a
+// Charm ID directory, or has an element named like a staging file.
+func inReserved(path string) bool {
+	parts := strings.Split(strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+path)), "/"), "/")
+	if reservedNames[parts[0]] {
+		return true
+	}
+	for _, part := range parts {
+		if isStaging(part) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package localstorage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestScratch(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/durable.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	before, err := lfs.Usage(charmID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/a.txt", "/work/b.txt"} {
+		if err := lfs.PutScratch(charmID, path, bytes.NewBufferString("scratch data"), fs.FileMode(0o644)); err != nil {
+			t.Fatalf("expected no error writing scratch file, %v", err)
+		}
+	}
+	after, err := lfs.Usage(charmID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after != before {
+		t.Fatalf("expected scratch files not to count towards usage, got %d, want %d", after, before)
+	}
+
+	f, err := lfs.GetScratch(charmID, "/work/b.txt")
+	if err != nil {
+		t.Fatalf("expected no error reading scratch file, %v", err)
+	}
+	b, err := io.ReadAll(f)
+	f.Close() // nolint:errcheck
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "scratch data" {
+		t.Fatalf("unexpected scratch contents %q", b)
+	}
+
+	if err := lfs.ClearScratch(charmID); err != nil {
+		t.Fatalf("expected no error clearing scratch, %v", err)
+	}
+	for _, path := range []string{"/a.txt", "/work/b.txt"} {
+		if _, err := lfs.GetScratch(charmID, path); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected %s to be removed, got %v", path, err)
+		}
+	}
+	if _, err := lfs.Stat(charmID, "/durable.txt"); err != nil {
+		t.Fatalf("expected durable file to remain, %v", err)
+	}
+}
+
+func TestPutIntoScratch(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/.scratch/a.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err == nil {
+		t.Fatal("expected Put into the scratch area to fail")
+	}
+}
@@ -0,0 +1,127 @@
+package localstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// quarantineDir is the top-level directory corrupt files are moved to when
+// QuarantineCorrupt is set.
+const quarantineDir = ".quarantine"
+
+// Scrub walks the files stored for the Charm ID, recomputing their checksums
+// and comparing them against the ones recorded when they were stored. The
+// progress func, if provided, is called for every file checked with the path
+// and whether the checksum matched. Files without a recorded checksum are
+// skipped. Reads are limited to ScrubBytesPerSec and the scrub stops when the
+// context is canceled.
+func (lfs *LocalFileStore) Scrub(ctx context.Context, charmID string, progress func(path string, ok bool)) error {
+	defer lfs.op()()
+	lim := &scrubLimiter{ctx: ctx, rate: lfs.ScrubBytesPerSec, start: time.Now()}
+	err := lfs.walk(charmID, "", func(rel string, d fs.DirEntry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		ok, err := lfs.scrubFile(charmID, rel, lim)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(rel, ok)
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// scrubFile verifies the file at path for the Charm ID and quarantines it if
+// it's corrupt and QuarantineCorrupt is set. A mismatch is checked again with
+// the path locked, so a file replaced while it was being read isn't taken for
+// a corrupt one.
+func (lfs *LocalFileStore) scrubFile(charmID, path string, lim *scrubLimiter) (bool, error) {
+	ok, err := lfs.verify(charmID, path, lim.reader)
+	if err != nil || ok {
+		return ok, err
+	}
+	defer lfs.lockPath(filepath.Join(lfs.root(), charmID, path))()
+	if ok, err = lfs.verify(charmID, path, nil); err != nil || ok {
+		return ok, err
+	}
+	if lfs.QuarantineCorrupt {
+		return false, lfs.quarantine(charmID, path)
+	}
+	return false, nil
+}
+
+// quarantine moves a corrupt file out of the Charm ID directory and drops its
+// recorded checksum. The caller must hold its path lock.
+func (lfs *LocalFileStore) quarantine(charmID, path string) error {
+	defer lfs.resetUsage(charmID)
+	qp := filepath.Join(lfs.root(), quarantineDir, charmID, path)
+	if err := storage.EnsureDir(filepath.Dir(qp), 0o700); err != nil {
+		return err
+	}
+	if err := os.Rename(filepath.Join(lfs.root(), charmID, path), qp); err != nil {
+		return err
+	}
+	return os.Remove(lfs.checksumPath(charmID, path))
+}
+
+// scrubLimiter throttles the reads of a scrub and stops them when the context
+// is canceled.
+type scrubLimiter struct {
+	ctx   context.Context
+	rate  int64
+	start time.Time
+	read  int64
+}
+
+func (sl *scrubLimiter) reader(r io.Reader) io.Reader {
+	return &scrubReader{sl, r}
+}
+
+type scrubReader struct {
+	sl *scrubLimiter
+	r  io.Reader
+}
+
+func (sr *scrubReader) Read(p []byte) (int, error) {
+	sl := sr.sl
+	if err := sl.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if sl.rate > 0 {
+		due := time.Duration(float64(sl.read) / float64(sl.rate) * float64(time.Second))
+		if d := due - time.Since(sl.start); d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-t.C:
+			case <-sl.ctx.Done():
+				t.Stop()
+				return 0, sl.ctx.Err()
+			}
+		}
+		if int64(len(p)) > sl.rate {
+			p = p[:sl.rate]
+		}
+	}
+	n, err := sr.r.Read(p)
+	sl.read += int64(n)
+	return n, err
+}
@@ -0,0 +1,80 @@
+package localstorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestScrub(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/good.txt", "/foo/bad.txt"} {
+		if err := lfs.Put(charmID, path, bytes.NewBufferString("hello world"), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bad := filepath.Join(lfs.Path, charmID, "foo", "bad.txt")
+	if err := os.WriteFile(bad, []byte("hellO world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reserved entries, such as staging files and the scratch area, aren't
+	// scrubbed even if they have a checksum that doesn't match.
+	for _, rel := range []string{".good.txt.123.tmp", scratchDir + "/tmp.txt"} {
+		fp := filepath.Join(lfs.Path, charmID, rel)
+		if err := os.MkdirAll(filepath.Dir(fp), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fp, []byte("staged"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := lfs.writeChecksum(charmID, rel, "sha256:00"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scrub := func() map[string]bool {
+		t.Helper()
+		res := map[string]bool{}
+		err := lfs.Scrub(context.Background(), charmID, func(path string, ok bool) {
+			res[path] = ok
+		})
+		if err != nil {
+			t.Fatalf("expected no error when scrubbing, %v", err)
+		}
+		return res
+	}
+
+	res := scrub()
+	if len(res) != 2 || !res["good.txt"] || res["foo/bad.txt"] {
+		t.Fatalf("expected only foo/bad.txt to be flagged, got %v", res)
+	}
+
+	lfs.QuarantineCorrupt = true
+	scrub()
+	if _, err := os.Stat(bad); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected corrupt file to be quarantined, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(lfs.Path, quarantineDir, charmID, "foo", "bad.txt")); err != nil {
+		t.Fatalf("expected corrupt file in quarantine, %v", err)
+	}
+	if res := scrub(); len(res) != 1 || !res["good.txt"] {
+		t.Fatalf("expected only good.txt to be scrubbed, got %v", res)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := lfs.Scrub(ctx, charmID, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled scrub to return context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package localstorage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// sharedDir is the top-level directory shared spaces are stored in, with a
+// directory per space laid out like a Charm ID directory.
+const sharedDir = ".shared"
+
+// GetShared is like Get for path in the shared space spaceID, on behalf of
+// the Charm ID. It fails with storage.ErrAccessDenied unless SharedAccess
+// allows the Charm ID into the space.
+func (lfs *LocalFileStore) GetShared(charmID, spaceID, path string) (fs.File, error) {
+	defer lfs.op()()
+	root, err := lfs.sharedRoot(charmID, spaceID)
+	if err != nil {
+		return nil, err
+	}
+	return lfs.Get(root, path)
+}
+
+// PutShared is like Put for path in the shared space spaceID, on behalf of
+// the Charm ID. Each space has its own quota. It fails with
+// storage.ErrAccessDenied unless SharedAccess allows the Charm ID into the
+// space.
+func (lfs *LocalFileStore) PutShared(charmID, spaceID, path string, r io.Reader, mode fs.FileMode) error {
+	defer lfs.op()()
+	root, err := lfs.sharedRoot(charmID, spaceID)
+	if err != nil {
+		return err
+	}
+	return lfs.Put(root, path, r, mode)
+}
+
+// DeleteShared is like Delete for path in the shared space spaceID, on behalf
+// of the Charm ID. It fails with storage.ErrAccessDenied unless SharedAccess
+// allows the Charm ID into the space.
+func (lfs *LocalFileStore) DeleteShared(charmID, spaceID, path string) error {
+	defer lfs.op()()
+	root, err := lfs.sharedRoot(charmID, spaceID)
+	if err != nil {
+		return err
+	}
+	return lfs.Delete(root, path)
+}
+
+// sharedRoot returns the directory of the shared space spaceID, relative to
+// the store path, if the Charm ID may access it. It takes the place of a
+// Charm ID in calls to the per Charm ID methods.
+func (lfs *LocalFileStore) sharedRoot(charmID, spaceID string) (string, error) {
+	if spaceID == "" || spaceID == "." || spaceID == ".." || strings.ContainsAny(spaceID, `/\`) {
+		return "", fmt.Errorf("invalid shared space: %q", spaceID)
+	}
+	if lfs.SharedAccess == nil || !lfs.SharedAccess(charmID, spaceID) {
+		return "", fmt.Errorf("shared space %s: %w", spaceID, storage.ErrAccessDenied)
+	}
+	return filepath.Join(sharedDir, spaceID), nil
+}
@@ -0,0 +1,80 @@
+package localstorage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+func TestSharedSpace(t *testing.T) {
+	alice := uuid.New().String()
+	bob := uuid.New().String()
+	eve := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.SharedAccess = func(charmID, spaceID string) bool {
+		return spaceID == "team" && (charmID == alice || charmID == bob)
+	}
+	read := func(charmID string) string {
+		t.Helper()
+		f, err := lfs.GetShared(charmID, "team", "/plan.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close() // nolint:errcheck
+		data, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	if err := lfs.PutShared(alice, "team", "/plan.txt", bytes.NewBufferString("v1"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(bob); got != "v1" {
+		t.Fatalf("expected bob to read alice's write, got %q", got)
+	}
+	if err := lfs.PutShared(bob, "team", "/plan.txt", bytes.NewBufferString("v2"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(alice); got != "v2" {
+		t.Fatalf("expected alice to read bob's write, got %q", got)
+	}
+	if _, err := lfs.Stat(alice, "/plan.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected the shared file not to be in alice's own data, got %v", err)
+	}
+
+	if _, err := lfs.GetShared(eve, "team", "/plan.txt"); !errors.Is(err, storage.ErrAccessDenied) {
+		t.Fatalf("expected storage.ErrAccessDenied reading, got %v", err)
+	}
+	if err := lfs.PutShared(eve, "team", "/plan.txt", bytes.NewBufferString("x"), fs.FileMode(0o644)); !errors.Is(err, storage.ErrAccessDenied) {
+		t.Fatalf("expected storage.ErrAccessDenied writing, got %v", err)
+	}
+	if err := lfs.DeleteShared(eve, "team", "/plan.txt"); !errors.Is(err, storage.ErrAccessDenied) {
+		t.Fatalf("expected storage.ErrAccessDenied deleting, got %v", err)
+	}
+	if _, err := lfs.GetShared(alice, "../"+bob, "/plan.txt"); err == nil {
+		t.Fatal("expected an error for an invalid space")
+	}
+
+	ids, err := lfs.ListCharmIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if id == sharedDir {
+			t.Fatal("expected shared spaces not to be listed as Charm IDs")
+		}
+	}
+	if err := lfs.DeleteShared(bob, "team", "/plan.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,223 @@
+package localstorage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	charm "github.com/charmbracelet/charm/proto"
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// snapshotsDir is the top-level directory snapshot manifests are kept in.
+const snapshotsDir = ".snapshots"
+
+// Manifest returns the FileInfo of every file and directory stored for the
+// Charm ID. Names are paths relative to the Charm ID directory and files
+// include their checksum.
+func (lfs *LocalFileStore) Manifest(charmID string) ([]*charm.FileInfo, error) {
+	defer lfs.op()()
+	fis := make([]*charm.FileInfo, 0)
+	err := lfs.walk(charmID, "", func(rel string, d fs.DirEntry) error {
+		fin, err := lfs.manifestEntry(charmID, rel, d)
+		if err != nil {
+			return err
+		}
+		fis = append(fis, fin)
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return fis, nil
+	}
+	return fis, err
+}
+
+// manifestEntry returns the manifest entry of the file or directory at rel.
+func (lfs *LocalFileStore) manifestEntry(charmID, rel string, d fs.DirEntry) (*charm.FileInfo, error) {
+	fi, err := d.Info()
+	if err != nil {
+		return nil, err
+	}
+	fin, err := lfs.fileInfo(charmID, rel, fi)
+	if err != nil {
+		return nil, err
+	}
+	fin.Name = rel
+	if !fin.IsDir {
+		if fin.Checksum, err = lfs.lockedChecksum(charmID, rel); err != nil {
+			return nil, err
+		}
+	}
+	return &fin, nil
+}
+
+// errPageFull stops the walk of ManifestPage once it has a full page.
+var errPageFull = errors.New("page full")
+
+// ManifestPage returns a page of up to limit entries of the Manifest for the
+// Charm ID, starting after cursor, along with the cursor of the next page.
+// Start with an empty cursor; an empty next cursor means there are no more
+// pages. Entries are in the same order as Manifest, so concatenating the pages
+// gives the whole manifest, although entries changed while paging may be
+// missed. A limit of zero or less returns everything left. Cursors are opaque
+// and storage.ErrInvalidCursor is returned for one that wasn't returned by
+// ManifestPage.
+func (lfs *LocalFileStore) ManifestPage(charmID string, cursor string, limit int) ([]*charm.FileInfo, string, error) {
+	defer lfs.op()()
+	var after []string
+	if cursor != "" {
+		b, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || len(b) == 0 {
+			return nil, "", storage.ErrInvalidCursor
+		}
+		after = strings.Split(string(b), "/")
+	}
+	fis := make([]*charm.FileInfo, 0)
+	next := ""
+	err := lfs.walk(charmID, "", func(rel string, d fs.DirEntry) error {
+		if after != nil {
+			parts := strings.Split(rel, "/")
+			switch c := comparePaths(parts, after); {
+			case c < 0 && d.IsDir() && !isPrefix(parts, after):
+				return filepath.SkipDir
+			case c <= 0:
+				return nil
+			}
+		}
+		if limit > 0 && len(fis) == limit {
+			next = base64.RawURLEncoding.EncodeToString([]byte(fis[len(fis)-1].Name))
+			return errPageFull
+		}
+		fin, err := lfs.manifestEntry(charmID, rel, d)
+		if err != nil {
+			return err
+		}
+		fis = append(fis, fin)
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) || err == errPageFull {
+		err = nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return fis, next, nil
+}
+
+// comparePaths compares two paths split into components in the order they're
+// walked in, where a directory comes right before its contents.
+func comparePaths(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := strings.Compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+// isPrefix reports whether the components of dir are a prefix of those of
+// path.
+func isPrefix(dir, path []string) bool {
+	if len(dir) > len(path) {
+		return false
+	}
+	for i := range dir {
+		if dir[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Snapshot records the current Manifest for the Charm ID and returns the ID of
+// the snapshot. Snapshots only hold the manifest, not file contents, and are
+// meant to be compared with Diff.
+func (lfs *LocalFileStore) Snapshot(charmID string) (string, error) {
+	defer lfs.op()()
+	fis, err := lfs.Manifest(charmID)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(lfs.root(), snapshotsDir, charmID)
+	if err := storage.EnsureDir(dir, 0o700); err != nil {
+		return "", err
+	}
+	id := time.Now().UTC().Format("20060102T150405.000000000Z")
+	b, err := json.Marshal(fis)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, id+".json"), b, 0o600); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Diff returns the changes between two snapshots of the Charm ID. An empty
+// snapshot ID refers to the current state of the store. Changes are sorted by
+// path.
+func (lfs *LocalFileStore) Diff(charmID, fromSnapshotID, toSnapshotID string) ([]storage.Change, error) {
+	defer lfs.op()()
+	from, err := lfs.snapshotManifest(charmID, fromSnapshotID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := lfs.snapshotManifest(charmID, toSnapshotID)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]storage.Change, 0)
+	for path, fi := range to {
+		old, ok := from[path]
+		switch {
+		case !ok:
+			changes = append(changes, storage.Change{Type: storage.ChangeAdded, Path: path, IsDir: fi.IsDir, Checksum: fi.Checksum})
+		case modified(old, fi):
+			changes = append(changes, storage.Change{Type: storage.ChangeModified, Path: path, IsDir: fi.IsDir, Checksum: fi.Checksum, OldChecksum: old.Checksum})
+		}
+	}
+	for path, fi := range from {
+		if _, ok := to[path]; !ok {
+			changes = append(changes, storage.Change{Type: storage.ChangeDeleted, Path: path, IsDir: fi.IsDir, OldChecksum: fi.Checksum})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+func modified(old, fi *charm.FileInfo) bool {
+	if old.IsDir != fi.IsDir || old.Mode != fi.Mode {
+		return true
+	}
+	return !fi.IsDir && old.Checksum != fi.Checksum
+}
+
+// snapshotManifest returns the manifest of a snapshot keyed by path.
+func (lfs *LocalFileStore) snapshotManifest(charmID, snapshotID string) (map[string]*charm.FileInfo, error) {
+	var fis []*charm.FileInfo
+	if snapshotID == "" {
+		var err error
+		if fis, err = lfs.Manifest(charmID); err != nil {
+			return nil, err
+		}
+	} else {
+		b, err := os.ReadFile(filepath.Join(lfs.root(), snapshotsDir, charmID, filepath.Base(snapshotID)+".json"))
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &fis); err != nil {
+			return nil, err
+		}
+	}
+	m := make(map[string]*charm.FileInfo, len(fis))
+	for _, fi := range fis {
+		m[fi.Name] = fi
+	}
+	return m, nil
+}
@@ -0,0 +1,124 @@
+package localstorage
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+func TestDiff(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(path, content string) {
+		t.Helper()
+		if err := lfs.Put(charmID, path, bytes.NewBufferString(content), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("/same.txt", "same")
+	put("/changed.txt", "before")
+	put("/foo/deleted.txt", "deleted")
+
+	id, err := lfs.Snapshot(charmID)
+	if err != nil {
+		t.Fatalf("expected no error when taking a snapshot, %v", err)
+	}
+
+	put("/changed.txt", "after")
+	put("/foo/added.txt", "added")
+	if err := lfs.Delete(charmID, "/foo/deleted.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := lfs.Diff(charmID, id, "")
+	if err != nil {
+		t.Fatalf("expected no error when diffing, %v", err)
+	}
+	want := []struct {
+		typ  storage.ChangeType
+		path string
+	}{
+		{storage.ChangeModified, "changed.txt"},
+		{storage.ChangeAdded, "foo/added.txt"},
+		{storage.ChangeDeleted, "foo/deleted.txt"},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("expected %d changes, got %+v", len(want), changes)
+	}
+	for i, w := range want {
+		c := changes[i]
+		if c.Type != w.typ || c.Path != w.path {
+			t.Fatalf("expected change %d to be %s %s, got %s %s", i, w.typ, w.path, c.Type, c.Path)
+		}
+	}
+	sum, err := lfs.Checksum(charmID, "/changed.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes[0].Checksum != sum || changes[0].OldChecksum == "" || changes[0].OldChecksum == sum {
+		t.Fatalf("expected modified change to carry old and new checksums, got %+v", changes[0])
+	}
+
+	if changes, err := lfs.Diff(charmID, id, id); err != nil || len(changes) != 0 {
+		t.Fatalf("expected no changes between a snapshot and itself, got %+v, %v", changes, err)
+	}
+}
+
+func TestManifestPage(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a-b sorts between a and a/b as a string, but is walked after a/b.
+	for _, path := range []string{"a/b/c.txt", "a/d.txt", "a-b", "b.txt", "c/d/e/f.txt", "z.txt"} {
+		if err := lfs.Put(charmID, "/"+path, bytes.NewBufferString(path), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	all, err := lfs.Manifest(charmID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, limit := range []int{1, 2, 3, len(all), len(all) + 1} {
+		var got []string
+		cursor := ""
+		for pages := 0; ; pages++ {
+			if pages > len(all) {
+				t.Fatalf("limit %d: too many pages", limit)
+			}
+			fis, next, err := lfs.ManifestPage(charmID, cursor, limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(fis) > limit {
+				t.Fatalf("limit %d: got a page of %d", limit, len(fis))
+			}
+			for _, fi := range fis {
+				got = append(got, fi.Name)
+			}
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		if len(got) != len(all) {
+			t.Fatalf("limit %d: expected %d entries, got %v", limit, len(all), got)
+		}
+		for i, fi := range all {
+			if got[i] != fi.Name {
+				t.Fatalf("limit %d: expected entry %d to be %s, got %s", limit, i, fi.Name, got[i])
+			}
+		}
+	}
+	if _, _, err := lfs.ManifestPage(charmID, "not a cursor!", 1); !errors.Is(err, storage.ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor, got %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package localstorage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// sniffLen is the number of bytes http.DetectContentType considers.
+const sniffLen = 512
+
+// sniff returns a reader with the same data as r, having checked the media type
+// sniffed from its first bytes isn't one of DisallowedContentTypes.
+func (lfs *LocalFileStore) sniff(r io.Reader) (io.Reader, error) {
+	if len(lfs.DisallowedContentTypes) == 0 {
+		return r, nil
+	}
+	br := bufio.NewReaderSize(r, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if err := lfs.checkContentType(head); err != nil {
+		return nil, err
+	}
+	return br, nil
+}
+
+// sniffFile checks the media type sniffed from the first bytes of the file at
+// fp, such as one staged to be committed, isn't one of DisallowedContentTypes.
+func (lfs *LocalFileStore) sniffFile(fp string) error {
+	if len(lfs.DisallowedContentTypes) == 0 {
+		return nil
+	}
+	f, err := os.Open(fp)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint:errcheck
+	_, err = lfs.sniff(f)
+	return err
+}
+
+// sniffPatch checks the media type the file at fp would be sniffed as once the
+// n bytes of data are written into it at offset isn't one of
+// DisallowedContentTypes. The file is left as is.
+func (lfs *LocalFileStore) sniffPatch(fp string, offset int64, data io.ReaderAt, n int64) error {
+	if len(lfs.DisallowedContentTypes) == 0 {
+		return nil
+	}
+	head := make([]byte, sniffLen)
+	var size int64
+	f, err := os.Open(fp)
+	switch {
+	case err == nil:
+		m, err := io.ReadFull(f, head)
+		f.Close() // nolint:errcheck
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		size = int64(m)
+	case !os.IsNotExist(err):
+		return err
+	}
+	// Any gap between the end of the file and offset reads as zeros.
+	end := offset + n
+	if end > sniffLen {
+		end = sniffLen
+	}
+	if offset < end {
+		if _, err := data.ReadAt(head[offset:end], 0); err != nil && err != io.EOF {
+			return err
+		}
+	}
+	if end > size {
+		size = end
+	}
+	return lfs.checkContentType(head[:size])
+}
+
+// checkContentType fails with storage.ErrDisallowedContentType if head, the
+// first bytes of a file, is sniffed as one of DisallowedContentTypes.
+func (lfs *LocalFileStore) checkContentType(head []byte) error {
+	mt, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return err
+	}
+	if matchMediaType(lfs.DisallowedContentTypes, mt) {
+		return fmt.Errorf("%s: %w", mt, storage.ErrDisallowedContentType)
+	}
+	return nil
+}
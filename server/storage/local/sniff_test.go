@@ -0,0 +1,140 @@
+package localstorage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+func TestDisallowedContentTypes(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.DisallowedContentTypes = []string{"image/*", "application/zip"}
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 1024)...)
+	for path, data := range map[string][]byte{
+		"/dir/image.png": png,
+		"/dir/data.zip":  []byte("PK\x03\x04rest of the archive"),
+	} {
+		err := lfs.Put(charmID, path, bytes.NewReader(data), fs.FileMode(0o644))
+		if !errors.Is(err, storage.ErrDisallowedContentType) {
+			t.Fatalf("expected ErrDisallowedContentType for %s, got %v", path, err)
+		}
+		if _, err := lfs.Stat(charmID, path); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected %s not to be stored, got %v", path, err)
+		}
+	}
+	des, err := os.ReadDir(filepath.Join(lfs.Path, charmID, "dir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(des) != 0 {
+		t.Fatalf("expected no files left behind, got %d", len(des))
+	}
+
+	for path, data := range map[string]string{
+		"/dir/notes.txt": "just some text",
+		"/dir/page.html": "<!DOCTYPE html><html></html>",
+		"/dir/empty.txt": "",
+	} {
+		if err := lfs.Put(charmID, path, bytes.NewBufferString(data), fs.FileMode(0o644)); err != nil {
+			t.Fatalf("expected %s to be allowed, got %v", path, err)
+		}
+		info, err := lfs.Stat(charmID, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != int64(len(data)) {
+			t.Fatalf("expected %s to be stored whole, got %d bytes", path, info.Size())
+		}
+	}
+}
+
+func TestDisallowedContentTypesOtherWrites(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.DisallowedContentTypes = []string{"image/png"}
+	png := "\x89PNG\r\n\x1a\n"
+	if err := lfs.Put(charmID, "/notes.txt", bytes.NewBufferString("plain text notes"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/header.bin", bytes.NewBufferString(png), fs.FileMode(0o644)); err == nil {
+		t.Fatal("expected the header on its own to be rejected")
+	}
+	assertContent := func(path, want string) {
+		t.Helper()
+		f, err := lfs.Get(charmID, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close() // nolint:errcheck
+		b, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Fatalf("expected %s to be left as %q, got %q", path, want, b)
+		}
+	}
+
+	// Patching the start of a file into a disallowed type.
+	if err := lfs.PutAt(charmID, "/notes.txt", 0, bytes.NewBufferString(png)); !errors.Is(err, storage.ErrDisallowedContentType) {
+		t.Fatalf("expected ErrDisallowedContentType from PutAt, got %v", err)
+	}
+	assertContent("/notes.txt", "plain text notes")
+	if _, err := lfs.ResumablePut(charmID, "/new.png", bytes.NewBufferString(png+"rest"), 0); !errors.Is(err, storage.ErrDisallowedContentType) {
+		t.Fatalf("expected ErrDisallowedContentType from ResumablePut, got %v", err)
+	}
+	if err := lfs.PutAt(charmID, "/notes.txt", 6, bytes.NewBufferString("TEXT")); err != nil {
+		t.Fatalf("expected an allowed patch to be written, got %v", err)
+	}
+	assertContent("/notes.txt", "plain TEXT notes")
+
+	// Saving disallowed drafts and scratch files, or publishing a draft saved
+	// before its type was disallowed.
+	if err := lfs.PutDraft(charmID, "/notes.txt", bytes.NewBufferString(png+"rest"), fs.FileMode(0o644)); !errors.Is(err, storage.ErrDisallowedContentType) {
+		t.Fatalf("expected ErrDisallowedContentType from PutDraft, got %v", err)
+	}
+	if err := lfs.PutScratch(charmID, "/tmp.png", bytes.NewBufferString(png+"rest"), fs.FileMode(0o644)); !errors.Is(err, storage.ErrDisallowedContentType) {
+		t.Fatalf("expected ErrDisallowedContentType from PutScratch, got %v", err)
+	}
+	lfs.DisallowedContentTypes = nil
+	if err := lfs.PutDraft(charmID, "/notes.txt", bytes.NewBufferString(png+"rest"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	lfs.DisallowedContentTypes = []string{"image/png"}
+	if err := lfs.PublishDraft(charmID, "/notes.txt"); !errors.Is(err, storage.ErrDisallowedContentType) {
+		t.Fatalf("expected ErrDisallowedContentType from PublishDraft, got %v", err)
+	}
+	assertContent("/notes.txt", "plain TEXT notes")
+
+	// Committing a transaction with a disallowed file applies none of it.
+	tx, err := lfs.Transaction(charmID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Put("/other.txt", bytes.NewBufferString("fine"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Put("/image.png", bytes.NewBufferString(png+"rest"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); !errors.Is(err, storage.ErrDisallowedContentType) {
+		t.Fatalf("expected ErrDisallowedContentType from CommitTx, got %v", err)
+	}
+	if _, err := lfs.Stat(charmID, "/other.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected the transaction not to be applied, got %v", err)
+	}
+}
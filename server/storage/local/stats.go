@@ -0,0 +1,77 @@
+package localstorage
+
+import (
+	"errors"
+	"io/fs"
+	"time"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// defaultStatsTTL is how long StoreStats reuses its totals when StatsTTL
+// isn't set.
+const defaultStatsTTL = time.Minute
+
+var errDiskSpaceUnsupported = errors.New("free space is unsupported on this platform")
+
+// StoreStats returns the bytes and files stored across every Charm ID, the
+// number of Charm IDs with data, and the free and total bytes of the volume
+// holding the store. Counting walks the whole store, so the totals are reused
+// for StatsTTL and may not reflect the latest writes; the volume figures are
+// always current.
+func (lfs *LocalFileStore) StoreStats() (storage.StoreStats, error) {
+	defer lfs.op()()
+	lfs.statsMu.Lock()
+	defer lfs.statsMu.Unlock()
+	ttl := lfs.StatsTTL
+	if ttl <= 0 {
+		ttl = defaultStatsTTL
+	}
+	if lfs.statsAt.IsZero() || time.Since(lfs.statsAt) >= ttl {
+		st, err := lfs.countStats()
+		if err != nil {
+			return storage.StoreStats{}, err
+		}
+		lfs.stats = st
+		lfs.statsAt = time.Now()
+	}
+	st := lfs.stats
+	free, total, err := diskSpace(lfs.root())
+	if err != nil && !errors.Is(err, errDiskSpaceUnsupported) {
+		return storage.StoreStats{}, err
+	}
+	st.VolumeFree, st.VolumeTotal = free, total
+	return st, nil
+}
+
+// countStats walks every Charm ID directory to total the files stored.
+func (lfs *LocalFileStore) countStats() (storage.StoreStats, error) {
+	var st storage.StoreStats
+	ids, err := lfs.ListCharmIDs()
+	if err != nil {
+		return st, err
+	}
+	for _, id := range ids {
+		err := lfs.walk(id, "", func(rel string, d fs.DirEntry) error {
+			if d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			st.Files++
+			st.Bytes += info.Size()
+			return nil
+		})
+		if errors.Is(err, fs.ErrNotExist) {
+			// Deleted since it was listed.
+			continue
+		}
+		if err != nil {
+			return st, err
+		}
+		st.Users++
+	}
+	return st, nil
+}
@@ -0,0 +1,56 @@
+package localstorage
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestStoreStats(t *testing.T) {
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.StatsTTL = time.Hour
+	for i := 1; i <= 3; i++ {
+		charmID := uuid.New().String()
+		for j := 0; j < i; j++ {
+			if err := lfs.Put(charmID, fmt.Sprintf("/dir/%d.txt", j), bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	st, err := lfs.StoreStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Users != 3 || st.Files != 6 || st.Bytes != 30 {
+		t.Fatalf("expected 3 users with 6 files of 30 bytes, got %+v", st)
+	}
+	switch runtime.GOOS {
+	case "linux", "darwin":
+		if st.VolumeTotal <= 0 || st.VolumeFree <= 0 || st.VolumeFree > st.VolumeTotal {
+			t.Fatalf("expected volume stats to be populated, got %+v", st)
+		}
+	}
+
+	if err := lfs.Put(uuid.New().String(), "/new.txt", bytes.NewBufferString("hi"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := lfs.StoreStats(); err != nil || st.Users != 3 {
+		t.Fatalf("expected the totals to be reused within StatsTTL, got %+v, %v", st, err)
+	}
+	lfs.StatsTTL = time.Nanosecond
+	st, err = lfs.StoreStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Users != 4 || st.Files != 7 || st.Bytes != 32 {
+		t.Fatalf("expected 4 users with 7 files of 32 bytes once StatsTTL passed, got %+v", st)
+	}
+}
@@ -1,23 +1,242 @@
 package localstorage
 
 import (
-	"bytes"
-	"encoding/json"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	charmfs "github.com/charmbracelet/charm/fs"
 	charm "github.com/charmbracelet/charm/proto"
 	"github.com/charmbracelet/charm/server/storage"
 )
 
+// reservedRoots are top-level entries in the store path that don't belong to
+// a Charm ID.
+var reservedRoots = map[string]bool{
+	".health":      true,
+	blobsDir:       true,
+	checksumsDir:   true,
+	quarantineDir:  true,
+	snapshotsDir:   true,
+	txDir:          true,
+	accessDir:      true,
+	generationsDir: true,
+	auditDir:       true,
+	sharedDir:      true,
+	aclDir:         true,
+}
+
+// reservedNames are entries in Charm ID directories used internally by the
+// store. They're hidden from listings unless ShowReserved is set.
+var reservedNames = map[string]bool{}
+
+// isReserved reports whether a directory entry is used internally by the store
+// rather than stored by a client. Staging files are always reserved.
+func isReserved(name string) bool {
+	return reservedNames[name] || isStaging(name)
+}
+
+// isStaging reports whether name is that of a staging file, which are named
+// .<name>.<random>.tmp. Clients can't store files named like one, as they'd
+// be hidden from listings.
+func isStaging(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".tmp")
+}
+
+// specialModes are the file types Put refuses to replace.
+const specialModes = fs.ModeNamedPipe | fs.ModeSocket | fs.ModeDevice | fs.ModeCharDevice | fs.ModeIrregular
+
+// rename is used to move staged files into place. It's a variable so tests can
+// simulate a staging directory on a different volume.
+var rename = os.Rename
+
+// chmodFile sets the mode of staged files. It's a variable so tests can
+// simulate a filesystem that doesn't support modes.
+var chmodFile = (*os.File).Chmod
+
 // LocalFileStore is a FileStore implementation that stores files locally in a
 // folder.
 type LocalFileStore struct {
+	// writeRate holds the bits of the float64 WriteThroughput reports. It's
+	// first so it's 64-bit aligned for atomic access on 32-bit platforms.
+	writeRate uint64
+
 	Path string
+	// TempDir is the directory new files are staged in before being moved into
+	// place. When empty, files are staged in their destination directory.
+	TempDir string
+	// MaxFileBytes is the maximum size of a single file. Zero means no limit.
+	MaxFileBytes int64
+	// ScrubBytesPerSec limits how fast Scrub reads files. Zero means no limit.
+	ScrubBytesPerSec int64
+	// QuarantineCorrupt makes Scrub move files that fail verification out of
+	// the Charm ID directory.
+	QuarantineCorrupt bool
+	// VerifyOnGet makes Get check files against the checksum recorded when
+	// they were stored, failing with storage.ErrCorrupt on a mismatch. Files
+	// without a recorded checksum are returned unverified.
+	VerifyOnGet bool
+	// ChecksumAlgo is the algorithm checksums of new files are computed with.
+	// It defaults to SHA256.
+	ChecksumAlgo ChecksumAlgo
+	// StrongETags makes Stat and listings use the content checksum of files
+	// as their ETag rather than a weak validator derived from the size and
+	// modification time.
+	StrongETags bool
+	// ShowReserved includes entries used internally by the store in directory
+	// listings. It's meant for administrative access only.
+	ShowReserved bool
+	// OpTimeout limits how long Stat, Get, Put and Delete may take before
+	// failing with storage.ErrTimeout. Zero means no limit.
+	OpTimeout time.Duration
+	// IndexFile is the name of a file, such as index.html, that Get returns
+	// instead of the listing when a directory containing it is requested.
+	IndexFile string
+	// QuotaBytes is the maximum number of bytes each Charm ID may store, as
+	// stored on disk, so after compression with Compress. Zero means no limit.
+	QuotaBytes int64
+	// ReadTransform, if set, transforms the contents of files returned by Get
+	// as they're read, such as to redact or watermark them. Directory
+	// listings aren't transformed. The transformed contents aren't what the
+	// recorded checksum, ETag and size describe, so clients checking those
+	// against what they read need to account for the transform.
+	ReadTransform func(io.Reader) io.Reader
+	// Compress stores the files written by Put gzip-compressed, behind a
+	// header recording their logical size. Get, Stat and listings report the
+	// decompressed contents and size, with listings also reporting the size
+	// on disk as StoredSize. Checksums cover the decompressed contents. Files
+	// stored compressed can't be patched with PutAt or ResumablePut, and
+	// aren't deduplicated.
+	Compress bool
+	// CopyBufferSize is the size of the buffer file data is copied with by Get
+	// and Put. Zero uses the io.Copy default.
+	CopyBufferSize int
+	// Dedup stores files with the same content and mode once, in the blobs
+	// directory, with each file being a hard link to its blob. Deduplicated
+	// files share their modification time. Files with extended attributes
+	// aren't deduplicated. Use GarbageCollectBlobs to free orphaned blobs.
+	Dedup bool
+	// IdempotencyTTL is how long writes made with a storage.PutOptions
+	// IdempotencyKey are remembered. It defaults to 10 minutes.
+	IdempotencyTTL time.Duration
+	// TxTTL is how long the writes staged for a transaction are kept before
+	// they're swept when a transaction next starts, so abandoned
+	// transactions don't leave them behind. Committing a transaction that's
+	// open for longer fails. It defaults to a day.
+	TxTTL time.Duration
+	// TrackAccess records when each Charm ID's data was last read or written,
+	// for EvictLRU.
+	TrackAccess bool
+	// ComputeDirSizes makes directory listings report the total size of the
+	// files in each directory rather than the size of the directory entry.
+	// Each directory in a listing is walked to compute it.
+	ComputeDirSizes bool
+	// ListingFormat is the encoding of the directory listings returned by
+	// Get. It defaults to ListingJSON.
+	ListingFormat ListingFormat
+	// MaxListEntries is the maximum number of entries a listing may return
+	// before failing with storage.ErrTooManyEntries. Zero means no limit.
+	MaxListEntries int
+	// AuditLog records every Get, Put and Delete in a per Charm ID log, read
+	// with UserActivity.
+	AuditLog bool
+	// ImportHosts are the hosts PutFromURL may download from. PutFromURL
+	// fails for every URL when it's empty.
+	ImportHosts []string
+	// ImportMaxBytes is the maximum size of a download by PutFromURL. Zero
+	// means no limit other than MaxFileBytes.
+	ImportMaxBytes int64
+	// ImportContentTypes are the media types, such as "image/png" or
+	// "image/*", of the downloads PutFromURL stores. Empty allows any.
+	ImportContentTypes []string
+	// ImportTimeout limits how long a download by PutFromURL may take. It
+	// defaults to a minute.
+	ImportTimeout time.Duration
+	// SharedAccess reports whether the Charm ID may read and write the shared
+	// space spaceID through GetShared, PutShared and DeleteShared. When it's
+	// nil, no Charm ID may.
+	SharedAccess func(charmID, spaceID string) bool
+	// IgnoreChmodErrors makes Put keep a file whose data was written even if
+	// its mode can't be set, as on some FUSE and Windows filesystems. The
+	// file then has whatever mode the filesystem gives it.
+	IgnoreChmodErrors bool
+	// AllowCaseClobber lets Put replace a file whose name only differs in case
+	// on case-insensitive volumes instead of failing with
+	// storage.ErrCaseConflict.
+	AllowCaseClobber bool
+	// StatsTTL is how long StoreStats reuses the totals it counted before
+	// walking the store again. It defaults to a minute.
+	StatsTTL time.Duration
+	// DisallowedContentTypes are the media types, such as
+	// "application/x-executable" or "video/*", Put and the other writes
+	// refuse to store, failing with storage.ErrDisallowedContentType. The
+	// type is sniffed with http.DetectContentType from the first 512 bytes
+	// the file would have once written, before it's committed.
+	DisallowedContentTypes []string
+
+	caseMu     sync.Mutex
+	caseProbed bool
+	caseFold   bool
+	caseErr    error
+
+	quotaMu      sync.Mutex
+	reservations map[string]reservation
+
+	usageMu sync.Mutex
+	usages  map[string]*usage
+
+	txMu sync.Mutex
+
+	locksMu sync.Mutex
+	locks   map[string]*pathLock
+
+	genMu sync.Mutex
+
+	idemMu sync.Mutex
+	idem   map[string]*idempotentPut
+
+	auditMu sync.Mutex
+
+	aclMu sync.Mutex
+
+	// opMu guards the count of calls in flight, which Relocate and
+	// RenameCharmID wait to drain through exclusive, held by exclMu, and of
+	// those that timed out but are still running. moving is closed once
+	// they're done. pathMu guards Path, read through root.
+	opMu     sync.Mutex
+	inflight int
+	stuck    int
+	drained  chan struct{}
+	moving   chan struct{}
+	exclMu   sync.Mutex
+	pathMu   sync.RWMutex
+
+	statsMu sync.Mutex
+	stats   storage.StoreStats
+	statsAt time.Time
+}
+
+func init() {
+	storage.Register("file", func(u *url.URL) (storage.FileStore, error) {
+		path := u.Path
+		if u.Opaque != "" {
+			path = u.Opaque
+		} else if u.Host != "" {
+			path = filepath.Join(u.Host, u.Path)
+		}
+		return NewLocalFileStore(path)
+	})
 }
 
 // NewLocalFileStore creates a FileStore locally in the provided path. Files
@@ -28,12 +247,20 @@ func NewLocalFileStore(path string) (*LocalFileStore, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &LocalFileStore{path}, nil
+	return &LocalFileStore{Path: path}, nil
 }
 
 // Stat returns the FileInfo for the given Charm ID and path.
 func (lfs *LocalFileStore) Stat(charmID, path string) (fs.FileInfo, error) {
-	fp := filepath.Join(lfs.Path, charmID, path)
+	v, err := lfs.withTimeout(func(ctx context.Context) (interface{}, error) {
+		return lfs.stat(charmID, path)
+	}, nil)
+	info, _ := v.(fs.FileInfo)
+	return info, err
+}
+
+func (lfs *LocalFileStore) stat(charmID, path string) (fs.FileInfo, error) {
+	fp := filepath.Join(lfs.root(), charmID, path)
 	i, err := os.Stat(fp)
 	if os.IsNotExist(err) {
 		return nil, fs.ErrNotExist
@@ -41,34 +268,121 @@ func (lfs *LocalFileStore) Stat(charmID, path string) (fs.FileInfo, error) {
 	if err != nil {
 		return nil, err
 	}
-	in := &charmfs.FileInfo{
-		FileInfo: charm.FileInfo{
-			Name:    i.Name(),
-			IsDir:   i.IsDir(),
-			Size:    i.Size(),
-			ModTime: i.ModTime(),
-			Mode:    i.Mode(),
-		},
+	fin, err := lfs.fileInfo(charmID, path, i)
+	if err != nil {
+		return nil, err
 	}
+	in := &charmfs.FileInfo{FileInfo: fin}
 	// Get the actual size of the files in a directory
 	if i.IsDir() {
-		in.FileInfo.Size = 0
-		if err = filepath.Walk(fp, func(path string, info fs.FileInfo, err error) error {
-			if info.IsDir() {
-				return nil
-			}
-			in.FileInfo.Size += info.Size()
-			return nil
-		}); err != nil {
+		if in.FileInfo.Size, err = lfs.dirSize(charmID, path); err != nil {
 			return nil, err
 		}
 	}
+	in.FileInfo.ETag = lfs.etag(charmID, path, in.FileInfo)
 	return in, nil
 }
 
-// Get returns an fs.File for the given Charm ID and path.
+// StatBatch returns the FileInfo for each of the paths for the Charm ID. The
+// results and errors are in the same order as paths; for each path either the
+// FileInfo or the error is nil.
+func (lfs *LocalFileStore) StatBatch(charmID string, paths []string) ([]*charm.FileInfo, []error) {
+	defer lfs.op()()
+	fis := make([]*charm.FileInfo, len(paths))
+	errs := make([]error, len(paths))
+	for i, path := range paths {
+		info, err := lfs.Stat(charmID, path)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		fis[i] = &info.(*charmfs.FileInfo).FileInfo
+	}
+	return fis, errs
+}
+
+// IsDir reports whether path is a directory for the Charm ID.
+func (lfs *LocalFileStore) IsDir(charmID, path string) (bool, error) {
+	defer lfs.op()()
+	info, err := os.Stat(filepath.Join(lfs.root(), charmID, path))
+	if os.IsNotExist(err) {
+		return false, fs.ErrNotExist
+	}
+	if err != nil {
+		return false, err
+	}
+	return info.IsDir(), nil
+}
+
+// requireDir fails with storage.ErrNotDirectory if path isn't a directory for
+// the Charm ID.
+func (lfs *LocalFileStore) requireDir(charmID, path string) error {
+	isDir, err := lfs.IsDir(charmID, path)
+	if err != nil {
+		return err
+	}
+	if !isDir {
+		return storage.ErrNotDirectory
+	}
+	return nil
+}
+
+// listing returns the listing of the directory at path for the Charm ID,
+// described by info, with an ETag derived from its entries unless it's
+// streamed.
+func (lfs *LocalFileStore) listing(charmID, path string, info fs.FileInfo) (*charmfs.DirFile, error) {
+	if lfs.ListingFormat == ListingNDJSON {
+		return lfs.streamListing(charmID, path, info)
+	}
+	fis, err := lfs.list(charmID, path)
+	if err != nil {
+		return nil, err
+	}
+	dir := charm.FileInfo{
+		Name:    info.Name(),
+		IsDir:   true,
+		Size:    0,
+		ModTime: info.ModTime(),
+		Mode:    info.Mode(),
+		Files:   fis,
+	}
+	buf, ct, err := lfs.ListingFormat.encode(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &charmfs.DirFile{
+		Buffer:      buf,
+		FileInfo:    info,
+		ContentType: ct,
+		ETag:        dirETag(fis),
+	}, nil
+}
+
+// Get returns an fs.File for the given Charm ID and path. For a file, that's
+// its contents. For a directory, it's IndexFile if that's set and present in
+// the directory, or else the directory listing as a *charmfs.DirFile. Use
+// GetFile or the listing methods to fail on the other kind of path.
 func (lfs *LocalFileStore) Get(charmID string, path string) (fs.File, error) {
-	fp := filepath.Join(lfs.Path, charmID, path)
+	v, err := lfs.withTimeout(func(ctx context.Context) (interface{}, error) {
+		return lfs.get(charmID, path)
+	}, func(v interface{}) {
+		v.(fs.File).Close() // nolint:errcheck
+	})
+	f, _ := v.(fs.File)
+	if lfs.AuditLog {
+		var size int64
+		if err == nil {
+			if info, serr := f.Stat(); serr == nil && !info.IsDir() {
+				size = info.Size()
+			}
+		}
+		lfs.audit(charmID, opGet, path, size, err)
+	}
+	return f, err
+}
+
+func (lfs *LocalFileStore) get(charmID string, path string) (fs.File, error) {
+	fp := filepath.Join(lfs.root(), charmID, path)
 	info, err := os.Stat(fp)
 	if os.IsNotExist(err) {
 		return nil, fs.ErrNotExist
@@ -76,85 +390,739 @@ func (lfs *LocalFileStore) Get(charmID string, path string) (fs.File, error) {
 	if err != nil {
 		return nil, err
 	}
-	f, err := os.Open(fp)
-	if err != nil {
-		return nil, err
+	lfs.touch(charmID) // nolint:errcheck
+	if info.IsDir() && lfs.IndexFile != "" {
+		ip := filepath.Join(fp, lfs.IndexFile)
+		if ii, err := os.Stat(ip); err == nil && ii.Mode().IsRegular() {
+			return lfs.open(ip)
+		}
 	}
 	// write a directory listing if path is a dir
 	if info.IsDir() {
-		rds, err := f.ReadDir(0)
-		if err != nil {
+		return lfs.listing(charmID, path, info)
+	}
+	if lfs.VerifyOnGet {
+		// Writes hold the path lock until the checksum of what they wrote
+		// is recorded, so the file is checked against its own.
+		defer lfs.lockPath(fp)()
+		ok, err := lfs.Verify(charmID, path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
 			return nil, err
 		}
-		fis := make([]charm.FileInfo, 0)
-		for _, v := range rds {
-			fi, err := v.Info()
-			if err != nil {
-				return nil, err
-			}
-			fin := charm.FileInfo{
-				Name:    v.Name(),
-				IsDir:   fi.IsDir(),
-				Size:    fi.Size(),
-				ModTime: fi.ModTime(),
-				Mode:    fi.Mode(),
-			}
-			fis = append(fis, fin)
-		}
-		dir := charm.FileInfo{
-			Name:    info.Name(),
-			IsDir:   true,
-			Size:    0,
-			ModTime: info.ModTime(),
-			Mode:    info.Mode(),
-			Files:   fis,
-		}
-		buf := bytes.NewBuffer(nil)
-		enc := json.NewEncoder(buf)
-		err = enc.Encode(dir)
+		if err == nil && !ok {
+			return nil, storage.ErrCorrupt
+		}
+	}
+	return lfs.open(fp)
+}
+
+// GetFile is like Get but fails with storage.ErrIsDirectory if path is a
+// directory, rather than returning a listing.
+func (lfs *LocalFileStore) GetFile(charmID, path string) (fs.File, error) {
+	defer lfs.op()()
+	isDir, err := lfs.IsDir(charmID, path)
+	if err != nil {
+		return nil, err
+	}
+	if isDir {
+		return nil, storage.ErrIsDirectory
+	}
+	return lfs.Get(charmID, path)
+}
+
+// open opens the file at fp for reading, through ReadTransform if it's set.
+// Otherwise, when CopyBufferSize is set, the file is copied out with a buffer
+// of that size.
+func (lfs *LocalFileStore) open(fp string) (fs.File, error) {
+	f, err := lfs.openStored(fp)
+	if err != nil {
+		return nil, err
+	}
+	if lfs.ReadTransform != nil {
+		return &transformedFile{File: f, r: lfs.ReadTransform(f)}, nil
+	}
+	if lfs.CopyBufferSize <= 0 {
+		return f, nil
+	}
+	return &bufferedFile{f, lfs.CopyBufferSize}, nil
+}
+
+// transformedFile is a file read through ReadTransform.
+type transformedFile struct {
+	fs.File
+	r io.Reader
+}
+
+func (tf *transformedFile) Read(p []byte) (int, error) {
+	return tf.r.Read(p)
+}
+
+// bufferedFile is a file that's copied with a buffer of a set size when used
+// with io.Copy.
+type bufferedFile struct {
+	fs.File
+	size int
+}
+
+// WriteTo implements io.WriterTo.
+func (bf *bufferedFile) WriteTo(w io.Writer) (int64, error) {
+	return io.CopyBuffer(w, struct{ io.Reader }{bf.File}, make([]byte, bf.size))
+}
+
+// copy copies from src to dst with a CopyBufferSize buffer if it's set.
+func (lfs *LocalFileStore) copy(dst io.Writer, src io.Reader) (int64, error) {
+	if lfs.CopyBufferSize <= 0 {
+		return io.Copy(dst, src)
+	}
+	return io.CopyBuffer(dst, src, make([]byte, lfs.CopyBufferSize))
+}
+
+// ListFiltered returns the entries of the directory at path for the Charm ID
+// that match the filter. It fails with storage.ErrNotDirectory if path is a
+// file.
+func (lfs *LocalFileStore) ListFiltered(charmID, path string, filter storage.ListFilter) ([]*charm.FileInfo, error) {
+	defer lfs.op()()
+	if err := lfs.requireDir(charmID, path); err != nil {
+		return nil, err
+	}
+	fis, err := lfs.listFiltered(charmID, path, filter)
+	if err != nil {
+		return nil, err
+	}
+	ptrs := make([]*charm.FileInfo, len(fis))
+	for i := range fis {
+		ptrs[i] = &fis[i]
+	}
+	return ptrs, nil
+}
+
+// List returns the entries of the directory at path for the Charm ID, as in
+// the listings returned by Get whatever the ListingFormat, even if the
+// directory has an IndexFile. It fails with storage.ErrNotDirectory if path is
+// a file.
+func (lfs *LocalFileStore) List(charmID, path string) ([]charm.FileInfo, error) {
+	defer lfs.op()()
+	if err := lfs.requireDir(charmID, path); err != nil {
+		return nil, err
+	}
+	return lfs.list(charmID, path)
+}
+
+// list returns the entries of the directory at path.
+func (lfs *LocalFileStore) list(charmID, path string) ([]charm.FileInfo, error) {
+	return lfs.listFiltered(charmID, path, storage.ListFilter{})
+}
+
+func (lfs *LocalFileStore) listFiltered(charmID, path string, filter storage.ListFilter) ([]charm.FileInfo, error) {
+	rds, err := os.ReadDir(filepath.Join(lfs.root(), charmID, path))
+	if err != nil {
+		return nil, err
+	}
+	fis := make([]charm.FileInfo, 0)
+	for _, v := range rds {
+		if !lfs.ShowReserved && isReserved(v.Name()) {
+			continue
+		}
+		if !filter.Match(v.Name(), v.IsDir()) {
+			continue
+		}
+		if lfs.MaxListEntries > 0 && len(fis) == lfs.MaxListEntries {
+			return nil, storage.ErrTooManyEntries
+		}
+		fin, err := lfs.entryInfo(charmID, path, v)
 		if err != nil {
 			return nil, err
 		}
-		return &charmfs.DirFile{
-			Buffer:   buf,
-			FileInfo: info,
-		}, nil
+		fis = append(fis, fin)
 	}
-	return f, nil
+	return fis, nil
+}
+
+// entryInfo returns the FileInfo listed for the entry v of the directory at
+// path for the Charm ID.
+func (lfs *LocalFileStore) entryInfo(charmID, path string, v fs.DirEntry) (charm.FileInfo, error) {
+	fi, err := v.Info()
+	if err != nil {
+		return charm.FileInfo{}, err
+	}
+	fin, err := lfs.fileInfo(charmID, filepath.Join(path, v.Name()), fi)
+	if err != nil {
+		return charm.FileInfo{}, err
+	}
+	if fin.IsDir && lfs.ComputeDirSizes {
+		if fin.Size, err = lfs.dirSize(charmID, filepath.Join(path, v.Name())); err != nil {
+			return charm.FileInfo{}, err
+		}
+		fin.ETag = lfs.etag(charmID, filepath.Join(path, v.Name()), fin)
+	}
+	return fin, nil
+}
+
+// ListRecursive returns the FileInfo of every file and directory below path for
+// the Charm ID. Names are paths relative to path. Listing fails with
+// storage.ErrTooManyEntries if there are more than MaxListEntries, and with
+// storage.ErrNotDirectory if path is a file.
+func (lfs *LocalFileStore) ListRecursive(charmID, path string) ([]*charm.FileInfo, error) {
+	defer lfs.op()()
+	if err := lfs.requireDir(charmID, path); err != nil {
+		return nil, err
+	}
+	fis := make([]*charm.FileInfo, 0)
+	err := lfs.walk(charmID, path, func(rel string, d fs.DirEntry) error {
+		if lfs.MaxListEntries > 0 && len(fis) == lfs.MaxListEntries {
+			return storage.ErrTooManyEntries
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fin, err := lfs.fileInfo(charmID, filepath.Join(path, rel), fi)
+		if err != nil {
+			return err
+		}
+		fin.Name = rel
+		fis = append(fis, &fin)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fis, nil
+}
+
+// fileInfo returns the charm.FileInfo for the file at path described by fi, as
+// it appears in directory listings.
+func (lfs *LocalFileStore) fileInfo(charmID, path string, fi fs.FileInfo) (charm.FileInfo, error) {
+	fin := charm.FileInfo{
+		Name:    fi.Name(),
+		IsDir:   fi.IsDir(),
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		Mode:    fi.Mode(),
+	}
+	fp := filepath.Join(lfs.root(), charmID, path)
+	if !fi.IsDir() {
+		fin.StoredSize = fi.Size()
+		n, ok, err := lfs.logicalSize(fp)
+		if err != nil {
+			return fin, err
+		}
+		if ok {
+			fin.Size = n
+		}
+	}
+	xattrs, err := getXattrs(fp)
+	if err != nil {
+		return fin, err
+	}
+	fin.Xattrs = xattrs
+	fin.ETag = lfs.etag(charmID, path, fin)
+	return fin, nil
 }
 
 // Put reads from the provided io.Reader and stores the data with the Charm ID
 // and path.
 func (lfs *LocalFileStore) Put(charmID string, path string, r io.Reader, mode fs.FileMode) error {
-	if cpath := filepath.Clean(path); cpath == string(os.PathSeparator) {
-		return fmt.Errorf("invalid path specified: %s", cpath)
+	defer lfs.op()()
+	return lfs.PutWithOptions(charmID, path, r, mode, storage.PutOptions{})
+}
+
+// PutWithOptions is like Put but also applies the provided storage.PutOptions
+// to the stored file.
+func (lfs *LocalFileStore) PutWithOptions(charmID string, path string, r io.Reader, mode fs.FileMode, opts storage.PutOptions) error {
+	if lfs.AuditLog && opts.Result == nil {
+		opts.Result = &storage.PutResult{}
+	}
+	_, err := lfs.withTimeout(func(ctx context.Context) (interface{}, error) {
+		return nil, lfs.idempotent(charmID, path, opts, func(opts storage.PutOptions) error {
+			return lfs.put(ctx, charmID, path, r, mode, opts)
+		})
+	}, nil)
+	if lfs.AuditLog {
+		lfs.audit(charmID, opPut, path, opts.Result.Size, err)
 	}
+	return err
+}
 
-	fp := filepath.Join(lfs.Path, charmID, path)
+func (lfs *LocalFileStore) put(ctx context.Context, charmID string, path string, r io.Reader, mode fs.FileMode, opts storage.PutOptions) error {
+	if cpath := filepath.Clean(path); cpath == string(os.PathSeparator) || inReserved(cpath) {
+		return fmt.Errorf("%s: %w", cpath, storage.ErrInvalidPath)
+	}
+	if err := checkXattrs(opts.Xattrs); err != nil {
+		return err
+	}
+
+	fp := filepath.Join(lfs.root(), charmID, path)
 	if mode.IsDir() {
-		return storage.EnsureDir(fp, mode)
+		if info, err := os.Lstat(fp); err == nil && info.Mode()&specialModes != 0 {
+			return storage.ErrInvalidTarget
+		}
+		if err := storage.EnsureDir(fp, mode); err != nil {
+			return err
+		}
+		lfs.bumpGenerations(charmID, path) // nolint:errcheck
+		return nil
 	}
-	err := storage.EnsureDir(filepath.Dir(fp), mode)
+	defer lfs.lockPath(fp)()
+	// The reservation is released once the write is counted in the usage, so
+	// the bytes always count against the quota.
+	reservationID := opts.ReservationID
+	defer func() { lfs.releaseQuota(charmID, reservationID) }()
+	defer lfs.trackUsage(charmID, fp)()
+	if err := lfs.prepareTarget(charmID, path, fp, mode); err != nil {
+		return err
+	}
+	r, err := decodeUpload(r, opts.UploadEncoding)
+	if err != nil {
+		return err
+	}
+	if lfs.MaxFileBytes > 0 {
+		if n, ok := readerSize(r); ok && n > lfs.MaxFileBytes {
+			return storage.ErrFileTooLarge
+		}
+		r = io.LimitReader(r, lfs.MaxFileBytes+1)
+	}
+	compress := lfs.Compress
+	allowed, err := lfs.allowance(charmID, fp, opts.ReservationID)
+	if err != nil {
+		return err
+	}
+	// The quota counts bytes on disk, which for a compressed file are only
+	// known as it's written.
+	if allowed >= 0 && !compress {
+		if n, ok := readerSize(r); ok && n > allowed {
+			return storage.ErrQuotaExceeded
+		}
+		r = io.LimitReader(r, allowed+1)
+	}
+	if opts.ExpectedSize > 0 {
+		r = io.LimitReader(r, opts.ExpectedSize+1)
+	}
+	if r, err = lfs.sniff(r); err != nil {
+		return err
+	}
+	f, err := lfs.createTemp(fp)
 	if err != nil {
 		return err
 	}
-	f, err := os.Create(fp)
+	defer os.Remove(f.Name()) // nolint:errcheck
+	defer f.Close()           // nolint:errcheck
+	h, err := lfs.checksumAlgo().newHash()
 	if err != nil {
 		return err
 	}
-	defer f.Close() // nolint:errcheck
-	_, err = io.Copy(f, r)
+	// The expected checksum may use another algorithm than the one recorded.
+	eh := h
+	expectAlgo, expectSum := parseChecksum(opts.ExpectedChecksum)
+	if opts.ExpectedChecksum != "" && expectAlgo != lfs.checksumAlgo() {
+		if eh, err = expectAlgo.newHash(); err != nil {
+			return err
+		}
+	}
+	var cw *compressedWriter
+	var w io.Writer = f
+	if compress {
+		var fw io.Writer = f
+		if allowed >= 0 {
+			fw = &limitWriter{w: f, n: allowed, err: storage.ErrQuotaExceeded}
+		}
+		if cw, err = newCompressedWriter(f, fw); err != nil {
+			return err
+		}
+		w = cw
+	}
+	hw := io.Writer(h)
+	if eh != h {
+		hw = io.MultiWriter(h, eh)
+	}
+	start := time.Now()
+	n, err := lfs.copy(io.MultiWriter(w, hw), &ctxReader{ctx, r})
 	if err != nil {
 		return err
 	}
-	if mode != 0 {
-		return f.Chmod(mode)
+	if cw != nil {
+		if err := cw.Close(); err != nil {
+			return err
+		}
+	}
+	lfs.recordWrite(n, time.Since(start))
+	if lfs.MaxFileBytes > 0 && n > lfs.MaxFileBytes {
+		return storage.ErrFileTooLarge
+	}
+	if allowed >= 0 && !compress && n > allowed {
+		return storage.ErrQuotaExceeded
+	}
+	if opts.ExpectedSize > 0 && n != opts.ExpectedSize {
+		return storage.ErrSizeMismatch
+	}
+	if opts.ExpectedChecksum != "" && !strings.EqualFold(hex.EncodeToString(eh.Sum(nil)), expectSum) {
+		return storage.ErrChecksumMismatch
+	}
+	if !compress {
+		// Data that starts like a compressed file is stored compressed, so
+		// it's read back as it was written.
+		if _, forged, err := readCompressedHeader(f); err != nil || forged {
+			if err != nil {
+				return err
+			}
+			if f, err = recompress(f); err != nil {
+				return err
+			}
+			defer f.Close() // nolint:errcheck
+			compress = true
+		}
+	}
+	if mode == 0 {
+		mode = defaultFileMode(fp)
+	}
+	if err := lfs.chmod(f, mode); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if allowed >= 0 && reservationID == "" {
+		staged, err := os.Stat(f.Name())
+		if err != nil {
+			return err
+		}
+		existing, _ := regularSize(fp)
+		if reservationID, err = lfs.claimQuota(charmID, staged.Size(), existing); err != nil {
+			return err
+		}
+	}
+	// Attributes are set before the commit so a failure leaves the file
+	// being replaced as it was.
+	if err := setXattrs(f.Name(), opts.Xattrs); err != nil {
+		return err
+	}
+	// The old checksum goes first, so a crash before the new one is recorded
+	// leaves the file unverified rather than corrupt.
+	if err := lfs.removeChecksum(charmID, path); err != nil {
+		return err
+	}
+	if err := commit(f.Name(), fp); err != nil {
+		return err
+	}
+	lfs.touch(charmID)                 // nolint:errcheck
+	lfs.bumpGenerations(charmID, path) // nolint:errcheck
+	sum := encodeChecksum(lfs.checksumAlgo(), h)
+	// A checksum that isn't recorded is computed again when it's needed.
+	lfs.writeChecksum(charmID, path, sum) // nolint:errcheck
+	if lfs.Dedup && !compress && len(opts.Xattrs) == 0 {
+		lfs.dedup(fp, sum) // nolint:errcheck
+	}
+	if opts.Result != nil {
+		info, err := os.Stat(fp)
+		if err != nil {
+			return err
+		}
+		*opts.Result = storage.PutResult{Size: n, Checksum: sum, ModTime: info.ModTime()}
+	}
+	return nil
+}
+
+// prepareTarget checks fp, the location of path for the Charm ID, can be
+// replaced by a file written with mode, and creates its parent directories.
+// The caller must hold the path lock of fp.
+func (lfs *LocalFileStore) prepareTarget(charmID, path, fp string, mode fs.FileMode) error {
+	if info, err := os.Lstat(fp); err == nil && info.Mode()&specialModes != 0 {
+		return storage.ErrInvalidTarget
+	}
+	if err := lfs.ensureParent(charmID, path, mode); err != nil {
+		return err
+	}
+	return lfs.checkCaseConflict(fp)
+}
+
+// ensureParent creates the parent directories of path for the Charm ID. If
+// one of them is a file, a *storage.NotDirectoryError naming it is returned.
+func (lfs *LocalFileStore) ensureParent(charmID, path string, mode fs.FileMode) error {
+	root := filepath.Join(lfs.root(), charmID)
+	dir := filepath.Dir(filepath.Join(root, path))
+	// EnsureDir is satisfied by a file at dir.
+	err := storage.EnsureDir(dir, mode)
+	if err == nil {
+		info, serr := os.Stat(dir)
+		if serr == nil && info.IsDir() {
+			return nil
+		}
+		err = storage.ErrNotDirectory
+	}
+	parts := strings.Split(strings.Trim(filepath.ToSlash(filepath.Clean(path)), "/"), "/")
+	for i := 1; i < len(parts); i++ {
+		p := strings.Join(parts[:i], "/")
+		info, serr := os.Stat(filepath.Join(root, filepath.FromSlash(p)))
+		if serr != nil {
+			break
+		}
+		if !info.IsDir() {
+			return &storage.NotDirectoryError{Path: "/" + p}
+		}
+	}
+	return err
+}
+
+// createTemp creates the staging file for the destination path fp.
+func (lfs *LocalFileStore) createTemp(fp string) (*os.File, error) {
+	dir := lfs.TempDir
+	if dir == "" {
+		dir = filepath.Dir(fp)
+	} else if err := storage.EnsureDir(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(dir, fmt.Sprintf(".%s.*.tmp", filepath.Base(fp)))
+}
+
+// writeSidecar replaces fp, a file the store records alongside a stored file
+// such as its checksum, with data. It's written to a staging file first so it's
+// never seen half-written.
+func writeSidecar(fp string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(fp), fmt.Sprintf(".%s.*.tmp", filepath.Base(fp)))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // nolint:errcheck
+	if _, err := f.Write(data); err != nil {
+		f.Close() // nolint:errcheck
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), fp)
+}
+
+// chmod sets the mode of the staged file f, ignoring failures if
+// IgnoreChmodErrors is set.
+func (lfs *LocalFileStore) chmod(f *os.File, mode fs.FileMode) error {
+	if err := chmodFile(f, mode); err != nil && !lfs.IgnoreChmodErrors {
+		return err
 	}
 	return nil
 }
 
+// commit atomically moves the staged file tp to fp. If tp is on a different
+// volume, it's first copied next to fp so the final rename is still atomic.
+func commit(tp, fp string) error {
+	err := rename(tp, fp)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	src, err := os.Open(tp)
+	if err != nil {
+		return err
+	}
+	defer src.Close() // nolint:errcheck
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := os.CreateTemp(filepath.Dir(fp), fmt.Sprintf(".%s.*.tmp", filepath.Base(fp)))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dst.Name()) // nolint:errcheck
+	defer dst.Close()           // nolint:errcheck
+	if _, err := io.Copy(dst, src); err != nil {
+		return err
+	}
+	if err := dst.Chmod(info.Mode()); err != nil {
+		return err
+	}
+	xattrs, err := getXattrs(tp)
+	if err != nil {
+		return err
+	}
+	if err := setXattrs(dst.Name(), xattrs); err != nil {
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if err := rename(dst.Name(), fp); err != nil {
+		return err
+	}
+	return os.Remove(tp)
+}
+
+// readerSize returns the number of bytes left in r if the reader knows it.
+func readerSize(r io.Reader) (int64, bool) {
+	switch v := r.(type) {
+	case interface{ Len() int }:
+		return int64(v.Len()), true
+	case interface{ Size() int64 }:
+		return v.Size(), true
+	}
+	return 0, false
+}
+
+// decodeUpload returns a reader of the decoded data in r, which is encoded with
+// the given storage.PutOptions UploadEncoding.
+func decodeUpload(r io.Reader, encoding string) (io.Reader, error) {
+	switch encoding {
+	case "", storage.EncodingIdentity:
+		return r, nil
+	case storage.EncodingGzip:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", storage.ErrInvalidEncoding, err)
+		}
+		return &gzipReader{zr}, nil
+	}
+	return nil, fmt.Errorf("%w: unsupported encoding %q", storage.ErrInvalidEncoding, encoding)
+}
+
+// gzipReader reports corrupt gzip streams as storage.ErrInvalidEncoding.
+type gzipReader struct {
+	zr *gzip.Reader
+}
+
+func (gr *gzipReader) Read(p []byte) (int, error) {
+	n, err := gr.zr.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("%w: %v", storage.ErrInvalidEncoding, err)
+	}
+	return n, err
+}
+
+// defaultFileMode returns the mode for a file written without one, keeping the
+// mode of the file being replaced if there is one.
+func defaultFileMode(fp string) fs.FileMode {
+	if info, err := os.Stat(fp); err == nil {
+		return info.Mode().Perm()
+	}
+	return 0o644
+}
+
 // Delete deletes the file at the given path for the provided Charm ID.
 func (lfs *LocalFileStore) Delete(charmID string, path string) error {
-	fp := filepath.Join(lfs.Path, charmID, path)
-	return os.RemoveAll(fp)
+	_, err := lfs.withTimeout(func(ctx context.Context) (interface{}, error) {
+		defer lfs.lockPath(filepath.Join(lfs.root(), charmID, path))()
+		return nil, lfs.delete(charmID, path)
+	}, nil)
+	lfs.audit(charmID, opDelete, path, 0, err)
+	return err
+}
+
+// delete deletes the file or directory at path for the Charm ID. The caller
+// must hold its path lock.
+func (lfs *LocalFileStore) delete(charmID string, path string) error {
+	root := filepath.Join(lfs.root(), charmID)
+	fp := filepath.Join(root, path)
+	if !strings.HasPrefix(fp, root+string(os.PathSeparator)) {
+		return storage.ErrInvalidPath
+	}
+	defer lfs.trackUsage(charmID, fp)()
+	// Grants go first so they can't outlive the files they were for.
+	if err := lfs.deleteACLs(charmID, path); err != nil {
+		return err
+	}
+	lfs.dropGenerations(charmID, path)
+	if err := os.RemoveAll(fp); err != nil {
+		return err
+	}
+	lfs.bumpGenerations(charmID, path)            // nolint:errcheck
+	os.RemoveAll(lfs.checksumPath(charmID, path)) // nolint:errcheck
+	return nil
+}
+
+// CopyFrom copies the file or directory at srcPath for srcCharmID in the src
+// FileStore to the given path for the Charm ID.
+func (lfs *LocalFileStore) CopyFrom(charmID string, path string, src storage.FileStore, srcCharmID string, srcPath string) error {
+	defer lfs.op()()
+	return storage.Copy(lfs, charmID, path, src, srcCharmID, srcPath)
+}
+
+// withTimeout runs op, failing with storage.ErrTimeout if it takes longer than
+// OpTimeout. The context passed to op is canceled at that point. If op is
+// stuck and can't stop, it's left to finish in the background, counted as
+// stuck so Relocate and RenameCharmID don't wait for it, and discard, if
+// provided, is called to release the value it returns.
+func (lfs *LocalFileStore) withTimeout(op func(ctx context.Context) (interface{}, error), discard func(interface{})) (interface{}, error) {
+	// Relocate waits for operations in flight and holds off new ones.
+	end := lfs.op()
+	if lfs.OpTimeout <= 0 {
+		defer end()
+		return op(context.Background())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lfs.OpTimeout)
+	defer cancel()
+	type result struct {
+		v   interface{}
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer end()
+		v, err := op(ctx)
+		done <- result{v, err}
+	}()
+	select {
+	case res := <-done:
+		return res.v, timeoutError(res.err)
+	case <-ctx.Done():
+	}
+	select {
+	case res := <-done:
+		return res.v, timeoutError(res.err)
+	default:
+	}
+	lfs.opMu.Lock()
+	lfs.stuck++
+	if lfs.drained != nil {
+		close(lfs.drained)
+		lfs.drained = nil
+	}
+	lfs.opMu.Unlock()
+	go func() {
+		res := <-done
+		lfs.opMu.Lock()
+		lfs.stuck--
+		lfs.opMu.Unlock()
+		if res.err == nil && discard != nil {
+			discard(res.v)
+		}
+	}()
+	return nil, storage.ErrTimeout
+}
+
+func timeoutError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return storage.ErrTimeout
+	}
+	return err
+}
+
+// ctxReader stops reading once its context is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *ctxReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// ListCharmIDs returns the Charm IDs that have data stored.
+func (lfs *LocalFileStore) ListCharmIDs() ([]string, error) {
+	defer lfs.op()()
+	des, err := os.ReadDir(lfs.root())
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(des))
+	for _, de := range des {
+		if !de.IsDir() || reservedRoots[de.Name()] {
+			continue
+		}
+		ids = append(ids, de.Name())
+	}
+	return ids, nil
 }
@@ -2,12 +2,21 @@ package localstorage
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
+	charm "github.com/charmbracelet/charm/proto"
+	"github.com/charmbracelet/charm/server/storage"
 	"github.com/google/uuid"
 )
 
@@ -95,3 +104,847 @@ func TestPut(t *testing.T) {
 		}
 	})
 }
+
+func TestPutTempDir(t *testing.T) {
+	charmID := uuid.New().String()
+	content := "hello world"
+	path := "/foo/hello.txt"
+
+	assertStored := func(t *testing.T, lfs *LocalFileStore) {
+		t.Helper()
+		read, err := os.ReadFile(filepath.Join(lfs.Path, charmID, path))
+		if err != nil {
+			t.Fatalf("expected no error when reading file %s, %v", path, err)
+		}
+		if string(read) != content {
+			t.Fatalf("expected content to be %s, got %s", content, string(read))
+		}
+		for _, dir := range []string{lfs.TempDir, filepath.Join(lfs.Path, charmID, "foo")} {
+			des, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("expected no error when reading dir %s, %v", dir, err)
+			}
+			for _, de := range des {
+				if de.Name() != "hello.txt" {
+					t.Fatalf("expected staging file %s to be removed", de.Name())
+				}
+			}
+		}
+	}
+
+	t.Run("same volume", func(t *testing.T) {
+		lfs, err := NewLocalFileStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		lfs.TempDir = t.TempDir()
+		if err := lfs.Put(charmID, path, bytes.NewBufferString(content), fs.FileMode(0o644)); err != nil {
+			t.Fatalf("expected no error when file path is %s, %v", path, err)
+		}
+		assertStored(t, lfs)
+	})
+
+	t.Run("cross volume", func(t *testing.T) {
+		lfs, err := NewLocalFileStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		lfs.TempDir = t.TempDir()
+		rename = func(oldpath, newpath string) error {
+			if filepath.Dir(oldpath) != filepath.Dir(newpath) {
+				return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+			}
+			return os.Rename(oldpath, newpath)
+		}
+		defer func() { rename = os.Rename }()
+		if err := lfs.Put(charmID, path, bytes.NewBufferString(content), fs.FileMode(0o600)); err != nil {
+			t.Fatalf("expected no error when file path is %s, %v", path, err)
+		}
+		assertStored(t, lfs)
+		info, err := os.Stat(filepath.Join(lfs.Path, charmID, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0o600 {
+			t.Fatalf("expected mode to be %v, got %v", fs.FileMode(0o600), info.Mode().Perm())
+		}
+	})
+}
+
+func TestPutChmodUnsupported(t *testing.T) {
+	charmID := uuid.New().String()
+	chmodFile = func(f *os.File, mode fs.FileMode) error {
+		return &os.PathError{Op: "chmod", Path: f.Name(), Err: syscall.ENOTSUP}
+	}
+	defer func() { chmodFile = (*os.File).Chmod }()
+
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/a.txt", bytes.NewBufferString("hello"), fs.FileMode(0o600)); !errors.Is(err, syscall.ENOTSUP) {
+		t.Fatalf("expected the chmod error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(lfs.Path, charmID, "a.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected no file to be stored, got %v", err)
+	}
+
+	lfs.IgnoreChmodErrors = true
+	if err := lfs.Put(charmID, "/a.txt", bytes.NewBufferString("hello"), fs.FileMode(0o600)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(lfs.Path, charmID, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Fatalf("expected the data to be stored, got %q", data)
+	}
+}
+
+func TestListCharmIDs(t *testing.T) {
+	tdir := t.TempDir()
+	lfs, err := NewLocalFileStore(tdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		charmID := uuid.New().String()
+		want[charmID] = true
+		if err := lfs.Put(charmID, "/hello.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, dir := range []string{".health", "blobs"} {
+		if err := os.Mkdir(filepath.Join(tdir, dir), 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids, err := lfs.ListCharmIDs()
+	if err != nil {
+		t.Fatalf("expected no error when listing charm ids, %v", err)
+	}
+	if len(ids) != len(want) {
+		t.Fatalf("expected %d charm ids, got %d: %v", len(want), len(ids), ids)
+	}
+	for _, id := range ids {
+		if !want[id] {
+			t.Fatalf("unexpected charm id %s", id)
+		}
+	}
+}
+
+// opaqueReader hides the size of the underlying reader so Put has to stream it.
+type opaqueReader struct {
+	r io.Reader
+}
+
+func (sr *opaqueReader) Read(p []byte) (int, error) {
+	return sr.r.Read(p)
+}
+
+func TestPutMaxFileBytes(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.MaxFileBytes = 8
+
+	if err := lfs.Put(charmID, "/ok.txt", bytes.NewBufferString("12345678"), fs.FileMode(0o644)); err != nil {
+		t.Fatalf("expected no error for a file at the limit, %v", err)
+	}
+
+	tests := map[string]io.Reader{
+		"pre-check": bytes.NewBufferString("123456789"),
+		"streaming": &opaqueReader{bytes.NewBufferString("123456789")},
+	}
+	for name, r := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := lfs.Put(charmID, "/big.txt", r, fs.FileMode(0o644))
+			if !errors.Is(err, storage.ErrFileTooLarge) {
+				t.Fatalf("expected ErrFileTooLarge, got %v", err)
+			}
+			des, err := os.ReadDir(filepath.Join(lfs.Path, charmID))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(des) != 1 || des[0].Name() != "ok.txt" {
+				t.Fatalf("expected only ok.txt to be stored, got %v", des)
+			}
+		})
+	}
+}
+
+func TestListReserved(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/hello.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	staging := ".hello.txt.123456.tmp"
+	if err := os.WriteFile(filepath.Join(lfs.Path, charmID, staging), []byte("hel"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	names := func() []string {
+		t.Helper()
+		f, err := lfs.Get(charmID, "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close() // nolint:errcheck
+		var dir charm.FileInfo
+		if err := json.NewDecoder(f).Decode(&dir); err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, 0, len(dir.Files))
+		for _, fi := range dir.Files {
+			names = append(names, fi.Name)
+		}
+		return names
+	}
+
+	if got := names(); len(got) != 1 || got[0] != "hello.txt" {
+		t.Fatalf("expected reserved entries to be hidden, got %v", got)
+	}
+	lfs.ShowReserved = true
+	if got := names(); len(got) != 2 || got[0] != staging {
+		t.Fatalf("expected reserved entries to be listed, got %v", got)
+	}
+}
+
+func TestPutStagingName(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/.notes.tmp", "/docs/.draft.1.tmp", "/.cache.tmp/a.txt"} {
+		err := lfs.Put(charmID, path, bytes.NewBufferString("hidden"), fs.FileMode(0o644))
+		if !errors.Is(err, storage.ErrInvalidPath) {
+			t.Fatalf("expected ErrInvalidPath putting %s, got %v", path, err)
+		}
+	}
+	if err := lfs.Put(charmID, "/.notes", bytes.NewBufferString("shown"), fs.FileMode(0o644)); err != nil {
+		t.Fatalf("expected dot files to be allowed, %v", err)
+	}
+}
+
+// slowReader returns one byte per read, blocking each read until its delay
+// passes or release is closed.
+type slowReader struct {
+	n       int
+	delay   time.Duration
+	release chan struct{}
+}
+
+func (sr *slowReader) Read(p []byte) (int, error) {
+	if sr.n == 0 {
+		return 0, io.EOF
+	}
+	select {
+	case <-time.After(sr.delay):
+	case <-sr.release:
+	}
+	sr.n--
+	p[0] = 'a'
+	return 1, nil
+}
+
+func TestPutOpTimeout(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.OpTimeout = 20 * time.Millisecond
+	dir := filepath.Join(lfs.Path, charmID)
+
+	if err := lfs.Put(charmID, "/ok.txt", bytes.NewBufferString("ok"), fs.FileMode(0o644)); err != nil {
+		t.Fatalf("expected no error for a fast put, %v", err)
+	}
+
+	tests := map[string]time.Duration{
+		"slow":  5 * time.Millisecond,
+		"stuck": time.Hour,
+	}
+	for name, delay := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := &slowReader{n: 100, delay: delay, release: make(chan struct{})}
+			start := time.Now()
+			err := lfs.Put(charmID, "/slow.txt", r, fs.FileMode(0o644))
+			if !errors.Is(err, storage.ErrTimeout) {
+				t.Fatalf("expected ErrTimeout, got %v", err)
+			}
+			if time.Since(start) > time.Second {
+				t.Fatalf("expected put to give up after the timeout")
+			}
+			if delay == time.Hour {
+				if err := lfs.Relocate(t.TempDir()); !errors.Is(err, storage.ErrStuck) {
+					t.Fatalf("expected relocating to fail while the put is stuck, got %v", err)
+				}
+			}
+			close(r.release)
+			time.Sleep(50 * time.Millisecond)
+			des, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(des) != 1 || des[0].Name() != "ok.txt" {
+				t.Fatalf("expected partial write to be cleaned up, got %v", des)
+			}
+		})
+	}
+	if err := lfs.Relocate(t.TempDir()); err != nil {
+		t.Fatalf("expected relocating to work once the put stopped, got %v", err)
+	}
+}
+
+func TestGetIndexFile(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.IndexFile = "index.html"
+	if err := lfs.Put(charmID, "/site/index.html", bytes.NewBufferString("<h1>hi</h1>"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/other/hello.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := lfs.Get(charmID, "/site")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close() // nolint:errcheck
+	read, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(read) != "<h1>hi</h1>" {
+		t.Fatalf("expected index file content, got %s", string(read))
+	}
+
+	f, err = lfs.Get(charmID, "/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close() // nolint:errcheck
+	var dir charm.FileInfo
+	if err := json.NewDecoder(f).Decode(&dir); err != nil {
+		t.Fatalf("expected a directory listing without an index file, %v", err)
+	}
+	if len(dir.Files) != 1 || dir.Files[0].Name != "hello.txt" {
+		t.Fatalf("expected listing of hello.txt, got %+v", dir.Files)
+	}
+}
+
+func TestListComputeDirSizes(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.ComputeDirSizes = true
+	files := map[string]string{
+		"/foo/a.txt":     "hello",
+		"/foo/bar/b.txt": "hello world",
+		"/foo/bar/c.txt": "!",
+	}
+	for path, content := range files {
+		if err := lfs.Put(charmID, path, bytes.NewBufferString(content), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := lfs.Get(charmID, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close() // nolint:errcheck
+	var dir charm.FileInfo
+	if err := json.NewDecoder(f).Decode(&dir); err != nil {
+		t.Fatal(err)
+	}
+	if len(dir.Files) != 1 || dir.Files[0].Size != 17 {
+		t.Fatalf("expected foo to be 17 bytes, got %+v", dir.Files)
+	}
+
+	info, err := lfs.Stat(charmID, "/foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 12 {
+		t.Fatalf("expected foo/bar to be 12 bytes, got %d", info.Size())
+	}
+}
+
+func TestStatBatch(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/a.txt", "/foo/b.txt"} {
+		if err := lfs.Put(charmID, path, bytes.NewBufferString(path), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths := []string{"/foo/b.txt", "/missing.txt", "/a.txt", "/foo/missing.txt"}
+	fis, errs := lfs.StatBatch(charmID, paths)
+	if len(fis) != len(paths) || len(errs) != len(paths) {
+		t.Fatalf("expected %d results, got %d infos and %d errors", len(paths), len(fis), len(errs))
+	}
+	for i, path := range paths {
+		if strings.Contains(path, "missing") {
+			if fis[i] != nil || !errors.Is(errs[i], fs.ErrNotExist) {
+				t.Fatalf("expected fs.ErrNotExist for %s, got %+v, %v", path, fis[i], errs[i])
+			}
+			continue
+		}
+		if errs[i] != nil {
+			t.Fatalf("expected no error for %s, %v", path, errs[i])
+		}
+		if fis[i].Name != filepath.Base(path) || fis[i].Size != int64(len(path)) {
+			t.Fatalf("expected info for %s, got %+v", path, fis[i])
+		}
+	}
+}
+
+func TestListRecursive(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/root.txt", "/foo/a.txt", "/foo/bar/b.txt", "/foo/bar/baz/c.txt"} {
+		if err := lfs.Put(charmID, path, bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fis, err := lfs.ListRecursive(charmID, "/foo")
+	if err != nil {
+		t.Fatalf("expected no error listing recursively, %v", err)
+	}
+	want := map[string]bool{
+		"a.txt":         false,
+		"bar":           true,
+		"bar/b.txt":     false,
+		"bar/baz":       true,
+		"bar/baz/c.txt": false,
+	}
+	if len(fis) != len(want) {
+		t.Fatalf("expected %d entries, got %d", len(want), len(fis))
+	}
+	for _, fi := range fis {
+		isDir, ok := want[fi.Name]
+		if !ok {
+			t.Fatalf("unexpected entry %s", fi.Name)
+		}
+		if fi.IsDir != isDir {
+			t.Fatalf("expected %s IsDir to be %t", fi.Name, isDir)
+		}
+	}
+
+	lfs.MaxListEntries = 4
+	if _, err := lfs.ListRecursive(charmID, "/foo"); !errors.Is(err, storage.ErrTooManyEntries) {
+		t.Fatalf("expected ErrTooManyEntries, got %v", err)
+	}
+	if _, err := lfs.ListRecursive(charmID, "/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist, got %v", err)
+	}
+}
+
+func TestDeleteRoot(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/keep.txt", "/foo/bar.txt"} {
+		if err := lfs.Put(charmID, path, bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, path := range []string{"", ".", "/", "foo/..", ".."} {
+		if err := lfs.Delete(charmID, path); !errors.Is(err, storage.ErrInvalidPath) {
+			t.Fatalf("expected ErrInvalidPath deleting %q, got %v", path, err)
+		}
+	}
+	if _, err := lfs.Stat(charmID, "/keep.txt"); err != nil {
+		t.Fatalf("expected files to survive refused deletes, %v", err)
+	}
+	if err := lfs.Delete(charmID, "/foo"); err != nil {
+		t.Fatalf("expected no error deleting a subpath, %v", err)
+	}
+	if _, err := lfs.Stat(charmID, "/foo/bar.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist after delete, got %v", err)
+	}
+}
+
+func TestCopyBufferSize(t *testing.T) {
+	charmID := uuid.New().String()
+	data := make([]byte, 1<<20+13)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	for _, size := range []int{0, 7, 4 << 20} {
+		lfs, err := NewLocalFileStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		lfs.CopyBufferSize = size
+		if err := lfs.Put(charmID, "/data.bin", &opaqueReader{bytes.NewReader(data)}, fs.FileMode(0o644)); err != nil {
+			t.Fatalf("expected no error with buffer size %d, %v", size, err)
+		}
+		f, err := lfs.Get(charmID, "/data.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := bytes.NewBuffer(nil)
+		_, err = io.Copy(buf, f)
+		f.Close() // nolint:errcheck
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Fatalf("expected identical data with buffer size %d", size)
+		}
+	}
+}
+
+func BenchmarkCopyBufferSize(b *testing.B) {
+	charmID := uuid.New().String()
+	data := make([]byte, 16<<20)
+	for _, size := range []int{0, 256 << 10, 1 << 20} {
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			lfs, err := NewLocalFileStore(b.TempDir())
+			if err != nil {
+				b.Fatal(err)
+			}
+			lfs.CopyBufferSize = size
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if err := lfs.Put(charmID, "/data.bin", &opaqueReader{bytes.NewReader(data)}, fs.FileMode(0o644)); err != nil {
+					b.Fatal(err)
+				}
+				f, err := lfs.Get(charmID, "/data.bin")
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.Copy(io.Discard, f); err != nil {
+					b.Fatal(err)
+				}
+				f.Close() // nolint:errcheck
+			}
+		})
+	}
+}
+
+func TestPutGzipEncoding(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := strings.Repeat("hello gzip ", 100)
+	gz := bytes.NewBuffer(nil)
+	zw := gzip.NewWriter(gz)
+	if _, err := zw.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	compressed := gz.Bytes()
+	opts := storage.PutOptions{UploadEncoding: storage.EncodingGzip}
+
+	t.Run("valid", func(t *testing.T) {
+		if err := lfs.PutWithOptions(charmID, "/hello.txt", bytes.NewReader(compressed), fs.FileMode(0o644), opts); err != nil {
+			t.Fatalf("expected no error storing gzip upload, %v", err)
+		}
+		b, err := os.ReadFile(filepath.Join(lfs.Path, charmID, "hello.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != content {
+			t.Fatalf("expected decompressed content to be stored, got %q", b)
+		}
+		ok, err := lfs.Verify(charmID, "/hello.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatal("expected checksum to cover the decompressed content")
+		}
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		corrupt := append([]byte(nil), compressed...)
+		corrupt[len(corrupt)/2] ^= 0xff
+		for name, data := range map[string][]byte{"header": []byte("not gzip"), "body": corrupt} {
+			err := lfs.PutWithOptions(charmID, "/corrupt.txt", bytes.NewReader(data), fs.FileMode(0o644), opts)
+			if !errors.Is(err, storage.ErrInvalidEncoding) {
+				t.Fatalf("expected ErrInvalidEncoding for corrupt %s, got %v", name, err)
+			}
+		}
+		if _, err := lfs.Stat(charmID, "/corrupt.txt"); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected no file after a corrupt upload, got %v", err)
+		}
+		des, err := os.ReadDir(filepath.Join(lfs.Path, charmID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, de := range des {
+			if isReserved(de.Name()) {
+				t.Fatalf("expected staged file to be removed, found %s", de.Name())
+			}
+		}
+	})
+}
+
+func TestListFiltered(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/photo.PNG", "/drawing.png", "/notes.txt", "/docs/a.txt", "/pics/b.png"} {
+		if err := lfs.Put(charmID, path, bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := func(filter storage.ListFilter) []string {
+		fis, err := lfs.ListFiltered(charmID, "/", filter)
+		if err != nil {
+			t.Fatalf("expected no error listing with %+v, %v", filter, err)
+		}
+		ns := make([]string, 0, len(fis))
+		for _, fi := range fis {
+			ns = append(ns, fi.Name)
+		}
+		return ns
+	}
+	tests := []struct {
+		name   string
+		filter storage.ListFilter
+		want   []string
+	}{
+		{"none", storage.ListFilter{}, []string{"docs", "drawing.png", "notes.txt", "photo.PNG", "pics"}},
+		{"dirs only", storage.ListFilter{DirsOnly: true}, []string{"docs", "pics"}},
+		{"files only", storage.ListFilter{FilesOnly: true}, []string{"drawing.png", "notes.txt", "photo.PNG"}},
+		{"extensions", storage.ListFilter{FilesOnly: true, Extensions: []string{".png"}}, []string{"drawing.png", "photo.PNG"}},
+		{"extensions with dirs", storage.ListFilter{Extensions: []string{"txt"}}, []string{"docs", "notes.txt", "pics"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := names(tc.filter)
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestPutResult(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res storage.PutResult
+	opts := storage.PutOptions{Result: &res}
+	if err := lfs.PutWithOptions(charmID, "/hello.txt", &opaqueReader{bytes.NewBufferString("hello world")}, fs.FileMode(0o644), opts); err != nil {
+		t.Fatal(err)
+	}
+	info, err := lfs.Stat(charmID, "/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := lfs.Checksum(charmID, "/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Size != info.Size() || res.Size != 11 {
+		t.Fatalf("expected size %d, got %d", info.Size(), res.Size)
+	}
+	if !res.ModTime.Equal(info.ModTime()) {
+		t.Fatalf("expected modtime %v, got %v", info.ModTime(), res.ModTime)
+	}
+	if res.Checksum != sum {
+		t.Fatalf("expected checksum %s, got %s", sum, res.Checksum)
+	}
+}
+
+func TestPathKindMismatch(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/foo/hello.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+
+	if isDir, err := lfs.IsDir(charmID, "/foo"); err != nil || !isDir {
+		t.Fatalf("expected /foo to be a directory, %t %v", isDir, err)
+	}
+	if isDir, err := lfs.IsDir(charmID, "/foo/hello.txt"); err != nil || isDir {
+		t.Fatalf("expected /foo/hello.txt not to be a directory, %t %v", isDir, err)
+	}
+	if _, err := lfs.IsDir(charmID, "/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist, got %v", err)
+	}
+
+	if _, err := lfs.GetFile(charmID, "/foo"); !errors.Is(err, storage.ErrIsDirectory) {
+		t.Fatalf("expected ErrIsDirectory getting a directory as a file, got %v", err)
+	}
+	f, err := lfs.GetFile(charmID, "/foo/hello.txt")
+	if err != nil {
+		t.Fatalf("expected no error getting a file, %v", err)
+	}
+	f.Close() // nolint:errcheck
+
+	if _, err := lfs.ListFiltered(charmID, "/foo/hello.txt", storage.ListFilter{}); !errors.Is(err, storage.ErrNotDirectory) {
+		t.Fatalf("expected ErrNotDirectory listing a file, got %v", err)
+	}
+	if _, err := lfs.ListRecursive(charmID, "/foo/hello.txt"); !errors.Is(err, storage.ErrNotDirectory) {
+		t.Fatalf("expected ErrNotDirectory listing a file recursively, got %v", err)
+	}
+	if _, err := lfs.DirGeneration(charmID, "/foo/hello.txt"); !errors.Is(err, storage.ErrNotDirectory) {
+		t.Fatalf("expected ErrNotDirectory for the generation of a file, got %v", err)
+	}
+}
+
+func TestPutIdempotencyKey(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(path, content string) (storage.PutResult, error) {
+		var res storage.PutResult
+		opts := storage.PutOptions{IdempotencyKey: "upload-1", Result: &res}
+		err := lfs.PutWithOptions(charmID, path, bytes.NewBufferString(content), fs.FileMode(0o644), opts)
+		return res, err
+	}
+	first, err := put("/hello.txt", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen, err := lfs.DirGeneration(charmID, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The retry's data is never written, so changing it shows whether it was.
+	second, err := put("/hello.txt", "HELLO")
+	if err != nil {
+		t.Fatalf("expected no error retrying, %v", err)
+	}
+	if second != first {
+		t.Fatalf("expected retry to return the first result %+v, got %+v", first, second)
+	}
+	b, err := os.ReadFile(filepath.Join(lfs.Path, charmID, "hello.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("expected the file to be written once, got %q", b)
+	}
+	if n, err := lfs.DirGeneration(charmID, "/"); err != nil || n != gen {
+		t.Fatalf("expected the retry not to change the directory, %d %v", n, err)
+	}
+
+	if _, err := put("/other.txt", "hello"); !errors.Is(err, storage.ErrIdempotencyKeyReused) {
+		t.Fatalf("expected ErrIdempotencyKeyReused, got %v", err)
+	}
+}
+
+func TestReadTransform(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfs.ReadTransform = func(r io.Reader) io.Reader {
+		pr, pw := io.Pipe()
+		go func() {
+			b, err := io.ReadAll(r)
+			if err == nil {
+				_, err = pw.Write(bytes.ToUpper(b))
+			}
+			pw.CloseWithError(err) // nolint:errcheck
+		}()
+		return pr
+	}
+	if err := lfs.Put(charmID, "/foo/hello.txt", bytes.NewBufferString("hello world"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	f, err := lfs.Get(charmID, "/foo/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(f)
+	f.Close() // nolint:errcheck
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "HELLO WORLD" {
+		t.Fatalf("expected transformed content, got %q", b)
+	}
+	stored, err := os.ReadFile(filepath.Join(lfs.Path, charmID, "foo", "hello.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stored) != "hello world" {
+		t.Fatalf("expected stored content to be untouched, got %q", stored)
+	}
+
+	f, err = lfs.Get(charmID, "/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close() // nolint:errcheck
+	var dir charm.FileInfo
+	if err := json.NewDecoder(f).Decode(&dir); err != nil {
+		t.Fatalf("expected listings not to be transformed, %v", err)
+	}
+	if len(dir.Files) != 1 || dir.Files[0].Name != "hello.txt" {
+		t.Fatalf("unexpected listing %+v", dir)
+	}
+}
+
+func TestPutUnderFile(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/x/a", bytes.NewBufferString("a"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/x/a/b", "/x/a/b/c"} {
+		err := lfs.Put(charmID, path, bytes.NewBufferString("b"), fs.FileMode(0o644))
+		var nde *storage.NotDirectoryError
+		if !errors.As(err, &nde) {
+			t.Fatalf("%s: expected a NotDirectoryError, got %v", path, err)
+		}
+		if nde.Path != "/x/a" {
+			t.Fatalf("%s: expected the error to name /x/a, got %s", path, nde.Path)
+		}
+		if !errors.Is(err, storage.ErrNotDirectory) {
+			t.Fatalf("%s: expected the error to match ErrNotDirectory", path)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package localstorage
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+func TestPutSpecialFile(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(lfs.Path, charmID)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	fifo := filepath.Join(dir, "fifo")
+	if err := syscall.Mkfifo(fifo, 0o600); err != nil {
+		t.Skipf("cannot create fifo: %v", err)
+	}
+
+	for _, mode := range []fs.FileMode{0o644, fs.ModeDir | 0o755} {
+		err := lfs.Put(charmID, "/fifo", bytes.NewBufferString("hello"), mode)
+		if !errors.Is(err, storage.ErrInvalidTarget) {
+			t.Fatalf("expected ErrInvalidTarget, got %v", err)
+		}
+	}
+	info, err := os.Lstat(fifo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&fs.ModeNamedPipe == 0 {
+		t.Fatalf("expected fifo to be left in place, got %v", info.Mode())
+	}
+}
+
+func TestWalkSymlinkLoop(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/foo/bar/hello.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(lfs.Path, charmID)
+	if err := os.Symlink("hello.txt", filepath.Join(root, "foo", "bar", "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	fis, err := lfs.ListRecursive(charmID, "/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 3 || fis[2].Name != "bar/link.txt" || fis[2].Size != 5 {
+		t.Fatalf("expected the linked file to be listed, got %+v", fis)
+	}
+
+	for _, links := range [][][2]string{
+		{{"foo/bar/up", ".."}},
+		{{"foo/a", "b"}, {"foo/b", "a"}},
+	} {
+		for _, l := range links {
+			if err := os.Symlink(l[1], filepath.Join(root, l[0])); err != nil {
+				t.Fatal(err)
+			}
+		}
+		done := make(chan error, 1)
+		go func() {
+			_, err := lfs.Usage(charmID)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if !errors.Is(err, storage.ErrSymlinkLoop) {
+				t.Fatalf("%v: expected ErrSymlinkLoop, got %v", links, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%v: walk didn't terminate", links)
+		}
+		for _, l := range links {
+			if err := os.Remove(filepath.Join(root, l[0])); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestWalkSymlink(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/foo/hello.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(lfs.Path, charmID)
+	if err := os.Symlink("foo", filepath.Join(root, "bar")); err != nil {
+		t.Fatal(err)
+	}
+	n, err := lfs.Usage(charmID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 {
+		t.Fatalf("expected linked directory to be followed, got usage %d", n)
+	}
+}
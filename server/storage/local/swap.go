@@ -0,0 +1,100 @@
+package localstorage
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// Swap exchanges the files at pathA and pathB for the Charm ID, along with
+// their checksums. Both files must exist. On Linux the exchange is atomic, so
+// readers see either the old or the new file at each path, never neither.
+// Elsewhere the files are swapped with a series of renames, during which
+// pathA briefly doesn't exist.
+func (lfs *LocalFileStore) Swap(charmID, pathA, pathB string) error {
+	defer lfs.op()()
+	for _, path := range []string{pathA, pathB} {
+		if cpath := filepath.Clean(path); cpath == string(os.PathSeparator) || inReserved(cpath) {
+			return fmt.Errorf("invalid path specified: %s", cpath)
+		}
+	}
+	a := filepath.Join(lfs.root(), charmID, pathA)
+	b := filepath.Join(lfs.root(), charmID, pathB)
+	if a == b {
+		return storage.ErrInvalidTarget
+	}
+	// Lock in a consistent order so concurrent swaps of the same paths can't
+	// deadlock.
+	first, second := a, b
+	if second < first {
+		first, second = second, first
+	}
+	defer lfs.lockPath(first)()
+	defer lfs.lockPath(second)()
+	for _, fp := range []string{a, b} {
+		info, err := os.Lstat(fp)
+		if os.IsNotExist(err) {
+			return fs.ErrNotExist
+		}
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return storage.ErrInvalidTarget
+		}
+	}
+	if err := exchange(a, b); err != nil {
+		return err
+	}
+	if err := swapSidecar(lfs.checksumPath(charmID, pathA), lfs.checksumPath(charmID, pathB)); err != nil {
+		return err
+	}
+	lfs.touch(charmID)                  // nolint:errcheck
+	lfs.bumpGenerations(charmID, pathA) // nolint:errcheck
+	lfs.bumpGenerations(charmID, pathB) // nolint:errcheck
+	return nil
+}
+
+// swapSidecar exchanges the sidecar files at a and b, either of which may be
+// missing.
+func swapSidecar(a, b string) error {
+	_, errA := os.Stat(a)
+	_, errB := os.Stat(b)
+	switch {
+	case errA == nil && errB == nil:
+		return exchange(a, b)
+	case errA == nil:
+		if err := os.MkdirAll(filepath.Dir(b), 0o700); err != nil {
+			return err
+		}
+		return os.Rename(a, b)
+	case errB == nil:
+		if err := os.MkdirAll(filepath.Dir(a), 0o700); err != nil {
+			return err
+		}
+		return os.Rename(b, a)
+	}
+	return nil
+}
+
+// exchangeRenames swaps the files at a and b by moving a aside, moving b to
+// a, and moving a's file to b. A failed step is rolled back.
+func exchangeRenames(a, b string) error {
+	tmp := fmt.Sprintf("%s.swap.tmp", filepath.Join(filepath.Dir(a), "."+filepath.Base(a)))
+	if err := os.Rename(a, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(b, a); err != nil {
+		os.Rename(tmp, a) // nolint:errcheck
+		return err
+	}
+	if err := os.Rename(tmp, b); err != nil {
+		os.Rename(a, b)   // nolint:errcheck
+		os.Rename(tmp, a) // nolint:errcheck
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package localstorage
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSwapAtomic(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/active", bytes.NewBufferString("v1"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/candidate", bytes.NewBufferString("v2"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			if err := lfs.Swap(charmID, "/active", "/candidate"); err != nil {
+				t.Errorf("expected no error swapping, %v", err)
+				break
+			}
+		}
+		close(done)
+	}()
+	fp := filepath.Join(lfs.Path, charmID, "active")
+	for {
+		select {
+		case <-done:
+			wg.Wait()
+			return
+		default:
+		}
+		b, err := os.ReadFile(fp)
+		if err != nil {
+			t.Fatalf("expected active to exist throughout, %v", err)
+		}
+		if s := string(b); s != "v1" && s != "v2" {
+			t.Fatalf("expected either version, got %q", s)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package localstorage
+
+// exchange swaps the files at a and b. There's no renameat2 outside Linux, so
+// it's done with a series of renames.
+func exchange(a, b string) error {
+	return exchangeRenames(a, b)
+}
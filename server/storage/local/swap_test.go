@@ -0,0 +1,82 @@
+package localstorage
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSwap(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/config/active", bytes.NewBufferString("v1"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/config/candidate", bytes.NewBufferString("v2"), fs.FileMode(0o600)); err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Swap(charmID, "/config/active", "/config/candidate"); err != nil {
+		t.Fatalf("expected no error swapping, %v", err)
+	}
+	for path, want := range map[string]string{"active": "v2", "candidate": "v1"} {
+		b, err := os.ReadFile(filepath.Join(lfs.Path, charmID, "config", path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Fatalf("expected %s to hold %q, got %q", path, want, b)
+		}
+		ok, err := lfs.Verify(charmID, "/config/"+path)
+		if err != nil || !ok {
+			t.Fatalf("expected checksum of %s to be swapped, %t %v", path, ok, err)
+		}
+	}
+	info, err := os.Stat(filepath.Join(lfs.Path, charmID, "config", "active"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected mode to move with the file, got %v", info.Mode().Perm())
+	}
+	if err := lfs.Swap(charmID, "/config/active", "/config/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected ErrNotExist swapping with a missing file, got %v", err)
+	}
+}
+
+func TestExchangeRenames(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	if err := os.WriteFile(a, []byte("a"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, []byte("b"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := exchangeRenames(a, b); err != nil {
+		t.Fatal(err)
+	}
+	for fp, want := range map[string]string{a: "b", b: "a"} {
+		got, err := os.ReadFile(fp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Fatalf("expected %s to hold %q, got %q", fp, want, got)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected no leftover files, got %d entries", len(entries))
+	}
+}
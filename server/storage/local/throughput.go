@@ -0,0 +1,48 @@
+package localstorage
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// throughputWeight is how much each write counts towards the rolling average
+// reported by WriteThroughput.
+const throughputWeight = 0.2
+
+// minThroughputSample is the smallest write that's measured, as the speed of
+// smaller ones mostly reflects per-write overhead.
+const minThroughputSample = 4 << 10
+
+// WriteThroughput returns a rolling average of how fast recent writes through
+// Put were stored, in bytes per second, to estimate how long an upload will
+// take. Writes smaller than a few kilobytes aren't measured. It fails with
+// storage.ErrNoThroughput until a write has been measured.
+func (lfs *LocalFileStore) WriteThroughput() (float64, error) {
+	bits := atomic.LoadUint64(&lfs.writeRate)
+	if bits == 0 {
+		return 0, storage.ErrNoThroughput
+	}
+	return math.Float64frombits(bits), nil
+}
+
+// recordWrite adds a write of n bytes that took d to the rolling average.
+func (lfs *LocalFileStore) recordWrite(n int64, d time.Duration) {
+	if n < minThroughputSample || d <= 0 {
+		return
+	}
+	rate := float64(n) / d.Seconds()
+	for {
+		old := atomic.LoadUint64(&lfs.writeRate)
+		next := rate
+		if old != 0 {
+			prev := math.Float64frombits(old)
+			next = prev + throughputWeight*(rate-prev)
+		}
+		if atomic.CompareAndSwapUint64(&lfs.writeRate, old, math.Float64bits(next)) {
+			return
+		}
+	}
+}
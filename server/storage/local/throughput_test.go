@@ -0,0 +1,67 @@
+package localstorage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+// pacedReader returns chunks of size bytes, waiting delay before each.
+type pacedReader struct {
+	chunks int
+	size   int
+	delay  time.Duration
+}
+
+func (pr *pacedReader) Read(p []byte) (int, error) {
+	if pr.chunks == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(pr.delay)
+	pr.chunks--
+	n := pr.size
+	if n > len(p) {
+		n = len(p)
+	}
+	copy(p, bytes.Repeat([]byte("x"), n))
+	return n, nil
+}
+
+func TestWriteThroughput(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lfs.WriteThroughput(); !errors.Is(err, storage.ErrNoThroughput) {
+		t.Fatalf("expected storage.ErrNoThroughput before any write, got %v", err)
+	}
+	// Too small to be measured.
+	if err := lfs.Put(charmID, "/small.txt", bytes.NewBufferString("hi"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lfs.WriteThroughput(); !errors.Is(err, storage.ErrNoThroughput) {
+		t.Fatalf("expected small writes not to be measured, got %v", err)
+	}
+
+	// 16KiB every 5ms is at most 3.2MB/s.
+	for i := 0; i < 5; i++ {
+		r := &pacedReader{chunks: 4, size: 16 << 10, delay: 5 * time.Millisecond}
+		if err := lfs.Put(charmID, "/paced.bin", r, fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rate, err := lfs.WriteThroughput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate <= 100<<10 || rate > 3.3e6 {
+		t.Fatalf("expected a throughput between 100KiB/s and 3.3MB/s, got %.0f bytes/s", rate)
+	}
+}
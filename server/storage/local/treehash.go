@@ -0,0 +1,64 @@
+package localstorage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// TreeHash returns a fingerprint of the file or directory at path for the
+// Charm ID that changes if any file below it is added, removed, renamed or
+// changed. It's a Merkle hash: files hash their size and checksum, and
+// directories hash the sorted names and hashes of their entries, so identical
+// trees have the same fingerprint wherever they're stored. Reserved entries
+// are left out.
+func (lfs *LocalFileStore) TreeHash(charmID, path string) (string, error) {
+	defer lfs.op()()
+	info, err := os.Stat(filepath.Join(lfs.root(), charmID, path))
+	if os.IsNotExist(err) {
+		return "", fs.ErrNotExist
+	}
+	if err != nil {
+		return "", err
+	}
+	sum, err := lfs.treeHash(charmID, path, info)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+func (lfs *LocalFileStore) treeHash(charmID, path string, info fs.FileInfo) ([]byte, error) {
+	h := sha256.New()
+	if !info.IsDir() {
+		sum, err := lfs.lockedChecksum(charmID, path)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(h, "file\x00%d\x00%s", info.Size(), sum)
+		return h.Sum(nil), nil
+	}
+	des, err := os.ReadDir(filepath.Join(lfs.root(), charmID, path))
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprint(h, "dir\x00")
+	for _, de := range des {
+		if isReserved(de.Name()) {
+			continue
+		}
+		ci, err := de.Info()
+		if err != nil {
+			return nil, err
+		}
+		sum, err := lfs.treeHash(charmID, filepath.Join(path, de.Name()), ci)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(h, "%s\x00%x\x00", de.Name(), sum)
+	}
+	return h.Sum(nil), nil
+}
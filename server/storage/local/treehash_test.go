@@ -0,0 +1,72 @@
+package localstorage
+
+import (
+	"bytes"
+	"io/fs"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestTreeHash(t *testing.T) {
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"/a.txt":         "hello",
+		"/foo/b.txt":     "hello world",
+		"/foo/bar/c.txt": "charm",
+	}
+	put := func(charmID, path, content string) {
+		t.Helper()
+		if err := lfs.Put(charmID, path, bytes.NewBufferString(content), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash := func(charmID, path string) string {
+		t.Helper()
+		h, err := lfs.TreeHash(charmID, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	one, two := uuid.New().String(), uuid.New().String()
+	for path, content := range files {
+		put(one, path, content)
+		put(two, path, content)
+	}
+	want := hash(one, "/")
+	if got := hash(two, "/"); got != want {
+		t.Fatalf("expected identical trees to hash equally, got %s and %s", want, got)
+	}
+	if hash(one, "/foo") == want {
+		t.Fatal("expected a subtree to hash differently from the whole tree")
+	}
+
+	changes := []struct {
+		name   string
+		change func(charmID string)
+	}{
+		{"modified", func(id string) { put(id, "/foo/bar/c.txt", "charm!") }},
+		{"added", func(id string) { put(id, "/foo/new.txt", "") }},
+		{"deleted", func(id string) {
+			if err := lfs.Delete(id, "/a.txt"); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, c := range changes {
+		t.Run(c.name, func(t *testing.T) {
+			id := uuid.New().String()
+			for path, content := range files {
+				put(id, path, content)
+			}
+			c.change(id)
+			if hash(id, "/") == want {
+				t.Fatalf("expected a %s file to change the hash", c.name)
+			}
+		})
+	}
+}
@@ -0,0 +1,345 @@
+package localstorage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// txDir is the top-level directory transaction writes are staged in.
+const txDir = ".transactions"
+
+// defaultTxTTL is how long staged transaction writes are kept when TxTTL
+// isn't set.
+const defaultTxTTL = 24 * time.Hour
+
+// Transaction starts a transaction for the Charm ID. See storage.Tx for the
+// atomicity guarantees. What was staged for transactions older than TxTTL is
+// swept first.
+func (lfs *LocalFileStore) Transaction(charmID string) (*storage.Tx, error) {
+	defer lfs.op()()
+	if err := storage.EnsureDir(filepath.Join(lfs.root(), txDir), 0o700); err != nil {
+		return nil, err
+	}
+	lfs.sweepTx() // nolint:errcheck
+	return storage.NewTx(charmID, lfs), nil
+}
+
+// sweepTx removes the writes staged, and the backups left by interrupted
+// commits, more than TxTTL ago.
+func (lfs *LocalFileStore) sweepTx() error {
+	lfs.txMu.Lock()
+	defer lfs.txMu.Unlock()
+	ttl := lfs.TxTTL
+	if ttl <= 0 {
+		ttl = defaultTxTTL
+	}
+	dir := filepath.Join(lfs.root(), txDir)
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-ttl)
+	for _, de := range des {
+		info, err := de.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, de.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StageTx implements storage.TxBackend.
+func (lfs *LocalFileStore) StageTx(charmID string, r io.Reader) (string, error) {
+	defer lfs.op()()
+	if lfs.MaxFileBytes > 0 {
+		r = io.LimitReader(r, lfs.MaxFileBytes+1)
+	}
+	f, err := os.CreateTemp(filepath.Join(lfs.root(), txDir), charmID+"-*")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close() // nolint:errcheck
+	n, err := lfs.copy(f, r)
+	if err == nil && lfs.MaxFileBytes > 0 && n > lfs.MaxFileBytes {
+		err = storage.ErrFileTooLarge
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		os.Remove(f.Name()) // nolint:errcheck
+		return "", err
+	}
+	return filepath.Base(f.Name()), nil
+}
+
+// CommitTx implements storage.TxBackend. Files are checked and stored like
+// they are by Put. Files replaced or deleted by the transaction are moved
+// aside until every operation succeeded, so a failed commit can put them back.
+func (lfs *LocalFileStore) CommitTx(charmID string, ops []storage.TxOp) error {
+	defer lfs.op()()
+	lfs.txMu.Lock()
+	defer lfs.txMu.Unlock()
+	defer lfs.resetUsage(charmID)
+
+	for _, op := range ops {
+		paths := []string{op.Path}
+		if op.Type == storage.TxMove {
+			paths = append(paths, op.To)
+		}
+		for _, p := range paths {
+			if cp := filepath.Clean("/" + p); cp == string(os.PathSeparator) || inReserved(cp) {
+				return fmt.Errorf("invalid path specified: %s", p)
+			}
+		}
+		if op.Type == storage.TxPut {
+			if err := lfs.sniffFile(lfs.stagedPath(op.Staged)); err != nil {
+				return err
+			}
+		}
+	}
+	targets := txTargets(ops)
+	for _, p := range targets {
+		defer lfs.lockPath(filepath.Join(lfs.root(), charmID, p))()
+	}
+	var usage, before int64
+	if lfs.QuotaBytes > 0 {
+		var err error
+		if usage, err = lfs.Usage(charmID); err != nil {
+			return err
+		}
+		if before, err = lfs.txSize(charmID, targets); err != nil {
+			return err
+		}
+	}
+
+	backup, err := os.MkdirTemp(filepath.Join(lfs.root(), txDir), charmID+"-backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(backup) // nolint:errcheck
+	var undos []func()
+	undo := func() {
+		for i := len(undos) - 1; i >= 0; i-- {
+			undos[i]()
+		}
+	}
+	// aside moves whatever is at fp into the backup directory.
+	aside := func(fp string) error {
+		if _, err := os.Lstat(fp); os.IsNotExist(err) {
+			return nil
+		}
+		bp := filepath.Join(backup, strconv.Itoa(len(undos)))
+		if err := os.Rename(fp, bp); err != nil {
+			return err
+		}
+		undos = append(undos, func() {
+			os.RemoveAll(fp)  // nolint:errcheck
+			os.Rename(bp, fp) // nolint:errcheck
+		})
+		return nil
+	}
+	place := func(src, dst string) error {
+		if err := os.Rename(src, dst); err != nil {
+			return err
+		}
+		undos = append(undos, func() {
+			os.Rename(dst, src) // nolint:errcheck
+		})
+		return nil
+	}
+	apply := func(op storage.TxOp) error {
+		fp := filepath.Join(lfs.root(), charmID, op.Path)
+		switch op.Type {
+		case storage.TxPut:
+			sp := lfs.stagedPath(op.Staged)
+			mode := op.Mode
+			if mode == 0 {
+				mode = defaultFileMode(fp)
+			}
+			if err := lfs.prepareTarget(charmID, op.Path, fp, mode); err != nil {
+				return err
+			}
+			if lfs.Compress {
+				if _, err := compressFile(sp); err != nil {
+					return err
+				}
+			}
+			if err := os.Chmod(sp, mode); err != nil && !lfs.IgnoreChmodErrors {
+				return err
+			}
+			if err := aside(fp); err != nil {
+				return err
+			}
+			return place(sp, fp)
+		case storage.TxDelete:
+			return aside(fp)
+		case storage.TxMove:
+			to := filepath.Join(lfs.root(), charmID, op.To)
+			info, err := os.Lstat(fp)
+			if err != nil {
+				return err
+			}
+			if err := lfs.prepareTarget(charmID, op.To, to, info.Mode()); err != nil {
+				return err
+			}
+			if err := aside(to); err != nil {
+				return err
+			}
+			return place(fp, to)
+		}
+		return fmt.Errorf("unknown transaction operation: %d", op.Type)
+	}
+	for _, op := range ops {
+		if err := apply(op); err != nil {
+			undo()
+			return err
+		}
+	}
+	// Bytes freed by the files replaced or deleted count towards the quota.
+	if lfs.QuotaBytes > 0 {
+		after, err := lfs.txSize(charmID, targets)
+		if err != nil {
+			undo()
+			return err
+		}
+		lfs.quotaMu.Lock()
+		reserved := lfs.reserved(charmID)
+		lfs.quotaMu.Unlock()
+		if usage+reserved+after-before > lfs.QuotaBytes {
+			undo()
+			return storage.ErrQuotaExceeded
+		}
+	}
+	if err := lfs.commitTxChecksums(charmID, ops); err != nil {
+		return err
+	}
+	lfs.touch(charmID) // nolint:errcheck
+	for _, op := range ops {
+		lfs.bumpGenerations(charmID, op.Path) // nolint:errcheck
+		if op.Type == storage.TxMove {
+			lfs.bumpGenerations(charmID, op.To) // nolint:errcheck
+		}
+	}
+	return nil
+}
+
+// txTargets returns the paths the operations write to or delete, sorted so
+// they're always locked in the same order.
+func txTargets(ops []storage.TxOp) []string {
+	seen := make(map[string]bool)
+	for _, op := range ops {
+		seen[filepath.Clean("/"+op.Path)] = true
+		if op.Type == storage.TxMove {
+			seen[filepath.Clean("/"+op.To)] = true
+		}
+	}
+	targets := make([]string, 0, len(seen))
+	for p := range seen {
+		targets = append(targets, p)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// txSize returns the bytes stored at the targets of a transaction for the
+// Charm ID. Targets below another target are counted with it.
+func (lfs *LocalFileStore) txSize(charmID string, targets []string) (int64, error) {
+	var n int64
+outer:
+	for _, p := range targets {
+		for _, q := range targets {
+			if strings.HasPrefix(p, q+string(os.PathSeparator)) {
+				continue outer
+			}
+		}
+		info, err := os.Lstat(filepath.Join(lfs.root(), charmID, p))
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return 0, err
+		case info.IsDir():
+			size, err := lfs.dirSize(charmID, p)
+			if err != nil {
+				return 0, err
+			}
+			n += size
+		case info.Mode().IsRegular():
+			n += info.Size()
+		}
+	}
+	return n, nil
+}
+
+// commitTxChecksums brings the recorded checksums in line with the applied
+// operations.
+func (lfs *LocalFileStore) commitTxChecksums(charmID string, ops []storage.TxOp) error {
+	for _, op := range ops {
+		cp := lfs.checksumPath(charmID, op.Path)
+		switch op.Type {
+		case storage.TxPut, storage.TxDelete:
+			if err := os.RemoveAll(cp); err != nil {
+				return err
+			}
+		case storage.TxMove:
+			to := lfs.checksumPath(charmID, op.To)
+			if err := os.RemoveAll(to); err != nil {
+				return err
+			}
+			if err := storage.EnsureDir(filepath.Dir(to), 0o700); err != nil {
+				return err
+			}
+			if err := os.Rename(cp, to); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	for _, op := range ops {
+		path := op.Path
+		switch op.Type {
+		case storage.TxDelete:
+			continue
+		case storage.TxMove:
+			// A file put earlier in the transaction has no checksum to move.
+			info, err := os.Lstat(filepath.Join(lfs.root(), charmID, op.To))
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			path = op.To
+		}
+		// Missing files were moved or deleted later in the transaction.
+		if _, err := lfs.checksum(charmID, path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// DiscardTx implements storage.TxBackend.
+func (lfs *LocalFileStore) DiscardTx(charmID string, ops []storage.TxOp) error {
+	defer lfs.op()()
+	for _, op := range ops {
+		if op.Type != storage.TxPut {
+			continue
+		}
+		if err := os.Remove(lfs.stagedPath(op.Staged)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (lfs *LocalFileStore) stagedPath(staged string) string {
+	return filepath.Join(lfs.root(), txDir, filepath.Base(staged))
+}
@@ -0,0 +1,253 @@
+package localstorage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+func TestTransaction(t *testing.T) {
+	setup := func(t *testing.T) (*LocalFileStore, string) {
+		t.Helper()
+		lfs, err := NewLocalFileStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		charmID := uuid.New().String()
+		for path, content := range map[string]string{"/a.txt": "a", "/b.txt": "b", "/c.txt": "c"} {
+			if err := lfs.Put(charmID, path, bytes.NewBufferString(content), fs.FileMode(0o644)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return lfs, charmID
+	}
+	contents := func(t *testing.T, lfs *LocalFileStore, charmID string) map[string]string {
+		t.Helper()
+		m := map[string]string{}
+		des, err := os.ReadDir(filepath.Join(lfs.Path, charmID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, de := range des {
+			b, err := os.ReadFile(filepath.Join(lfs.Path, charmID, de.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			m[de.Name()] = string(b)
+		}
+		return m
+	}
+	assertContents := func(t *testing.T, got, want map[string]string) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+		for k, v := range want {
+			if got[k] != v {
+				t.Fatalf("expected %v, got %v", want, got)
+			}
+		}
+	}
+	stage := func(t *testing.T, tx *storage.Tx) {
+		t.Helper()
+		if err := tx.Put("/a.txt", bytes.NewBufferString("A"), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Put("/d.txt", bytes.NewBufferString("d"), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Delete("/b.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Move("/c.txt", "/e.txt"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("commit", func(t *testing.T) {
+		lfs, charmID := setup(t)
+		tx, err := lfs.Transaction(charmID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stage(t, tx)
+		assertContents(t, contents(t, lfs, charmID), map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c"})
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("expected no error committing, %v", err)
+		}
+		assertContents(t, contents(t, lfs, charmID), map[string]string{"a.txt": "A", "d.txt": "d", "e.txt": "c"})
+		if err := tx.Commit(); !errors.Is(err, storage.ErrTxDone) {
+			t.Fatalf("expected ErrTxDone, got %v", err)
+		}
+		sum, err := lfs.Checksum(charmID, "/a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, _ := lfs.checksumFile(filepath.Join(lfs.Path, charmID, "a.txt"), SHA256, nil); sum != want {
+			t.Fatalf("expected checksum of a.txt to be updated")
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		lfs, charmID := setup(t)
+		tx, err := lfs.Transaction(charmID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stage(t, tx)
+		if err := tx.Rollback(); err != nil {
+			t.Fatalf("expected no error rolling back, %v", err)
+		}
+		assertContents(t, contents(t, lfs, charmID), map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c"})
+		des, err := os.ReadDir(filepath.Join(lfs.Path, txDir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(des) != 0 {
+			t.Fatalf("expected staged data to be removed, got %v", des)
+		}
+	})
+
+	t.Run("failed commit", func(t *testing.T) {
+		lfs, charmID := setup(t)
+		tx, err := lfs.Transaction(charmID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stage(t, tx)
+		if err := tx.Move("/missing.txt", "/f.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected commit to fail on the missing file, got %v", err)
+		}
+		assertContents(t, contents(t, lfs, charmID), map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c"})
+	})
+	t.Run("put checks", func(t *testing.T) {
+		probe := probeCaseInsensitive
+		defer func() { probeCaseInsensitive = probe }()
+		probeCaseInsensitive = func(string) (bool, error) { return true, nil }
+		lfs, charmID := setup(t)
+		tx, err := lfs.Transaction(charmID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Put("/A.txt", bytes.NewBufferString("A"), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); !errors.Is(err, storage.ErrCaseConflict) {
+			t.Fatalf("expected ErrCaseConflict, got %v", err)
+		}
+		assertContents(t, contents(t, lfs, charmID), map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c"})
+	})
+
+	t.Run("compress", func(t *testing.T) {
+		lfs, charmID := setup(t)
+		lfs.Compress = true
+		tx, err := lfs.Transaction(charmID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stage(t, tx)
+		if err := tx.Move("/a.txt", "/f.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("expected no error committing, %v", err)
+		}
+		for path, want := range map[string]string{"/d.txt": "d", "/f.txt": "A"} {
+			if _, ok, err := lfs.logicalSize(filepath.Join(lfs.Path, charmID, path)); err != nil || !ok {
+				t.Fatalf("expected %s to be stored compressed, got %v, %v", path, ok, err)
+			}
+			f, err := lfs.Get(charmID, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(f)
+			f.Close() // nolint:errcheck
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != want {
+				t.Fatalf("expected %s to read %q, got %q", path, want, b)
+			}
+			if ok, err := lfs.Verify(charmID, path); err != nil || !ok {
+				t.Fatalf("expected %s to verify, got %v, %v", path, ok, err)
+			}
+		}
+	})
+
+	t.Run("quota", func(t *testing.T) {
+		lfs, charmID := setup(t)
+		lfs.QuotaBytes = 3
+		tx, err := lfs.Transaction(charmID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Put("/a.txt", bytes.NewBufferString("A"), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Delete("/b.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Put("/d.txt", bytes.NewBufferString("d"), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("expected replaced and deleted bytes to make room, %v", err)
+		}
+		tx, err = lfs.Transaction(charmID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Put("/e.txt", bytes.NewBufferString("e"), fs.FileMode(0o644)); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); !errors.Is(err, storage.ErrQuotaExceeded) {
+			t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+		}
+		assertContents(t, contents(t, lfs, charmID), map[string]string{"a.txt": "A", "c.txt": "c", "d.txt": "d"})
+	})
+
+	t.Run("sweep", func(t *testing.T) {
+		lfs, charmID := setup(t)
+		lfs.TxTTL = time.Hour
+		tx, err := lfs.Transaction(charmID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stage(t, tx)
+		des, err := os.ReadDir(filepath.Join(lfs.Path, txDir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		old := time.Now().Add(-2 * time.Hour)
+		for _, de := range des {
+			if err := os.Chtimes(filepath.Join(lfs.Path, txDir, de.Name()), old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := lfs.Transaction(charmID); err != nil {
+			t.Fatal(err)
+		}
+		des, err = os.ReadDir(filepath.Join(lfs.Path, txDir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(des) != 0 {
+			t.Fatalf("expected abandoned staged data to be swept, got %v", des)
+		}
+		if err := tx.Commit(); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected commit of a swept transaction to fail, got %v", err)
+		}
+		assertContents(t, contents(t, lfs, charmID), map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c"})
+	})
+}
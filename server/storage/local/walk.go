@@ -0,0 +1,113 @@
+package localstorage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// walk calls fn for every file and directory below path for the Charm ID,
+// skipping reserved entries. The rel path passed to fn is relative to path and
+// uses forward slashes. Symbolic links to files and directories within the
+// Charm ID directory are followed; fn is passed the entry of their target. A
+// link leading back to a directory being walked fails with
+// storage.ErrSymlinkLoop. Links pointing elsewhere are passed to fn as is. fn
+// may return filepath.SkipDir to skip a directory's contents.
+func (lfs *LocalFileStore) walk(charmID, path string, fn func(rel string, d fs.DirEntry) error) error {
+	root := filepath.Join(lfs.root(), charmID, path)
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return nil
+	}
+	base, err := filepath.EvalSymlinks(filepath.Join(lfs.root(), charmID))
+	if err != nil {
+		return err
+	}
+	w := &walker{base: base, fn: fn}
+	return w.walkDir(root, "", []fs.FileInfo{info})
+}
+
+// walker follows symbolic links during a walk.
+type walker struct {
+	base string
+	fn   func(rel string, d fs.DirEntry) error
+}
+
+// walkDir walks the directory at dir, whose path relative to the walk's root
+// is rel. stack holds the directories from the root down to dir.
+func (w *walker) walkDir(dir, rel string, stack []fs.FileInfo) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, d := range entries {
+		if isReserved(d.Name()) {
+			continue
+		}
+		fp := filepath.Join(dir, d.Name())
+		r := path.Join(rel, d.Name())
+		if d.Type()&fs.ModeSymlink != 0 {
+			info, err := w.follow(fp)
+			if err != nil {
+				return fmt.Errorf("%s: %w", r, err)
+			}
+			if info != nil {
+				d = fs.FileInfoToDirEntry(info)
+			}
+		}
+		if err := w.fn(r, d); err == filepath.SkipDir && d.IsDir() {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		for _, anc := range stack {
+			if os.SameFile(anc, info) {
+				return fmt.Errorf("%s: %w", r, storage.ErrSymlinkLoop)
+			}
+		}
+		if err := w.walkDir(fp, r, append(stack, info)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// follow returns the fs.FileInfo of the target of the symbolic link at fp, or
+// nil if the link is dangling or points outside the Charm ID directory.
+func (w *walker) follow(fp string) (fs.FileInfo, error) {
+	info, err := os.Stat(fp)
+	if errors.Is(err, syscall.ELOOP) {
+		return nil, storage.ErrSymlinkLoop
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	target, err := filepath.EvalSymlinks(fp)
+	if err != nil {
+		return nil, err
+	}
+	if target != w.base && !strings.HasPrefix(target, w.base+string(os.PathSeparator)) {
+		return nil, nil
+	}
+	return info, nil
+}
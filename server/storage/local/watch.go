@@ -0,0 +1,216 @@
+package localstorage
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchCoalesce is how long Watch collects events once one is pending before
+// emitting the changes they describe.
+const watchCoalesce = 100 * time.Millisecond
+
+// Watch emits a storage.Change whenever a file or directory below path for the
+// Charm ID is added, modified or deleted, until ctx is done, at which point
+// the channel is closed. Bursts of events on the same path, such as a write
+// followed by a chmod, are coalesced into a single change, and a path added
+// and deleted again before its change is emitted isn't reported at all.
+// Changes are emitted at most watchCoalesce after the first, however many
+// events follow. Change paths are
+// relative to the Charm ID directory, like those of Diff. Reserved entries are
+// ignored.
+func (lfs *LocalFileStore) Watch(ctx context.Context, charmID, path string) (<-chan storage.Change, error) {
+	defer lfs.op()()
+	if err := lfs.requireDir(charmID, path); err != nil {
+		return nil, err
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	cw := &changeWatcher{
+		root:    filepath.Join(lfs.root(), charmID),
+		w:       w,
+		known:   make(map[string]bool),
+		pending: make(map[string]storage.Change),
+	}
+	if err := cw.add(filepath.Join(cw.root, path), nil); err != nil {
+		w.Close() // nolint:errcheck
+		return nil, err
+	}
+	ch := make(chan storage.Change)
+	go cw.run(ctx, ch)
+	return ch, nil
+}
+
+// changeWatcher turns fsnotify events into storage.Changes.
+type changeWatcher struct {
+	root    string
+	w       *fsnotify.Watcher
+	known   map[string]bool
+	pending map[string]storage.Change
+	order   []string
+}
+
+// add watches the directory at dir and every directory below it, recording
+// the entries that already exist. found is called with entries below dir that
+// weren't known yet.
+func (cw *changeWatcher) add(dir string, found func(fp string, isDir bool)) error {
+	return filepath.WalkDir(dir, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if fp != dir && isReserved(d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fp != dir && !cw.known[fp] && found != nil {
+			found(fp, d.IsDir())
+		}
+		cw.known[fp] = true
+		if d.IsDir() {
+			return cw.w.Add(fp)
+		}
+		return nil
+	})
+}
+
+func (cw *changeWatcher) run(ctx context.Context, ch chan<- storage.Change) {
+	defer close(ch)
+	defer cw.w.Close() // nolint:errcheck
+	timer := time.NewTimer(watchCoalesce)
+	timer.Stop()
+	// The timer isn't pushed back by later events, so a steady stream of
+	// them is still emitted.
+	armed := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-cw.w.Events:
+			if !ok {
+				return
+			}
+			if cw.record(ev) && !armed {
+				timer.Reset(watchCoalesce)
+				armed = true
+			}
+		case _, ok := <-cw.w.Errors:
+			if !ok {
+				return
+			}
+		case <-timer.C:
+			armed = false
+			for _, p := range cw.order {
+				select {
+				case ch <- cw.pending[p]:
+				case <-ctx.Done():
+					return
+				}
+			}
+			cw.order = cw.order[:0]
+			cw.pending = make(map[string]storage.Change)
+		}
+	}
+}
+
+// record adds the change described by ev to the pending changes and reports
+// whether there was one.
+func (cw *changeWatcher) record(ev fsnotify.Event) bool {
+	rel, err := filepath.Rel(cw.root, ev.Name)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	for _, name := range strings.Split(filepath.ToSlash(rel), "/") {
+		if isReserved(name) {
+			return false
+		}
+	}
+	c := storage.Change{Path: filepath.ToSlash(rel)}
+	switch {
+	case ev.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+		if !cw.known[ev.Name] {
+			return false
+		}
+		for fp := range cw.known {
+			if fp == ev.Name || strings.HasPrefix(fp, ev.Name+string(os.PathSeparator)) {
+				delete(cw.known, fp)
+			}
+		}
+		c.Type = storage.ChangeDeleted
+	case ev.Op&fsnotify.Create != 0:
+		c.Type = storage.ChangeAdded
+		if cw.known[ev.Name] {
+			c.Type = storage.ChangeModified
+		}
+		cw.known[ev.Name] = true
+		if info, err := os.Lstat(ev.Name); err == nil && info.IsDir() {
+			c.IsDir = true
+			cw.push(c)
+			// Entries created before the directory was watched have no
+			// events of their own.
+			cw.add(ev.Name, func(fp string, isDir bool) { // nolint:errcheck
+				if rel, err := filepath.Rel(cw.root, fp); err == nil {
+					cw.push(storage.Change{Type: storage.ChangeAdded, Path: filepath.ToSlash(rel), IsDir: isDir})
+				}
+			})
+			return true
+		}
+	case ev.Op&(fsnotify.Write|fsnotify.Chmod) != 0:
+		c.Type = storage.ChangeModified
+	default:
+		return false
+	}
+	cw.push(c)
+	return true
+}
+
+// push adds c to the pending changes, coalescing it with a change already
+// pending for its path.
+func (cw *changeWatcher) push(c storage.Change) {
+	prev, ok := cw.pending[c.Path]
+	if ok && prev.Type == storage.ChangeAdded && c.Type == storage.ChangeDeleted {
+		cw.drop(c.Path)
+		return
+	}
+	if !ok {
+		cw.order = append(cw.order, c.Path)
+	} else {
+		c.Type = coalesce(prev.Type, c.Type)
+		c.IsDir = c.IsDir || prev.IsDir
+	}
+	cw.pending[c.Path] = c
+}
+
+// drop removes the pending changes to path and to the entries below it.
+func (cw *changeWatcher) drop(path string) {
+	order := cw.order[:0]
+	for _, p := range cw.order {
+		if p == path || strings.HasPrefix(p, path+"/") {
+			delete(cw.pending, p)
+			continue
+		}
+		order = append(order, p)
+	}
+	cw.order = order
+}
+
+// coalesce returns the change type equivalent to the change prev followed by
+// next. An addition followed by a deletion is dropped by push instead.
+func coalesce(prev, next storage.ChangeType) storage.ChangeType {
+	switch {
+	case prev == storage.ChangeAdded:
+		return storage.ChangeAdded
+	case prev == storage.ChangeDeleted && next == storage.ChangeAdded:
+		return storage.ChangeModified
+	}
+	return next
+}
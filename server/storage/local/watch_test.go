@@ -0,0 +1,131 @@
+package localstorage
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+func TestWatch(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/docs", nil, fs.ModeDir|0o755); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := lfs.Watch(ctx, charmID, "/")
+	if err != nil {
+		t.Fatalf("expected no error watching, %v", err)
+	}
+	expect := func(ct storage.ChangeType, path string) {
+		t.Helper()
+		select {
+		case c := <-ch:
+			if c.Type != ct || c.Path != path {
+				t.Fatalf("expected %s %s, got %s %s", ct, path, c.Type, c.Path)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s %s", ct, path)
+		}
+	}
+
+	if err := lfs.Put(charmID, "/docs/a.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	expect(storage.ChangeAdded, "docs/a.txt")
+
+	if err := lfs.Put(charmID, "/docs/a.txt", bytes.NewBufferString("hello world"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	expect(storage.ChangeModified, "docs/a.txt")
+
+	// A burst of writes is a single change.
+	fp := filepath.Join(lfs.Path, charmID, "docs", "a.txt")
+	for i := 0; i < 5; i++ {
+		if err := os.WriteFile(fp, []byte("again"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect(storage.ChangeModified, "docs/a.txt")
+
+	if err := lfs.Delete(charmID, "/docs/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	expect(storage.ChangeDeleted, "docs/a.txt")
+
+	// Something added and deleted again before it's emitted never changed.
+	flash := filepath.Join(lfs.Path, charmID, "docs", "flash.txt")
+	if err := os.WriteFile(flash, []byte("gone"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(flash); err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/docs/b.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	expect(storage.ChangeAdded, "docs/b.txt")
+
+	// A steady stream of writes is still emitted while it goes on.
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		bp := filepath.Join(lfs.Path, charmID, "docs", "b.txt")
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(watchCoalesce / 10):
+			}
+			os.WriteFile(bp, []byte("busy"), 0o644) // nolint:errcheck
+		}
+	}()
+	select {
+	case c := <-ch:
+		if c.Type != storage.ChangeModified || c.Path != "docs/b.txt" {
+			t.Fatalf("expected %s docs/b.txt, got %s %s", storage.ChangeModified, c.Type, c.Path)
+		}
+	case <-time.After(10 * watchCoalesce):
+		t.Fatal("expected a change while writes kept coming")
+	}
+	close(stop)
+	<-done
+	for quiet := false; !quiet; {
+		select {
+		case <-ch:
+		case <-time.After(3 * watchCoalesce):
+			quiet = true
+		}
+	}
+
+	if err := lfs.PutScratch(charmID, "/tmp.txt", bytes.NewBufferString("scratch"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case c := <-ch:
+		t.Fatalf("expected reserved entries to be ignored, got %s %s", c.Type, c.Path)
+	case <-time.After(3 * watchCoalesce):
+	}
+
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("expected no more changes after cancellation")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected channel to be closed after cancellation")
+	}
+}
@@ -0,0 +1,24 @@
+package localstorage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// xattrNamespace is the namespace of the extended attributes clients may set
+// and read. The security, trusted and system namespaces are used by the
+// kernel and security modules, so clients mustn't control them.
+const xattrNamespace = "user."
+
+// checkXattrs fails with storage.ErrXattrNotAllowed unless every attribute is
+// in the user namespace.
+func checkXattrs(attrs map[string][]byte) error {
+	for name := range attrs {
+		if !strings.HasPrefix(name, xattrNamespace) || len(name) == len(xattrNamespace) {
+			return fmt.Errorf("%s: %w", name, storage.ErrXattrNotAllowed)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package localstorage
+
+// getXattrs is a no-op on platforms without extended attribute support.
+func getXattrs(path string) (map[string][]byte, error) {
+	return nil, nil
+}
+
+// setXattrs is a no-op on platforms without extended attribute support.
+func setXattrs(path string, attrs map[string][]byte) error {
+	return nil
+}
@@ -0,0 +1,85 @@
+package localstorage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+
+	charmfs "github.com/charmbracelet/charm/fs"
+	"github.com/charmbracelet/charm/server/storage"
+	"github.com/google/uuid"
+)
+
+func TestPutXattrs(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]byte{
+		"user.charm.tag":   []byte("blue"),
+		"user.charm.label": []byte("important"),
+	}
+	opts := storage.PutOptions{Xattrs: want}
+	if err := lfs.PutWithOptions(charmID, "/hello.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644), opts); err != nil {
+		t.Fatalf("expected no error when putting file with xattrs, %v", err)
+	}
+
+	info, err := lfs.Stat(charmID, "/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := info.(*charmfs.FileInfo).FileInfo.Xattrs
+	if len(got) == 0 {
+		t.Skip("extended attributes not supported")
+	}
+	for name, val := range want {
+		if !bytes.Equal(got[name], val) {
+			t.Fatalf("expected xattr %s to be %q, got %q", name, val, got[name])
+		}
+	}
+}
+
+func TestPutXattrsRejected(t *testing.T) {
+	charmID := uuid.New().String()
+	lfs, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.Put(charmID, "/hello.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	assertUnchanged := func() {
+		t.Helper()
+		f, err := lfs.Get(charmID, "/hello.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close() // nolint:errcheck
+		b, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "hello" {
+			t.Fatalf("expected the file to be left as it was, got %q", b)
+		}
+	}
+	for _, name := range []string{"security.selinux", "trusted.overlay.opaque", "system.posix_acl_access", "user.", "charm"} {
+		opts := storage.PutOptions{Xattrs: map[string][]byte{name: []byte("x")}}
+		err := lfs.PutWithOptions(charmID, "/hello.txt", bytes.NewBufferString("replaced"), fs.FileMode(0o644), opts)
+		if !errors.Is(err, storage.ErrXattrNotAllowed) {
+			t.Fatalf("expected ErrXattrNotAllowed for %s, got %v", name, err)
+		}
+		assertUnchanged()
+	}
+
+	// An attribute the file system refuses fails the write before it's
+	// committed.
+	opts := storage.PutOptions{Xattrs: map[string][]byte{"user.charm.big": bytes.Repeat([]byte("x"), 1<<20)}}
+	if err := lfs.PutWithOptions(charmID, "/hello.txt", bytes.NewBufferString("replaced"), fs.FileMode(0o644), opts); err == nil {
+		t.Skip("file system accepted an oversized extended attribute")
+	}
+	assertUnchanged()
+}
@@ -0,0 +1,61 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package localstorage
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// getXattrs returns the extended attributes in the user namespace set on the
+// file at path. Nil is returned if the file system doesn't support extended
+// attributes.
+func getXattrs(path string) (map[string][]byte, error) {
+	sz, err := unix.Listxattr(path, nil)
+	if err != nil || sz == 0 {
+		return nil, ignoreUnsupported(err)
+	}
+	buf := make([]byte, sz)
+	sz, err = unix.Listxattr(path, buf)
+	if err != nil {
+		return nil, ignoreUnsupported(err)
+	}
+	attrs := make(map[string][]byte)
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:sz]), "\x00"), "\x00") {
+		if !strings.HasPrefix(name, xattrNamespace) {
+			continue
+		}
+		vsz, err := unix.Getxattr(path, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		val := make([]byte, vsz)
+		vsz, err = unix.Getxattr(path, name, val)
+		if err != nil {
+			return nil, err
+		}
+		attrs[name] = val[:vsz]
+	}
+	return attrs, nil
+}
+
+// setXattrs sets the extended attributes on the file at path. Attributes are
+// silently dropped if the file system doesn't support them.
+func setXattrs(path string, attrs map[string][]byte) error {
+	for name, val := range attrs {
+		if err := unix.Setxattr(path, name, val, 0); err != nil {
+			return ignoreUnsupported(err)
+		}
+	}
+	return nil
+}
+
+func ignoreUnsupported(err error) error {
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,201 @@
+package memstorage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	charmfs "github.com/charmbracelet/charm/fs"
+	charm "github.com/charmbracelet/charm/proto"
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+// MemFileStore is a FileStore implementation that keeps files in memory. It's
+// meant for tests, so it isn't registered with storage.Open.
+type MemFileStore struct {
+	mu    sync.RWMutex
+	files map[string]*memFile
+}
+
+type memFile struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// NewMemFileStore creates an empty in-memory FileStore.
+func NewMemFileStore() *MemFileStore {
+	return &MemFileStore{files: make(map[string]*memFile)}
+}
+
+// Stat returns the FileInfo for the given Charm ID and path.
+func (mfs *MemFileStore) Stat(charmID, path string) (fs.FileInfo, error) {
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+	fin, err := mfs.stat(key(charmID, path))
+	if err != nil {
+		return nil, err
+	}
+	return &charmfs.FileInfo{FileInfo: fin}, nil
+}
+
+// Get returns an fs.File for the given Charm ID and path.
+func (mfs *MemFileStore) Get(charmID string, path string) (fs.File, error) {
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+	k := key(charmID, path)
+	fin, err := mfs.stat(k)
+	if err != nil {
+		return nil, err
+	}
+	info := &charmfs.FileInfo{FileInfo: fin}
+	if !fin.IsDir {
+		return &file{Reader: bytes.NewReader(mfs.files[k].data), info: info}, nil
+	}
+	dir := fin
+	dir.Size = 0
+	dir.Files = make([]charm.FileInfo, 0)
+	for _, name := range mfs.children(k) {
+		child, err := mfs.stat(k + "/" + name)
+		if err != nil {
+			return nil, err
+		}
+		dir.Files = append(dir.Files, child)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := json.NewEncoder(buf).Encode(dir); err != nil {
+		return nil, err
+	}
+	return &charmfs.DirFile{
+		Buffer:   buf,
+		FileInfo: info,
+	}, nil
+}
+
+// Put reads from the provided io.Reader and stores the data with the Charm ID
+// and path.
+func (mfs *MemFileStore) Put(charmID string, path string, r io.Reader, mode fs.FileMode) error {
+	k := key(charmID, path)
+	if k == key(charmID, "") {
+		return fmt.Errorf("invalid path specified: %s", path)
+	}
+	f := &memFile{mode: mode, modTime: time.Now()}
+	if mode.IsDir() {
+		f.mode = mode | fs.ModeDir
+	} else {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		f.data = data
+		if mode == 0 {
+			f.mode = 0o644
+		}
+	}
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+	if old, ok := mfs.files[k]; ok && old.mode.IsDir() && !mode.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	mfs.files[k] = f
+	return nil
+}
+
+// Delete deletes the file or directory at the given path for the provided
+// Charm ID.
+func (mfs *MemFileStore) Delete(charmID string, path string) error {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+	k := key(charmID, path)
+	if k == charmID {
+		return storage.ErrInvalidPath
+	}
+	for fk := range mfs.files {
+		if fk == k || strings.HasPrefix(fk, k+"/") {
+			delete(mfs.files, fk)
+		}
+	}
+	return nil
+}
+
+// CopyFrom copies the file or directory at srcPath for srcCharmID in the src
+// FileStore to the given path for the Charm ID.
+func (mfs *MemFileStore) CopyFrom(charmID string, path string, src storage.FileStore, srcCharmID string, srcPath string) error {
+	return storage.Copy(mfs, charmID, path, src, srcCharmID, srcPath)
+}
+
+// stat returns the FileInfo for the key. Directories exist implicitly if any
+// file is stored below them.
+func (mfs *MemFileStore) stat(k string) (charm.FileInfo, error) {
+	if f, ok := mfs.files[k]; ok {
+		return charm.FileInfo{
+			Name:    path.Base(k),
+			IsDir:   f.mode.IsDir(),
+			Size:    int64(len(f.data)),
+			ModTime: f.modTime,
+			Mode:    f.mode,
+		}, nil
+	}
+	fin := charm.FileInfo{
+		Name:  path.Base(k),
+		IsDir: true,
+		Mode:  fs.ModeDir | 0o700,
+	}
+	found := false
+	for fk, f := range mfs.files {
+		if strings.HasPrefix(fk, k+"/") {
+			found = true
+			fin.Size += int64(len(f.data))
+			if f.modTime.After(fin.ModTime) {
+				fin.ModTime = f.modTime
+			}
+		}
+	}
+	if !found {
+		return fin, fs.ErrNotExist
+	}
+	return fin, nil
+}
+
+// children returns the sorted names of the entries directly below the key.
+func (mfs *MemFileStore) children(k string) []string {
+	seen := make(map[string]bool)
+	for fk := range mfs.files {
+		if !strings.HasPrefix(fk, k+"/") {
+			continue
+		}
+		name := strings.SplitN(strings.TrimPrefix(fk, k+"/"), "/", 2)[0]
+		seen[name] = true
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func key(charmID, p string) string {
+	return path.Join(charmID, path.Clean("/"+p))
+}
+
+// file is the fs.File returned for stored files.
+type file struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) Close() error {
+	return nil
+}
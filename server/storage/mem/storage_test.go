@@ -0,0 +1,64 @@
+package memstorage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+
+	charm "github.com/charmbracelet/charm/proto"
+	"github.com/charmbracelet/charm/server/storage"
+)
+
+func TestMemFileStore(t *testing.T) {
+	mfs := NewMemFileStore()
+	if err := mfs.Put("id", "/", bytes.NewBufferString(""), fs.FileMode(0o644)); err == nil {
+		t.Fatalf("expected error when file path is /")
+	}
+	if err := mfs.Put("id", "/foo/hello.txt", bytes.NewBufferString("hello"), fs.FileMode(0o600)); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := mfs.Get("id", "foo/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("expected content to be hello, got %s", string(b))
+	}
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0o600 || info.Size() != 5 {
+		t.Fatalf("expected a 5 byte file with mode 0600, got %v %d", info.Mode(), info.Size())
+	}
+
+	d, err := mfs.Get("id", "/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dir charm.FileInfo
+	if err := json.NewDecoder(d).Decode(&dir); err != nil {
+		t.Fatal(err)
+	}
+	if !dir.IsDir || len(dir.Files) != 1 || dir.Files[0].Name != "hello.txt" {
+		t.Fatalf("expected foo to list hello.txt, got %+v", dir)
+	}
+
+	if err := mfs.Delete("id", ""); !errors.Is(err, storage.ErrInvalidPath) {
+		t.Fatalf("expected ErrInvalidPath deleting the root, got %v", err)
+	}
+	if err := mfs.Delete("id", "/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Stat("id", "/foo/hello.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist after delete, got %v", err)
+	}
+}
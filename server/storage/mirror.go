@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+
+	charm "github.com/charmbracelet/charm/proto"
+)
+
+// MirrorFileStore is a FileStore that writes every file to a primary
+// FileStore and to each of its secondaries, and reads from the primary. Files
+// are buffered in memory while they're written, so they can be replayed to
+// each store.
+type MirrorFileStore struct {
+	// FileStore is the primary store.
+	FileStore
+	// Secondaries are the stores writes are mirrored to.
+	Secondaries []FileStore
+	// ReadFallback makes Stat and Get read from the secondaries, in order,
+	// when the primary fails with an error other than fs.ErrNotExist, such
+	// as when it's temporarily unavailable. The data read may be stale if a
+	// write reached the primary but not the secondary. A file that doesn't
+	// exist on the primary is reported as not existing.
+	ReadFallback bool
+}
+
+// NewMirrorFileStore returns a MirrorFileStore reading from primary and
+// writing to primary and every secondary.
+func NewMirrorFileStore(primary FileStore, secondaries ...FileStore) *MirrorFileStore {
+	return &MirrorFileStore{FileStore: primary, Secondaries: secondaries}
+}
+
+// Stat returns the fs.FileInfo of the file at path for the Charm ID.
+func (mfs *MirrorFileStore) Stat(charmID string, path string) (fs.FileInfo, error) {
+	info, err := mfs.FileStore.Stat(charmID, path)
+	if err == nil || !mfs.fallback(err) {
+		return info, err
+	}
+	for _, s := range mfs.Secondaries {
+		if info, serr := s.Stat(charmID, path); serr == nil {
+			return info, nil
+		}
+	}
+	return nil, err
+}
+
+// Get returns an fs.File for the given Charm ID and path.
+func (mfs *MirrorFileStore) Get(charmID string, path string) (fs.File, error) {
+	f, err := mfs.FileStore.Get(charmID, path)
+	if err == nil || !mfs.fallback(err) {
+		return f, err
+	}
+	for _, s := range mfs.Secondaries {
+		if f, serr := s.Get(charmID, path); serr == nil {
+			return f, nil
+		}
+	}
+	return nil, err
+}
+
+// List returns the entries of the directory at path for the Charm ID.
+func (mfs *MirrorFileStore) List(charmID string, path string) ([]charm.FileInfo, error) {
+	fis, err := List(mfs.FileStore, charmID, path)
+	if err == nil || !mfs.fallback(err) {
+		return fis, err
+	}
+	for _, s := range mfs.Secondaries {
+		if fis, serr := List(s, charmID, path); serr == nil {
+			return fis, nil
+		}
+	}
+	return nil, err
+}
+
+// Put stores the data from r at path for the Charm ID on the primary and then
+// on each secondary. An error writing to a secondary is returned after the
+// write is attempted on the others, with the file kept on the primary.
+func (mfs *MirrorFileStore) Put(charmID string, path string, r io.Reader, mode fs.FileMode) error {
+	var data []byte
+	if !mode.IsDir() {
+		var err error
+		if data, err = io.ReadAll(r); err != nil {
+			return err
+		}
+	}
+	if err := mfs.FileStore.Put(charmID, path, bytes.NewReader(data), mode); err != nil {
+		return err
+	}
+	var first error
+	for i, s := range mfs.Secondaries {
+		if err := s.Put(charmID, path, bytes.NewReader(data), mode); err != nil && first == nil {
+			first = fmt.Errorf("mirror %d: %w", i, err)
+		}
+	}
+	return first
+}
+
+// Delete deletes the file at path for the Charm ID from the primary and each
+// secondary.
+func (mfs *MirrorFileStore) Delete(charmID string, path string) error {
+	if err := mfs.FileStore.Delete(charmID, path); err != nil {
+		return err
+	}
+	var first error
+	for i, s := range mfs.Secondaries {
+		if err := s.Delete(charmID, path); err != nil && !errors.Is(err, fs.ErrNotExist) && first == nil {
+			first = fmt.Errorf("mirror %d: %w", i, err)
+		}
+	}
+	return first
+}
+
+// CopyFrom copies the file or directory at srcPath for srcCharmID in the src
+// FileStore to the given path for the Charm ID, on every store.
+func (mfs *MirrorFileStore) CopyFrom(charmID string, path string, src FileStore, srcCharmID string, srcPath string) error {
+	return Copy(mfs, charmID, path, src, srcCharmID, srcPath)
+}
+
+// fallback reports whether a read that failed on the primary with err should
+// be retried on the secondaries.
+func (mfs *MirrorFileStore) fallback(err error) bool {
+	return mfs.ReadFallback && !errors.Is(err, fs.ErrNotExist)
+}
@@ -0,0 +1,77 @@
+package storage_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/charmbracelet/charm/server/storage"
+	memstorage "github.com/charmbracelet/charm/server/storage/mem"
+)
+
+// unavailableFileStore fails reads with err while it's down.
+type unavailableFileStore struct {
+	storage.FileStore
+	down bool
+	err  error
+}
+
+func (ufs *unavailableFileStore) Stat(charmID string, path string) (fs.FileInfo, error) {
+	if ufs.down {
+		return nil, ufs.err
+	}
+	return ufs.FileStore.Stat(charmID, path)
+}
+
+func (ufs *unavailableFileStore) Get(charmID string, path string) (fs.File, error) {
+	if ufs.down {
+		return nil, ufs.err
+	}
+	return ufs.FileStore.Get(charmID, path)
+}
+
+func TestMirrorReadFallback(t *testing.T) {
+	errUnavailable := errors.New("backend unavailable")
+	primary := &unavailableFileStore{FileStore: memstorage.NewMemFileStore(), err: errUnavailable}
+	secondary := memstorage.NewMemFileStore()
+	mfs := storage.NewMirrorFileStore(primary, secondary)
+	if err := mfs.Put("a", "/hello.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := secondary.Stat("a", "/hello.txt"); err != nil {
+		t.Fatalf("expected the write to be mirrored, got %v", err)
+	}
+
+	primary.down = true
+	if _, err := mfs.Get("a", "/hello.txt"); !errors.Is(err, errUnavailable) {
+		t.Fatalf("expected the primary error without ReadFallback, got %v", err)
+	}
+
+	mfs.ReadFallback = true
+	f, err := mfs.Get("a", "/hello.txt")
+	if err != nil {
+		t.Fatalf("expected the read to fall back to the secondary, got %v", err)
+	}
+	data, err := io.ReadAll(f)
+	f.Close() // nolint:errcheck
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("expected the secondary's data, got %q, %v", data, err)
+	}
+	if _, err := mfs.Stat("a", "/hello.txt"); err != nil {
+		t.Fatalf("expected Stat to fall back to the secondary, got %v", err)
+	}
+	if _, err := mfs.Get("a", "/missing.txt"); !errors.Is(err, errUnavailable) {
+		t.Fatalf("expected the primary error when no secondary has the file, got %v", err)
+	}
+
+	// A file the primary doesn't have isn't looked up elsewhere.
+	primary.down = false
+	if err := secondary.Put("a", "/stale.txt", bytes.NewBufferString("stale"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Get("a", "/stale.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist to propagate, got %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+// ErrUnknownScheme is used when Open is given a URL with a scheme no FileStore
+// backend is registered for.
+var ErrUnknownScheme = errors.New("unknown file store scheme")
+
+// OpenFunc creates a FileStore from a parsed URL.
+type OpenFunc func(u *url.URL) (FileStore, error)
+
+var (
+	openersMu sync.RWMutex
+	openers   = make(map[string]OpenFunc)
+)
+
+// Register makes a FileStore backend available to Open for URLs with the given
+// scheme. Backends usually register themselves when their package is
+// imported.
+func Register(scheme string, open OpenFunc) {
+	openersMu.Lock()
+	defer openersMu.Unlock()
+	openers[scheme] = open
+}
+
+// Open returns the FileStore described by a URL such as file:///var/data. The
+// backend for the scheme must have been registered.
+func Open(dsn string) (FileStore, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	openersMu.RLock()
+	open, ok := openers[u.Scheme]
+	openersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownScheme, u.Scheme)
+	}
+	return open(u)
+}
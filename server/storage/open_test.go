@@ -0,0 +1,45 @@
+package storage_test
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/charmbracelet/charm/server/storage"
+	localstorage "github.com/charmbracelet/charm/server/storage/local"
+	memstorage "github.com/charmbracelet/charm/server/storage/mem"
+)
+
+func TestOpen(t *testing.T) {
+	tdir := t.TempDir()
+	fs, err := storage.Open("file://" + tdir)
+	if err != nil {
+		t.Fatalf("expected no error opening file store, %v", err)
+	}
+	lfs, ok := fs.(*localstorage.LocalFileStore)
+	if !ok {
+		t.Fatalf("expected a LocalFileStore, got %T", fs)
+	}
+	if lfs.Path != tdir {
+		t.Fatalf("expected path %s, got %s", tdir, lfs.Path)
+	}
+
+	// Backends meant for tests are only registered by them.
+	if _, err := storage.Open("mem://"); !errors.Is(err, storage.ErrUnknownScheme) {
+		t.Fatalf("expected mem:// not to be registered, got %v", err)
+	}
+	storage.Register("mem", func(*url.URL) (storage.FileStore, error) {
+		return memstorage.NewMemFileStore(), nil
+	})
+	fs, err = storage.Open("mem://")
+	if err != nil {
+		t.Fatalf("expected no error opening mem store, %v", err)
+	}
+	if _, ok := fs.(*memstorage.MemFileStore); !ok {
+		t.Fatalf("expected a MemFileStore, got %T", fs)
+	}
+
+	if _, err := storage.Open("s3://bucket/prefix?region=us-east-1"); !errors.Is(err, storage.ErrUnknownScheme) {
+		t.Fatalf("expected ErrUnknownScheme, got %v", err)
+	}
+}
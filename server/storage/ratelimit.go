@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+
+	charm "github.com/charmbracelet/charm/proto"
+)
+
+// ErrRateLimited is used when a Charm ID makes operations on a
+// RateLimitedFileStore faster than it allows.
+var ErrRateLimited = errors.New("too many operations, slow down")
+
+// rateLimitSweep is how often a RateLimitedFileStore forgets the buckets of
+// idle Charm IDs.
+const rateLimitSweep = time.Minute
+
+// now returns the current time. It's a variable so tests can control how fast
+// buckets refill.
+var now = time.Now
+
+// RateLimitedFileStore is a FileStore that limits how many operations each
+// Charm ID may make per second on the FileStore it wraps, regardless of their
+// size. Every Stat, Get, Put, Delete and CopyFrom takes a token from the
+// Charm ID's bucket, which refills at a steady rate up to its burst size.
+// Operations made with an empty bucket fail with ErrRateLimited.
+type RateLimitedFileStore struct {
+	FileStore
+
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// tokenBucket is the state of a Charm ID's bucket as of last.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimitedFileStore returns a RateLimitedFileStore allowing each Charm
+// ID opsPerSec operations per second on fs, with bursts of up to burst
+// operations.
+func NewRateLimitedFileStore(fs FileStore, opsPerSec float64, burst int) *RateLimitedFileStore {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimitedFileStore{
+		FileStore: fs,
+		rate:      opsPerSec,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: now(),
+	}
+}
+
+// Stat returns the fs.FileInfo of the file at path for the Charm ID.
+func (rfs *RateLimitedFileStore) Stat(charmID string, path string) (fs.FileInfo, error) {
+	if err := rfs.allow(charmID); err != nil {
+		return nil, err
+	}
+	return rfs.FileStore.Stat(charmID, path)
+}
+
+// Get returns an fs.File for the given Charm ID and path.
+func (rfs *RateLimitedFileStore) Get(charmID string, path string) (fs.File, error) {
+	if err := rfs.allow(charmID); err != nil {
+		return nil, err
+	}
+	return rfs.FileStore.Get(charmID, path)
+}
+
+// List returns the entries of the directory at path for the Charm ID.
+func (rfs *RateLimitedFileStore) List(charmID string, path string) ([]charm.FileInfo, error) {
+	if err := rfs.allow(charmID); err != nil {
+		return nil, err
+	}
+	return List(rfs.FileStore, charmID, path)
+}
+
+// Put stores the data from r at path for the Charm ID.
+func (rfs *RateLimitedFileStore) Put(charmID string, path string, r io.Reader, mode fs.FileMode) error {
+	if err := rfs.allow(charmID); err != nil {
+		return err
+	}
+	return rfs.FileStore.Put(charmID, path, r, mode)
+}
+
+// Delete deletes the file at path for the Charm ID.
+func (rfs *RateLimitedFileStore) Delete(charmID string, path string) error {
+	if err := rfs.allow(charmID); err != nil {
+		return err
+	}
+	return rfs.FileStore.Delete(charmID, path)
+}
+
+// CopyFrom copies the file or directory at srcPath for srcCharmID in the src
+// FileStore to the given path for the Charm ID. It counts as one operation for
+// the Charm ID.
+func (rfs *RateLimitedFileStore) CopyFrom(charmID string, path string, src FileStore, srcCharmID string, srcPath string) error {
+	if err := rfs.allow(charmID); err != nil {
+		return err
+	}
+	return rfs.FileStore.CopyFrom(charmID, path, src, srcCharmID, srcPath)
+}
+
+// allow takes a token from the Charm ID's bucket, failing with ErrRateLimited
+// if it's empty.
+func (rfs *RateLimitedFileStore) allow(charmID string) error {
+	now := now()
+	rfs.mu.Lock()
+	defer rfs.mu.Unlock()
+	if now.Sub(rfs.lastSweep) > rateLimitSweep {
+		// A bucket that's refilled is the same as a new one.
+		for id, b := range rfs.buckets {
+			if b.refill(now, rfs.rate, rfs.burst) >= rfs.burst {
+				delete(rfs.buckets, id)
+			}
+		}
+		rfs.lastSweep = now
+	}
+	b, ok := rfs.buckets[charmID]
+	if !ok {
+		b = &tokenBucket{tokens: rfs.burst, last: now}
+		rfs.buckets[charmID] = b
+	}
+	if b.refill(now, rfs.rate, rfs.burst) < 1 {
+		return ErrRateLimited
+	}
+	b.tokens--
+	return nil
+}
+
+// refill adds the tokens accrued since the bucket was last updated and returns
+// how many it holds.
+func (b *tokenBucket) refill(now time.Time, rate, burst float64) float64 {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	return b.tokens
+}
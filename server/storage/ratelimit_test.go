@@ -0,0 +1,45 @@
+package storage_test
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/charm/server/storage"
+	memstorage "github.com/charmbracelet/charm/server/storage/mem"
+)
+
+func TestRateLimitedFileStore(t *testing.T) {
+	clock := time.Unix(0, 0)
+	defer storage.SetNow(func() time.Time { return clock })()
+	rfs := storage.NewRateLimitedFileStore(memstorage.NewMemFileStore(), 5, 3)
+	put := func(charmID string) error {
+		return rfs.Put(charmID, "/hello.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644))
+	}
+	for i := 0; i < 3; i++ {
+		if err := put("a"); err != nil {
+			t.Fatalf("expected operation %d of the burst to pass, got %v", i, err)
+		}
+	}
+	if _, err := rfs.Stat("a", "/hello.txt"); !errors.Is(err, storage.ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited past the burst, got %v", err)
+	}
+	if err := put("b"); err != nil {
+		t.Fatalf("expected other users not to be limited, got %v", err)
+	}
+
+	// At 5 operations per second, a token is refilled every 200ms.
+	clock = clock.Add(199 * time.Millisecond)
+	if _, err := rfs.Stat("a", "/hello.txt"); !errors.Is(err, storage.ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited before a token is refilled, got %v", err)
+	}
+	clock = clock.Add(2 * time.Millisecond)
+	if _, err := rfs.Stat("a", "/hello.txt"); err != nil {
+		t.Fatalf("expected a token to be refilled, got %v", err)
+	}
+	if _, err := rfs.Stat("a", "/hello.txt"); !errors.Is(err, storage.ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited once the refilled token is used, got %v", err)
+	}
+}
@@ -1,11 +1,141 @@
 package storage
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"path"
+	"strings"
+	"time"
+
+	charm "github.com/charmbracelet/charm/proto"
 )
 
+// ErrFileTooLarge is used when a file exceeds the maximum file size allowed by
+// a FileStore.
+var ErrFileTooLarge = errors.New("file too large")
+
+// ErrTimeout is used when a FileStore operation doesn't complete in time.
+var ErrTimeout = errors.New("operation timed out")
+
+// ErrStuck is used when a FileStore can't be moved because operations that
+// timed out are still running.
+var ErrStuck = errors.New("operations that timed out are still running")
+
+// ErrInvalidTarget is used when the destination of a write exists but isn't a
+// regular file or directory.
+var ErrInvalidTarget = errors.New("invalid target: not a regular file or directory")
+
+// ErrQuotaExceeded is used when a write doesn't fit in a user's storage quota.
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// ErrUnknownReservation is used when a quota reservation doesn't exist or
+// belongs to another user.
+var ErrUnknownReservation = errors.New("unknown quota reservation")
+
+// ErrCaseConflict is used when a write would replace a file whose name only
+// differs in case on a case-insensitive volume.
+var ErrCaseConflict = errors.New("a file with a differently-cased name already exists")
+
+// ErrTooManyEntries is used when a listing has more entries than a FileStore
+// allows.
+var ErrTooManyEntries = errors.New("too many entries to list")
+
+// ErrCorrupt is used when stored data no longer matches the checksum recorded
+// when it was written.
+var ErrCorrupt = errors.New("stored data is corrupt")
+
+// ErrChecksumMismatch is used when uploaded data doesn't match the checksum
+// the client expected it to have.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrSizeMismatch is used when uploaded data doesn't have the size the client
+// expected it to have.
+var ErrSizeMismatch = errors.New("size mismatch")
+
+// ErrHostNotAllowed is used when importing from a host that isn't allowed.
+var ErrHostNotAllowed = errors.New("host not allowed")
+
+// ErrContentTypeNotAllowed is used when importing data of a media type that
+// isn't allowed.
+var ErrContentTypeNotAllowed = errors.New("content type not allowed")
+
+// ErrDisallowedContentType is used when storing data whose sniffed media type
+// isn't allowed.
+var ErrDisallowedContentType = errors.New("disallowed content type")
+
+// ErrXattrNotAllowed is used when a write sets an extended attribute outside
+// the user namespace, such as one read by the kernel or a security module.
+var ErrXattrNotAllowed = errors.New("extended attribute not allowed")
+
+// ErrConflict is used when a conditional operation's precondition fails
+// because the target changed since the client last saw it.
+var ErrConflict = errors.New("precondition failed: the file has changed")
+
+// ErrAccessDenied is used when a Charm ID isn't allowed to access data it
+// doesn't own, such as a shared space it isn't a member of.
+var ErrAccessDenied = errors.New("access denied")
+
+// ErrNoThroughput is used when write throughput is asked for before any write
+// has been measured.
+var ErrNoThroughput = errors.New("no writes measured yet")
+
+// ErrCharmIDExists is used when renaming a Charm ID to one that already has
+// data.
+var ErrCharmIDExists = errors.New("charm id already has data")
+
+// ErrInvalidPath is used when a path can't be operated on, such as deleting a
+// user's root directory.
+var ErrInvalidPath = errors.New("invalid path")
+
+// ErrInvalidEncoding is used when uploaded data isn't valid for its encoding,
+// or the encoding isn't supported.
+var ErrInvalidEncoding = errors.New("invalid upload encoding")
+
+// ErrInvalidOffset is used when a write starts at a negative offset.
+var ErrInvalidOffset = errors.New("invalid offset")
+
+// ErrIsDirectory is used when a file is expected but the path is a directory.
+var ErrIsDirectory = errors.New("is a directory")
+
+// ErrNotDirectory is used when a directory is expected but the path is a file.
+var ErrNotDirectory = errors.New("not a directory")
+
+// NotDirectoryError is used when a path can't be written because one of its
+// parents is a file. It matches ErrNotDirectory with errors.Is.
+type NotDirectoryError struct {
+	// Path is the parent that's a file.
+	Path string
+}
+
+func (e *NotDirectoryError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, ErrNotDirectory)
+}
+
+// Is reports whether target is ErrNotDirectory.
+func (e *NotDirectoryError) Is(target error) bool {
+	return target == ErrNotDirectory
+}
+
+// ErrIdempotencyKeyReused is used when an idempotency key is reused for a
+// write to a different path.
+var ErrIdempotencyKeyReused = errors.New("idempotency key reused for a different path")
+
+// ErrOffsetMismatch is used when resuming a write at an offset that isn't the
+// current length of the file.
+var ErrOffsetMismatch = errors.New("offset doesn't match file length")
+
+// ErrInvalidCursor is used when paging with a cursor that wasn't returned by
+// a previous page.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ErrSymlinkLoop is used when following symbolic links below a path leads back
+// to a directory already being walked.
+var ErrSymlinkLoop = errors.New("symbolic link loop")
+
 // FileStore is the interface storage backends need to implement to act as a
 // the datastore for the Charm Cloud server.
 type FileStore interface {
@@ -13,6 +143,258 @@ type FileStore interface {
 	Get(charmID string, path string) (fs.File, error)
 	Put(charmID string, path string, r io.Reader, mode fs.FileMode) error
 	Delete(charmID string, path string) error
+	CopyFrom(charmID string, path string, src FileStore, srcCharmID string, srcPath string) error
+}
+
+// Lister is implemented by FileStores that can list the entries of a
+// directory directly, whatever the format of the listings returned by Get.
+type Lister interface {
+	List(charmID string, path string) ([]charm.FileInfo, error)
+}
+
+// List returns the entries of the directory at path for the Charm ID in the
+// FileStore. FileStores that aren't Listers are listed by decoding the JSON
+// listing returned by Get. It fails with ErrNotDirectory if path isn't a
+// directory.
+func List(s FileStore, charmID string, path string) ([]charm.FileInfo, error) {
+	if l, ok := s.(Lister); ok {
+		return l.List(charmID, path)
+	}
+	f, err := s.Get(charmID, path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // nolint:errcheck
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, ErrNotDirectory
+	}
+	var dir charm.FileInfo
+	if err := json.NewDecoder(f).Decode(&dir); err != nil {
+		return nil, err
+	}
+	return dir.Files, nil
+}
+
+// PutOptions are optional settings for storing a file.
+type PutOptions struct {
+	// Xattrs are extended attributes to set on the stored file, if the
+	// backend supports them. Only attributes in the user namespace, such as
+	// user.charm.tag, may be set; others fail with ErrXattrNotAllowed.
+	Xattrs map[string][]byte
+	// ReservationID is a quota reservation the write uses instead of the
+	// remaining quota. The reservation is consumed by the write.
+	ReservationID string
+	// UploadEncoding is the encoding of the uploaded data. Data encoded with
+	// EncodingGzip is decompressed before it's stored. Empty means
+	// EncodingIdentity.
+	UploadEncoding string
+	// Result, if set, is filled in with the details of the stored file once
+	// the write is committed.
+	Result *PutResult
+	// IdempotencyKey identifies a write so that retrying it doesn't write
+	// again. A repeated write with the same key returns the result of the
+	// first, without reading its data, for as long as the store remembers it.
+	IdempotencyKey string
+	// ExpectedChecksum, if set, is the checksum the uploaded data must have,
+	// either as a hex digest or prefixed with the algorithm like the
+	// checksums a backend records, such as sha256:<hex>. A digest without a
+	// prefix is SHA-256. Data that doesn't match isn't stored and the write
+	// fails with ErrChecksumMismatch.
+	ExpectedChecksum string
+	// ExpectedSize, if positive, is the number of bytes the uploaded data must
+	// have once decoded. Data of another size isn't stored and the write fails
+	// with ErrSizeMismatch.
+	ExpectedSize int64
+}
+
+// PutResult describes a file as committed by a write, computed from the data
+// actually stored.
+type PutResult struct {
+	Size     int64
+	Checksum string
+	ModTime  time.Time
+}
+
+// Upload encodings.
+const (
+	EncodingIdentity = "identity"
+	EncodingGzip     = "gzip"
+)
+
+// ListFilter limits the entries of a directory listing.
+type ListFilter struct {
+	// DirsOnly lists only directories.
+	DirsOnly bool
+	// FilesOnly lists only files.
+	FilesOnly bool
+	// Extensions, if set, lists only files with one of the extensions, such
+	// as ".png", compared case-insensitively. Directories are still listed
+	// unless FilesOnly is set.
+	Extensions []string
+}
+
+// Match reports whether an entry with the given name belongs in a listing
+// filtered by lf.
+func (lf ListFilter) Match(name string, isDir bool) bool {
+	if isDir {
+		return !lf.FilesOnly
+	}
+	if lf.DirsOnly {
+		return false
+	}
+	if len(lf.Extensions) == 0 {
+		return true
+	}
+	ext := path.Ext(name)
+	for _, e := range lf.Extensions {
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		if strings.EqualFold(ext, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// ChangeType is the kind of change made to a path.
+type ChangeType int
+
+// Change types.
+const (
+	ChangeAdded ChangeType = iota
+	ChangeModified
+	ChangeDeleted
+)
+
+// String returns the name of the change type.
+func (ct ChangeType) String() string {
+	switch ct {
+	case ChangeAdded:
+		return "added"
+	case ChangeModified:
+		return "modified"
+	case ChangeDeleted:
+		return "deleted"
+	}
+	return "unknown"
+}
+
+// Change describes a change made to a path. Checksum is the checksum after the
+// change and OldChecksum the one before it, where applicable.
+type Change struct {
+	Type        ChangeType
+	Path        string
+	IsDir       bool
+	Checksum    string
+	OldChecksum string
+}
+
+// ActivityEntry describes an operation made on a path. Result is "ok" or the
+// error the operation failed with.
+type ActivityEntry struct {
+	Op     string    `json:"op"`
+	Path   string    `json:"path"`
+	Size   int64     `json:"size"`
+	Time   time.Time `json:"time"`
+	Result string    `json:"result"`
+}
+
+// Op is a kind of access to a file or directory.
+type Op int
+
+// Access ops.
+const (
+	OpRead Op = iota
+	OpWrite
+	OpDelete
+)
+
+// ACL grants Charm IDs other than the owner access to a file or directory.
+type ACL struct {
+	// Read are the Charm IDs that may read.
+	Read []string `json:"read,omitempty"`
+	// Write are the Charm IDs that may write and delete. Write access doesn't
+	// imply read access.
+	Write []string `json:"write,omitempty"`
+}
+
+// Allows reports whether the ACL grants charmID access for op.
+func (acl ACL) Allows(charmID string, op Op) bool {
+	ids := acl.Write
+	if op == OpRead {
+		ids = acl.Read
+	}
+	for _, id := range ids {
+		if id == charmID {
+			return true
+		}
+	}
+	return false
+}
+
+// ModeDrift describes a path whose permission bits aren't the ones expected.
+type ModeDrift struct {
+	Path string
+	Mode fs.FileMode
+	Want fs.FileMode
+}
+
+// Copy copies the file or directory at srcPath for srcCharmID in the src
+// FileStore to path for charmID in the dst FileStore. Directories are copied
+// recursively. It's meant for FileStore implementations of CopyFrom that have
+// no faster way of copying from src.
+func Copy(dst FileStore, charmID string, dstPath string, src FileStore, srcCharmID string, srcPath string) error {
+	info, err := src.Stat(srcCharmID, srcPath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return copyDir(dst, charmID, dstPath, src, srcCharmID, srcPath, info.Mode())
+	}
+	return copyFile(dst, charmID, dstPath, src, srcCharmID, srcPath)
+}
+
+// copyDir copies the directory at srcPath, with the given mode, and everything
+// below it. Its entries come from List rather than Get, which may return an
+// index file or a listing in another format.
+func copyDir(dst FileStore, charmID string, dstPath string, src FileStore, srcCharmID string, srcPath string, mode fs.FileMode) error {
+	fis, err := List(src, srcCharmID, srcPath)
+	if err != nil {
+		return err
+	}
+	if err := dst.Put(charmID, dstPath, nil, mode|fs.ModeDir); err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		sp, dp := path.Join(srcPath, fi.Name), path.Join(dstPath, fi.Name)
+		if fi.IsDir {
+			err = copyDir(dst, charmID, dp, src, srcCharmID, sp, fi.Mode)
+		} else {
+			err = copyFile(dst, charmID, dp, src, srcCharmID, sp)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(dst FileStore, charmID string, dstPath string, src FileStore, srcCharmID string, srcPath string) error {
+	f, err := src.Get(srcCharmID, srcPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint:errcheck
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return dst.Put(charmID, dstPath, f, info.Mode())
 }
 
 // EnsureDir will create the directory for the provided path on the server
@@ -43,3 +425,17 @@ func addExecPermsForMkDir(mode fs.FileMode) fs.FileMode {
 	}
 	return mode | op | fs.ModeDir
 }
+
+// StoreStats are totals across every user of a FileStore.
+type StoreStats struct {
+	// Bytes is the total size of the files stored.
+	Bytes int64 `json:"bytes"`
+	// Users is the number of Charm IDs with data stored.
+	Users int `json:"users"`
+	// Files is the number of files stored.
+	Files int64 `json:"files"`
+	// VolumeFree and VolumeTotal are the bytes available and the size of the
+	// volume holding the store, or zero where they can't be determined.
+	VolumeFree  int64 `json:"volume_free"`
+	VolumeTotal int64 `json:"volume_total"`
+}
@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"sync"
+
+	charmfs "github.com/charmbracelet/charm/fs"
+	charm "github.com/charmbracelet/charm/proto"
+)
+
+// ErrThrottled is used when a ThrottledFileStore with the ThrottleFailFast
+// policy is at its concurrency limit.
+var ErrThrottled = errors.New("too many concurrent operations")
+
+// ThrottlePolicy is what a ThrottledFileStore does with operations when it's
+// at its concurrency limit.
+type ThrottlePolicy int
+
+// Throttle policies.
+const (
+	// ThrottleBlock waits for an in-flight operation to finish.
+	ThrottleBlock ThrottlePolicy = iota
+	// ThrottleFailFast fails with ErrThrottled.
+	ThrottleFailFast
+)
+
+// ThrottledFileStore is a FileStore that limits how many operations may be in
+// flight on the FileStore it wraps. Reads (Stat and Get) and writes (Put,
+// Delete and CopyFrom) have separate limits. A file returned by Get counts as
+// in flight until it's closed.
+type ThrottledFileStore struct {
+	FileStore
+	Policy ThrottlePolicy
+
+	reads  chan struct{}
+	writes chan struct{}
+}
+
+// NewThrottledFileStore returns a ThrottledFileStore allowing up to maxReads
+// reads and maxWrites writes at once on fs. A limit of zero means no limit.
+func NewThrottledFileStore(fs FileStore, maxReads, maxWrites int, policy ThrottlePolicy) *ThrottledFileStore {
+	tfs := &ThrottledFileStore{FileStore: fs, Policy: policy}
+	if maxReads > 0 {
+		tfs.reads = make(chan struct{}, maxReads)
+	}
+	if maxWrites > 0 {
+		tfs.writes = make(chan struct{}, maxWrites)
+	}
+	return tfs
+}
+
+// Stat returns the fs.FileInfo of the file at path for the Charm ID.
+func (tfs *ThrottledFileStore) Stat(charmID string, path string) (fs.FileInfo, error) {
+	return tfs.StatContext(context.Background(), charmID, path)
+}
+
+// StatContext is like Stat but stops waiting for a free slot when ctx is done.
+func (tfs *ThrottledFileStore) StatContext(ctx context.Context, charmID string, path string) (fs.FileInfo, error) {
+	release, err := tfs.acquire(ctx, tfs.reads)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return tfs.FileStore.Stat(charmID, path)
+}
+
+// Get returns an fs.File for the given Charm ID and path.
+func (tfs *ThrottledFileStore) Get(charmID string, path string) (fs.File, error) {
+	return tfs.GetContext(context.Background(), charmID, path)
+}
+
+// GetContext is like Get but stops waiting for a free slot when ctx is done.
+func (tfs *ThrottledFileStore) GetContext(ctx context.Context, charmID string, path string) (fs.File, error) {
+	release, err := tfs.acquire(ctx, tfs.reads)
+	if err != nil {
+		return nil, err
+	}
+	f, err := tfs.FileStore.Get(charmID, path)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	// Listings stay a *charmfs.DirFile, with their reader freeing the slot,
+	// as they may be streamed from the store until they're closed.
+	if df, ok := f.(*charmfs.DirFile); ok {
+		tdf := *df
+		var r io.Reader = df.Buffer
+		if df.Reader != nil {
+			r = df.Reader
+		}
+		tdf.Reader = &throttledReader{Reader: r, release: release}
+		return &tdf, nil
+	}
+	return &throttledFile{File: f, release: release}, nil
+}
+
+// List returns the entries of the directory at path for the Charm ID. It counts
+// as a read.
+func (tfs *ThrottledFileStore) List(charmID string, path string) ([]charm.FileInfo, error) {
+	release, err := tfs.acquire(context.Background(), tfs.reads)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return List(tfs.FileStore, charmID, path)
+}
+
+// Put stores the data from r at path for the Charm ID.
+func (tfs *ThrottledFileStore) Put(charmID string, path string, r io.Reader, mode fs.FileMode) error {
+	return tfs.PutContext(context.Background(), charmID, path, r, mode)
+}
+
+// PutContext is like Put but stops waiting for a free slot when ctx is done.
+func (tfs *ThrottledFileStore) PutContext(ctx context.Context, charmID string, path string, r io.Reader, mode fs.FileMode) error {
+	release, err := tfs.acquire(ctx, tfs.writes)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return tfs.FileStore.Put(charmID, path, r, mode)
+}
+
+// Delete deletes the file at path for the Charm ID.
+func (tfs *ThrottledFileStore) Delete(charmID string, path string) error {
+	return tfs.DeleteContext(context.Background(), charmID, path)
+}
+
+// DeleteContext is like Delete but stops waiting for a free slot when ctx is
+// done.
+func (tfs *ThrottledFileStore) DeleteContext(ctx context.Context, charmID string, path string) error {
+	release, err := tfs.acquire(ctx, tfs.writes)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return tfs.FileStore.Delete(charmID, path)
+}
+
+// CopyFrom copies the file or directory at srcPath for srcCharmID in the src
+// FileStore to the given path for the Charm ID.
+func (tfs *ThrottledFileStore) CopyFrom(charmID string, path string, src FileStore, srcCharmID string, srcPath string) error {
+	release, err := tfs.acquire(context.Background(), tfs.writes)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return tfs.FileStore.CopyFrom(charmID, path, src, srcCharmID, srcPath)
+}
+
+// acquire takes a slot from sem, returning a function that frees it. A nil sem
+// has no limit.
+func (tfs *ThrottledFileStore) acquire(ctx context.Context, sem chan struct{}) (func(), error) {
+	if sem == nil {
+		return func() {}, nil
+	}
+	release := func() { <-sem }
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if tfs.Policy == ThrottleFailFast {
+		return nil, ErrThrottled
+	}
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// throttledFile frees its slot in a ThrottledFileStore when it's closed.
+type throttledFile struct {
+	fs.File
+	release func()
+	once    sync.Once
+}
+
+// Close closes the file and frees its slot.
+func (f *throttledFile) Close() error {
+	err := f.File.Close()
+	f.once.Do(f.release)
+	return err
+}
+
+// throttledReader is the reader of a directory listing that frees its slot in
+// a ThrottledFileStore when it's closed.
+type throttledReader struct {
+	io.Reader
+	release func()
+	once    sync.Once
+}
+
+// Close closes the reader it wraps, if it's an io.Closer, and frees its slot.
+func (r *throttledReader) Close() error {
+	var err error
+	if c, ok := r.Reader.(io.Closer); ok {
+		err = c.Close()
+	}
+	r.once.Do(r.release)
+	return err
+}
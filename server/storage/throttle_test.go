@@ -0,0 +1,160 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	charmfs "github.com/charmbracelet/charm/fs"
+	"github.com/charmbracelet/charm/server/storage"
+	localstorage "github.com/charmbracelet/charm/server/storage/local"
+	memstorage "github.com/charmbracelet/charm/server/storage/mem"
+)
+
+// countingFileStore records the most operations it has seen in flight at once.
+type countingFileStore struct {
+	*memstorage.MemFileStore
+	inFlight int32
+	max      int32
+	delay    time.Duration
+}
+
+func (cfs *countingFileStore) track() func() {
+	n := atomic.AddInt32(&cfs.inFlight, 1)
+	for {
+		m := atomic.LoadInt32(&cfs.max)
+		if n <= m || atomic.CompareAndSwapInt32(&cfs.max, m, n) {
+			break
+		}
+	}
+	time.Sleep(cfs.delay)
+	return func() { atomic.AddInt32(&cfs.inFlight, -1) }
+}
+
+func (cfs *countingFileStore) Stat(charmID, path string) (fs.FileInfo, error) {
+	defer cfs.track()()
+	return cfs.MemFileStore.Stat(charmID, path)
+}
+
+func (cfs *countingFileStore) Put(charmID, path string, r io.Reader, mode fs.FileMode) error {
+	defer cfs.track()()
+	return cfs.MemFileStore.Put(charmID, path, r, mode)
+}
+
+func TestThrottledFileStore(t *testing.T) {
+	reads := &countingFileStore{MemFileStore: memstorage.NewMemFileStore(), delay: time.Millisecond}
+	writes := &countingFileStore{MemFileStore: memstorage.NewMemFileStore(), delay: time.Millisecond}
+	rfs := storage.NewThrottledFileStore(reads, 3, 0, storage.ThrottleBlock)
+	wfs := storage.NewThrottledFileStore(writes, 0, 2, storage.ThrottleBlock)
+	if err := reads.MemFileStore.Put("id", "/hello.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := rfs.Stat("id", "/hello.txt"); err != nil {
+				errs <- err
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := wfs.Put("id", "/hello.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("expected no error, %v", err)
+	}
+	if reads.max > 3 {
+		t.Fatalf("expected at most 3 reads in flight, got %d", reads.max)
+	}
+	if writes.max > 2 {
+		t.Fatalf("expected at most 2 writes in flight, got %d", writes.max)
+	}
+}
+
+func TestThrottledFileStoreSaturated(t *testing.T) {
+	mfs := memstorage.NewMemFileStore()
+	if err := mfs.Put("id", "/hello.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	tfs := storage.NewThrottledFileStore(mfs, 1, 1, storage.ThrottleBlock)
+	// An open file holds its read slot until it's closed.
+	f, err := tfs.Get("id", "/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := tfs.StatContext(ctx, "id", "/hello.txt"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded waiting for a slot, got %v", err)
+	}
+
+	tfs.Policy = storage.ThrottleFailFast
+	if _, err := tfs.Stat("id", "/hello.txt"); !errors.Is(err, storage.ErrThrottled) {
+		t.Fatalf("expected ErrThrottled, got %v", err)
+	}
+	if err := tfs.Put("id", "/other.txt", bytes.NewBufferString("hi"), fs.FileMode(0o644)); err != nil {
+		t.Fatalf("expected writes not to be limited by reads, %v", err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tfs.Stat("id", "/hello.txt"); err != nil {
+		t.Fatalf("expected slot to be freed by Close, %v", err)
+	}
+}
+
+func TestThrottledFileStoreListing(t *testing.T) {
+	for _, format := range []localstorage.ListingFormat{localstorage.ListingJSON, localstorage.ListingNDJSON} {
+		t.Run(string(format), func(t *testing.T) {
+			lfs, err := localstorage.NewLocalFileStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			lfs.ListingFormat = format
+			if err := lfs.Put("id", "/hello.txt", bytes.NewBufferString("hello"), fs.FileMode(0o644)); err != nil {
+				t.Fatal(err)
+			}
+			tfs := storage.NewThrottledFileStore(lfs, 1, 0, storage.ThrottleFailFast)
+			f, err := tfs.Get("id", "/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := f.(*charmfs.DirFile); !ok {
+				t.Fatalf("expected a *charmfs.DirFile, got %T", f)
+			}
+			if _, err := tfs.Stat("id", "/hello.txt"); !errors.Is(err, storage.ErrThrottled) {
+				t.Fatalf("expected an open listing to hold its slot, got %v", err)
+			}
+			b, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Contains(b, []byte("hello.txt")) {
+				t.Fatalf("expected listing to include hello.txt, got %s", b)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tfs.Stat("id", "/hello.txt"); err != nil {
+				t.Fatalf("expected slot to be freed by Close, %v", err)
+			}
+		})
+	}
+}
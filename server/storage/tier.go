@@ -0,0 +1,284 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+
+	charmfs "github.com/charmbracelet/charm/fs"
+	charm "github.com/charmbracelet/charm/proto"
+)
+
+// ErrUnknownTier is used when a TieredFileStore is asked for a tier it doesn't
+// have.
+var ErrUnknownTier = errors.New("unknown storage tier")
+
+// TieredFileStore is a FileStore that spreads each Charm ID's files over
+// several named FileStores, such as a hot tier on local disk for data that's
+// synced often and a cold tier on object storage for archives. A file lives in
+// one tier at a time. Writes that don't name a tier go to the tier the file is
+// already in, or to the default tier for new files, and MoveTier relocates
+// files between tiers. Directory listings merge the entries of every tier and
+// report the tier of each file, so the tiers need to produce JSON listings.
+type TieredFileStore struct {
+	tiers map[string]FileStore
+	// names are the tier names with the default first and the rest sorted,
+	// the order files are looked up in.
+	names []string
+}
+
+// NewTieredFileStore returns a TieredFileStore over tiers, storing new files
+// in defaultTier.
+func NewTieredFileStore(defaultTier string, tiers map[string]FileStore) (*TieredFileStore, error) {
+	if _, ok := tiers[defaultTier]; !ok {
+		return nil, fmt.Errorf("default tier %q: %w", defaultTier, ErrUnknownTier)
+	}
+	names := make([]string, 0, len(tiers))
+	for name := range tiers {
+		if name != defaultTier {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	tfs := &TieredFileStore{
+		tiers: make(map[string]FileStore, len(tiers)),
+		names: append([]string{defaultTier}, names...),
+	}
+	for name, s := range tiers {
+		tfs.tiers[name] = s
+	}
+	return tfs, nil
+}
+
+// Tier returns the name of the tier the file or directory at path for the
+// Charm ID is in. For a directory spread over several tiers, it's the first
+// one holding it, starting with the default tier.
+func (tfs *TieredFileStore) Tier(charmID string, path string) (string, error) {
+	for _, name := range tfs.names {
+		_, err := tfs.tiers[name].Stat(charmID, path)
+		if err == nil {
+			return name, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+	}
+	return "", fs.ErrNotExist
+}
+
+// Stat returns the fs.FileInfo of the file at path for the Charm ID from the
+// tier it's in.
+func (tfs *TieredFileStore) Stat(charmID string, path string) (fs.FileInfo, error) {
+	tier, err := tfs.Tier(charmID, path)
+	if err != nil {
+		return nil, err
+	}
+	return tfs.tiers[tier].Stat(charmID, path)
+}
+
+// Get returns an fs.File for the given Charm ID and path from the tier it's
+// in. Directory listings include the entries of every tier, with the tier of
+// each file.
+func (tfs *TieredFileStore) Get(charmID string, path string) (fs.File, error) {
+	tier, err := tfs.Tier(charmID, path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := tfs.tiers[tier].Get(charmID, path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil || !info.IsDir() {
+		return f, err
+	}
+	f.Close() // nolint:errcheck
+	return tfs.list(charmID, path)
+}
+
+// GetTier is like Get but reads only from the named tier.
+func (tfs *TieredFileStore) GetTier(charmID string, path string, tier string) (fs.File, error) {
+	s, ok := tfs.tiers[tier]
+	if !ok {
+		return nil, ErrUnknownTier
+	}
+	return s.Get(charmID, path)
+}
+
+// Put stores the data from r at path for the Charm ID, in the tier the file is
+// already in, or the default tier for new files and directories.
+func (tfs *TieredFileStore) Put(charmID string, path string, r io.Reader, mode fs.FileMode) error {
+	tier := tfs.names[0]
+	if !mode.IsDir() {
+		if t, err := tfs.Tier(charmID, path); err == nil {
+			tier = t
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return tfs.PutTier(charmID, path, tier, r, mode)
+}
+
+// PutTier stores the data from r at path for the Charm ID in the named tier,
+// removing any copy of the file from the other tiers once it's stored.
+func (tfs *TieredFileStore) PutTier(charmID string, path string, tier string, r io.Reader, mode fs.FileMode) error {
+	dst, ok := tfs.tiers[tier]
+	if !ok {
+		return ErrUnknownTier
+	}
+	if err := dst.Put(charmID, path, r, mode); err != nil {
+		return err
+	}
+	if mode.IsDir() {
+		return nil
+	}
+	return tfs.deleteExcept(charmID, path, tier)
+}
+
+// Delete deletes the file or directory at path for the Charm ID from every
+// tier.
+func (tfs *TieredFileStore) Delete(charmID string, path string) error {
+	return tfs.deleteExcept(charmID, path, "")
+}
+
+// CopyFrom copies the file or directory at srcPath for srcCharmID in the src
+// FileStore to the given path for the Charm ID.
+func (tfs *TieredFileStore) CopyFrom(charmID string, path string, src FileStore, srcCharmID string, srcPath string) error {
+	return Copy(tfs, charmID, path, src, srcCharmID, srcPath)
+}
+
+// MoveTier moves the file or directory at path for the Charm ID to the named
+// tier. Each file is copied to the tier before it's deleted from the one it
+// was in, so a failed move leaves either copy readable.
+func (tfs *TieredFileStore) MoveTier(charmID string, path string, tier string) error {
+	dst, ok := tfs.tiers[tier]
+	if !ok {
+		return ErrUnknownTier
+	}
+	found := false
+	for _, name := range tfs.names {
+		if name == tier {
+			continue
+		}
+		src := tfs.tiers[name]
+		if _, err := src.Stat(charmID, path); errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		found = true
+		if err := Copy(dst, charmID, path, src, charmID, path); err != nil {
+			return err
+		}
+		if err := src.Delete(charmID, path); err != nil {
+			return err
+		}
+	}
+	if !found {
+		if _, err := dst.Stat(charmID, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteExcept deletes path for the Charm ID from every tier but except.
+func (tfs *TieredFileStore) deleteExcept(charmID string, path string, except string) error {
+	for _, name := range tfs.names {
+		if name == except {
+			continue
+		}
+		if err := tfs.tiers[name].Delete(charmID, path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns the entries of the directory at path for the Charm ID in every
+// tier holding it, with the tier of each file.
+func (tfs *TieredFileStore) List(charmID string, path string) ([]charm.FileInfo, error) {
+	dir, err := tfs.merge(charmID, path)
+	if err != nil {
+		return nil, err
+	}
+	return dir.Files, nil
+}
+
+// list returns the listing of the directory at path for the Charm ID, merging
+// the entries of every tier holding it.
+func (tfs *TieredFileStore) list(charmID string, path string) (fs.File, error) {
+	dir, err := tfs.merge(charmID, path)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := json.NewEncoder(buf).Encode(dir); err != nil {
+		return nil, err
+	}
+	info := dir
+	info.Files = nil
+	return &charmfs.DirFile{
+		Buffer:   buf,
+		FileInfo: &charmfs.FileInfo{FileInfo: info},
+	}, nil
+}
+
+// merge returns the directory at path for the Charm ID with the entries of
+// every tier holding it, listed with List so the tiers' listing formats don't
+// matter.
+func (tfs *TieredFileStore) merge(charmID string, path string) (charm.FileInfo, error) {
+	var dir charm.FileInfo
+	found := false
+	entries := make(map[string]charm.FileInfo)
+	for _, name := range tfs.names {
+		s := tfs.tiers[name]
+		info, err := s.Stat(charmID, path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return dir, err
+		}
+		if !info.IsDir() {
+			continue
+		}
+		fis, err := List(s, charmID, path)
+		if err != nil {
+			return dir, err
+		}
+		if !found {
+			dir = charm.FileInfo{Name: info.Name(), IsDir: true, Mode: info.Mode(), ModTime: info.ModTime()}
+		} else if info.ModTime().After(dir.ModTime) {
+			dir.ModTime = info.ModTime()
+		}
+		found = true
+		for _, e := range fis {
+			if prev, ok := entries[e.Name]; ok {
+				// A directory in several tiers is listed once.
+				if prev.IsDir && e.ModTime.After(prev.ModTime) {
+					prev.ModTime = e.ModTime
+					entries[e.Name] = prev
+				}
+				continue
+			}
+			if !e.IsDir {
+				e.Tier = name
+			}
+			entries[e.Name] = e
+		}
+	}
+	if !found {
+		return dir, fs.ErrNotExist
+	}
+	dir.Files = make([]charm.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		dir.Files = append(dir.Files, e)
+	}
+	sort.Slice(dir.Files, func(i, j int) bool { return dir.Files[i].Name < dir.Files[j].Name })
+	return dir, nil
+}
@@ -0,0 +1,129 @@
+package storage_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+
+	charm "github.com/charmbracelet/charm/proto"
+	"github.com/charmbracelet/charm/server/storage"
+	localstorage "github.com/charmbracelet/charm/server/storage/local"
+	memstorage "github.com/charmbracelet/charm/server/storage/mem"
+)
+
+func TestTieredFileStore(t *testing.T) {
+	hot := memstorage.NewMemFileStore()
+	cold := memstorage.NewMemFileStore()
+	tfs, err := storage.NewTieredFileStore("hot", map[string]storage.FileStore{"hot": hot, "cold": cold})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tfs.Put("a", "/docs/notes.txt", bytes.NewBufferString("notes"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tfs.PutTier("a", "/docs/archive.tar", "cold", bytes.NewBufferString("archive"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tfs.PutTier("a", "/x", "lukewarm", bytes.NewBufferString("x"), fs.FileMode(0o644)); !errors.Is(err, storage.ErrUnknownTier) {
+		t.Fatalf("expected ErrUnknownTier, got %v", err)
+	}
+	if _, err := hot.Stat("a", "/docs/archive.tar"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected the cold file not to be in the hot tier, got %v", err)
+	}
+
+	assertTiers := func(want map[string]string) {
+		t.Helper()
+		f, err := tfs.Get("a", "/docs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close() // nolint:errcheck
+		var dir charm.FileInfo
+		if err := json.NewDecoder(f).Decode(&dir); err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]string)
+		for _, fi := range dir.Files {
+			got[fi.Name] = fi.Tier
+		}
+		if len(got) != len(want) {
+			t.Fatalf("expected listing %v, got %v", want, got)
+		}
+		for name, tier := range want {
+			if got[name] != tier {
+				t.Fatalf("expected %s in tier %q, got %q", name, tier, got[name])
+			}
+		}
+	}
+	assertTiers(map[string]string{"notes.txt": "hot", "archive.tar": "cold"})
+
+	// Without a tier, updates stay in the tier the file is in.
+	if err := tfs.Put("a", "/docs/archive.tar", bytes.NewBufferString("archive v2"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if tier, err := tfs.Tier("a", "/docs/archive.tar"); err != nil || tier != "cold" {
+		t.Fatalf("expected the file to stay cold, got %q, %v", tier, err)
+	}
+
+	if err := tfs.MoveTier("a", "/docs/notes.txt", "cold"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hot.Stat("a", "/docs/notes.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected the file to be removed from the hot tier, got %v", err)
+	}
+	f, err := tfs.Get("a", "/docs/notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(f)
+	f.Close() // nolint:errcheck
+	if err != nil || string(data) != "notes" {
+		t.Fatalf("expected the moved file to be readable, got %q, %v", data, err)
+	}
+	assertTiers(map[string]string{"notes.txt": "cold", "archive.tar": "cold"})
+
+	if err := tfs.MoveTier("a", "/missing.txt", "hot"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist moving a missing file, got %v", err)
+	}
+	if err := tfs.Delete("a", "/docs"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tfs.Stat("a", "/docs"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected the directory to be deleted from every tier, got %v", err)
+	}
+}
+
+func TestTieredFileStoreMsgpackTier(t *testing.T) {
+	cold, err := localstorage.NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cold.ListingFormat = localstorage.ListingMsgpack
+	hot := memstorage.NewMemFileStore()
+	tfs, err := storage.NewTieredFileStore("hot", map[string]storage.FileStore{"hot": hot, "cold": cold})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tfs.PutTier("a", "/docs/archive.tar", "cold", bytes.NewBufferString("archive"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tfs.Put("a", "/docs/notes.txt", bytes.NewBufferString("notes"), fs.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	fis, err := storage.List(tfs, "a", "/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 2 || fis[0].Tier != "cold" || fis[1].Tier != "hot" {
+		t.Fatalf("expected the listing to merge both tiers, got %+v", fis)
+	}
+	if err := tfs.MoveTier("a", "/docs", "hot"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hot.Stat("a", "/docs/archive.tar"); err != nil {
+		t.Fatalf("expected the file to move out of the msgpack tier, got %v", err)
+	}
+}
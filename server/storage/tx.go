@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+)
+
+// ErrTxDone is used when a transaction is used after it was committed or
+// rolled back.
+var ErrTxDone = errors.New("transaction has already been committed or rolled back")
+
+// TxOpType is the kind of operation in a transaction.
+type TxOpType int
+
+// Transaction operation types.
+const (
+	TxPut TxOpType = iota
+	TxDelete
+	TxMove
+)
+
+// TxOp is an operation buffered in a transaction. For TxPut, Staged identifies
+// the staged data. For TxMove, Path is moved to To.
+type TxOp struct {
+	Type   TxOpType
+	Path   string
+	To     string
+	Mode   fs.FileMode
+	Staged string
+}
+
+// TxBackend is implemented by FileStores that support transactions.
+type TxBackend interface {
+	// StageTx stores the data of a pending write and returns an identifier
+	// for it.
+	StageTx(charmID string, r io.Reader) (string, error)
+	// CommitTx applies the operations in order, undoing the ones already
+	// applied if one fails.
+	CommitTx(charmID string, ops []TxOp) error
+	// DiscardTx removes the staged data of the operations.
+	DiscardTx(charmID string, ops []TxOp) error
+}
+
+// Tx is a set of writes, deletes and moves for a Charm ID that are applied
+// together on Commit or not at all. Writes are staged as they're added so
+// Commit only has to move them into place. Backends apply a transaction on a
+// best-effort basis: a failed Commit is rolled back, but other readers may see
+// some operations applied before others while Commit runs.
+type Tx struct {
+	charmID string
+	backend TxBackend
+	ops     []TxOp
+	done    bool
+}
+
+// NewTx returns a transaction for the Charm ID applied with the backend.
+func NewTx(charmID string, backend TxBackend) *Tx {
+	return &Tx{charmID: charmID, backend: backend}
+}
+
+// Put stages the data read from r to be stored at path on Commit.
+func (tx *Tx) Put(path string, r io.Reader, mode fs.FileMode) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	staged, err := tx.backend.StageTx(tx.charmID, r)
+	if err != nil {
+		return err
+	}
+	tx.ops = append(tx.ops, TxOp{Type: TxPut, Path: path, Mode: mode, Staged: staged})
+	return nil
+}
+
+// Delete deletes path on Commit.
+func (tx *Tx) Delete(path string) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.ops = append(tx.ops, TxOp{Type: TxDelete, Path: path})
+	return nil
+}
+
+// Move moves the file or directory at from to to on Commit.
+func (tx *Tx) Move(from, to string) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.ops = append(tx.ops, TxOp{Type: TxMove, Path: from, To: to})
+	return nil
+}
+
+// Commit applies the operations of the transaction.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	if err := tx.backend.CommitTx(tx.charmID, tx.ops); err != nil {
+		tx.backend.DiscardTx(tx.charmID, tx.ops) // nolint:errcheck
+		return err
+	}
+	return nil
+}
+
+// Rollback discards the operations of the transaction.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	return tx.backend.DiscardTx(tx.charmID, tx.ops)
+}